@@ -0,0 +1,184 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package detect reads the CPU quota applied to the calling process without
+// changing GOMAXPROCS. It's meant for libraries, such as observability
+// tooling, that want to know the CPU quota without the side effects of the
+// maxprocs package, which builds on it.
+package detect // import "go.uber.org/automaxprocs/detect"
+
+import (
+	"io/fs"
+
+	iruntime "go.uber.org/automaxprocs/internal/runtime"
+)
+
+// Status describes how a CPU quota or GOMAXPROCS value was determined.
+type Status = iruntime.CPUQuotaStatus
+
+const (
+	// Undefined means that no CPU quota applies to the process.
+	Undefined = iruntime.CPUQuotaUndefined
+	// Quota means that the value was determined from the CPU quota.
+	Quota = iruntime.CPUQuotaUsed
+	// MinUsed means that the CPU quota converted to a GOMAXPROCS value below
+	// the minimum, so the minimum was used instead.
+	MinUsed = iruntime.CPUQuotaMinUsed
+	// Shares means that no CPU quota is defined and the value was estimated
+	// from CPU shares (cgroups v1) or CPU weight (cgroups v2) instead.
+	Shares = iruntime.CPUQuotaSharesUsed
+	// CPUSet means that the cpuset of the process allows fewer CPUs than
+	// the CPU quota, or than the host has if there's none, so the value was
+	// determined from the number of CPUs in the cpuset instead.
+	CPUSet = iruntime.CPUQuotaCPUSetUsed
+)
+
+var (
+	// ErrCGroupsNotMounted is matched by errors caused by missing cgroup
+	// files, such as an invalid CPUCGroupPath. A process outside of any
+	// cgroup isn't an error; it merely has no CPU quota.
+	ErrCGroupsNotMounted = iruntime.ErrCGroupsNotMounted
+
+	// ErrCGroupsUnavailable is matched by errors caused by cgroup files that
+	// exist but can't be read, e.g. for lack of permissions.
+	ErrCGroupsUnavailable = iruntime.ErrCGroupsUnavailable
+)
+
+// ParseError reports CPU or memory limits that couldn't be parsed, e.g. a
+// malformed cgroup file. Use errors.As to tell it apart from missing or
+// inaccessible cgroups, as it usually points to a bug worth reporting.
+type ParseError = iruntime.ParseError
+
+// A Detector detects the CPU quota applied to the calling process. The zero
+// value reads process information from the procfs mounted at /proc and
+// ignores CPU shares.
+type Detector struct {
+	// ProcFS is the mount point of the procfs to read the process'
+	// `mountinfo` and `cgroup` files from. Defaults to /proc.
+	ProcFS string
+
+	// FS, if set, is the filesystem to read procfs and cgroup files from
+	// instead of the operating system's. Absolute paths, such as ProcFS,
+	// CPUCGroupPath and the mount points listed in mountinfo, are taken
+	// relative to its root, so a testdata tree mirroring `/proc` and
+	// `/sys/fs/cgroup`, or an fstest.MapFS, can stand in for a Linux host
+	// in tests. With FS, the CPU quota is read from cgroups on any OS.
+	FS fs.FS
+
+	// MaxReadSize, if positive, caps how many bytes are read from each
+	// procfs or cgroup file, as a guard against a corrupt or hostile procfs
+	// presenting an enormous mountinfo. Reading past the cap fails with an
+	// error matching ErrCGroupsUnavailable. It defaults to 4MiB, far above
+	// real files.
+	MaxReadSize int64
+
+	// PID, if set, is the process whose cgroups are read instead of the
+	// calling process', from `<ProcFS>/<PID>/cgroup` and
+	// `<ProcFS>/<PID>/mountinfo`. This suits helpers, such as sidecars or
+	// exec'd tools, that should size themselves after their parent.
+	PID int
+
+	// CPUCGroupPath, if set, is the directory of the CPU cgroup to read the
+	// cgroups v1 CPU quota from, e.g. /sys/fs/cgroup/cpu,cpuacct/docker/0123,
+	// bypassing procfs. This is an escape hatch for nested containers whose
+	// mount points can't be translated.
+	CPUCGroupPath string
+
+	// SharesFallback estimates the CPU quota from CPU shares (cgroups v1)
+	// or CPU weight (cgroups v2) when no CPU quota is defined.
+	SharesFallback bool
+
+	// PreferShares estimates the CPU quota from CPU shares or CPU weight,
+	// which Kubernetes derives from CPU requests, even when a CPU quota,
+	// derived from CPU limits, is defined. The quota still caps the
+	// estimate.
+	PreferShares bool
+
+	// Logger, if set, receives messages about the detection, such as falling
+	// back from cgroups v2 to v1 when only the latter holds a CPU quota.
+	Logger func(format string, args ...interface{})
+
+	// Cache, if set, keeps the cgroups located by the first detection, so
+	// that polling the CPU quota doesn't parse mountinfo every time. It's
+	// ignored with CPUCGroupPath or FS.
+	Cache *Cache
+}
+
+// A Cache keeps the cgroups of the calling process across detections. Mounts
+// rarely change; call Refresh when they do. The zero value is ready to use,
+// and a Cache is safe for concurrent use.
+type Cache = iruntime.Cache
+
+func (d Detector) runtime() iruntime.Detector {
+	return iruntime.Detector{
+		ProcFS:         d.ProcFS,
+		FS:             d.FS,
+		MaxReadSize:    d.MaxReadSize,
+		PID:            d.PID,
+		CPUCGroupPath:  d.CPUCGroupPath,
+		SharesFallback: d.SharesFallback,
+		PreferShares:   d.PreferShares,
+		Logger:         d.Logger,
+		Cache:          d.Cache,
+	}
+}
+
+// CPUQuota returns the CPU quota applied to the calling process in cores,
+// e.g. 1.5 for a quota of one and a half CPUs. The status is Quota, Shares or
+// CPUSet depending on where the quota comes from, or Undefined with a quota
+// of -1 if there is none. CPU quotas come from cgroups on Linux, from the CPU
+// rate cap of the job object on Windows, and from the AUTOMAXPROCS_CPU
+// environment variable on macOS; they aren't supported elsewhere.
+func (d Detector) CPUQuota() (float64, Status, error) {
+	return d.runtime().CPUQuota()
+}
+
+// GOMAXPROCS converts the CPU quota applied to the calling process to a
+// GOMAXPROCS value of at least minValue, like QuotaToGOMAXPROCS. If there is
+// no CPU quota, it returns -1 and Undefined.
+func (d Detector) GOMAXPROCS(minValue int, round func(v float64) int) (int, Status, error) {
+	return d.runtime().CPUQuotaToGOMAXPROCS(minValue, round)
+}
+
+// MemoryLimit returns the memory limit in bytes applied to the calling
+// process. The boolean is false if there is no memory limit. Memory limits
+// are only supported on Linux.
+func (d Detector) MemoryLimit() (uint64, bool, error) {
+	return d.runtime().MemoryLimit()
+}
+
+// IsGVisor reports whether the process appears to run inside the gVisor
+// sandbox, as told by the kernel version gVisor reports in
+// `<ProcFS>/version`, read from FS if set. gVisor may expose cgroup
+// information only partially, so missing cgroup files don't fail detection
+// under it. The check is best-effort, and always false on non-Linux systems
+// unless FS is set.
+func (d Detector) IsGVisor() bool {
+	return d.runtime().IsGVisor()
+}
+
+// QuotaToGOMAXPROCS converts a CPU quota in cores to a GOMAXPROCS value of at
+// least minValue, which counts as 1 if lower, so that the result can always
+// be passed to runtime.GOMAXPROCS. The quota is converted from float to int
+// using round; if round is nil, it is rounded down. The status is MinUsed if
+// the minimum was used and Quota otherwise.
+func QuotaToGOMAXPROCS(quota float64, minValue int, round func(v float64) int) (int, Status) {
+	return iruntime.QuotaToGOMAXPROCS(quota, minValue, round)
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package detect
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles writes the given files, keyed by their path relative to root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestDetector(t *testing.T) {
+	tests := []struct {
+		name           string
+		sharesFallback bool
+		// v2 mounts a cgroups v2 hierarchy at the test's cgroup directory
+		// instead of the cpu controller of cgroups v1. It's found the way
+		// hybrid systems are, since it isn't at /sys/fs/cgroup.
+		v2 bool
+		// files maps paths relative to the test's cgroup directory to their
+		// contents.
+		files      map[string]string
+		wantQuota  float64
+		wantStatus Status
+	}{
+		{
+			name: "v1 quota",
+			files: map[string]string{
+				"cpu.cfs_quota_us":  "250000\n",
+				"cpu.cfs_period_us": "100000\n",
+			},
+			wantQuota:  2.5,
+			wantStatus: Quota,
+		},
+		{
+			name: "v1 fractional quota",
+			files: map[string]string{
+				"cpu.cfs_quota_us":  "50000\n",
+				"cpu.cfs_period_us": "100000\n",
+			},
+			wantQuota:  0.5,
+			wantStatus: Quota,
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"cpu.cfs_quota_us":  "-1\n",
+				"cpu.cfs_period_us": "100000\n",
+				"cpu.shares":        "2048\n",
+			},
+			wantQuota:  -1,
+			wantStatus: Undefined,
+		},
+		{
+			name:           "v1 unlimited with shares fallback",
+			sharesFallback: true,
+			files: map[string]string{
+				"cpu.cfs_quota_us":  "-1\n",
+				"cpu.cfs_period_us": "100000\n",
+				"cpu.shares":        "2048\n",
+			},
+			wantQuota:  2,
+			wantStatus: Shares,
+		},
+		{
+			name:           "v1 quota with shares fallback",
+			sharesFallback: true,
+			files: map[string]string{
+				"cpu.cfs_quota_us":  "400000\n",
+				"cpu.cfs_period_us": "100000\n",
+				"cpu.shares":        "2048\n",
+			},
+			wantQuota:  4,
+			wantStatus: Quota,
+		},
+		{
+			name:       "v2 quota",
+			v2:         true,
+			files:      map[string]string{"cpu.max": "250000 100000\n"},
+			wantQuota:  2.5,
+			wantStatus: Quota,
+		},
+		{
+			name:       "v2 fractional quota",
+			v2:         true,
+			files:      map[string]string{"cpu.max": "50000 100000\n"},
+			wantQuota:  0.5,
+			wantStatus: Quota,
+		},
+		{
+			name:       "v2 unlimited",
+			v2:         true,
+			files:      map[string]string{"cpu.max": "max 100000\n"},
+			wantQuota:  -1,
+			wantStatus: Undefined,
+		},
+		{
+			name:       "v2 without cpu.max",
+			v2:         true,
+			wantQuota:  -1,
+			wantStatus: Undefined,
+		},
+		{
+			name: "v2 cpuset below quota",
+			v2:   true,
+			files: map[string]string{
+				"cpu.max":               "400000 100000\n",
+				"cpuset.cpus.effective": "0-1\n",
+			},
+			wantQuota:  2,
+			wantStatus: CPUSet,
+		},
+		{
+			name: "v2 quota below cpuset",
+			v2:   true,
+			files: map[string]string{
+				"cpu.max":               "150000 100000\n",
+				"cpuset.cpus.effective": "0-3\n",
+			},
+			wantQuota:  1.5,
+			wantStatus: Quota,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			cpuDir := filepath.Join(root, "cgroup")
+			writeFiles(t, cpuDir, tt.files)
+
+			mountInfo := "31 23 0:24 / " + cpuDir + " rw,nosuid,nodev,noexec,relatime shared:1 - cgroup cgroup rw,cpu\n"
+			cgroup := "1:cpu:/\n"
+			if tt.v2 {
+				mountInfo = "29 22 0:26 / " + cpuDir + " rw,nosuid,nodev,noexec,relatime shared:4 - cgroup2 cgroup2 rw,nsdelegate\n"
+				cgroup = "0::/\n"
+			}
+			procFS := filepath.Join(root, "proc")
+			writeFiles(t, procFS, map[string]string{
+				"self/mountinfo": mountInfo,
+				"self/cgroup":    cgroup,
+			})
+
+			quota, status, err := Detector{ProcFS: procFS, SharesFallback: tt.sharesFallback}.CPUQuota()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantQuota, quota, "quota")
+			assert.Equal(t, tt.wantStatus, status, "status")
+		})
+	}
+}
+
+func TestDetectorMissingProcFS(t *testing.T) {
+	quota, status, err := Detector{ProcFS: filepath.Join(t.TempDir(), "missing")}.CPUQuota()
+	assert.ErrorIs(t, err, ErrCGroupsNotMounted, "a wrong ProcFS shouldn't pass for bare metal")
+	assert.Equal(t, -1.0, quota)
+	assert.Equal(t, Undefined, status)
+}
+
+func TestDetectorGOMAXPROCS(t *testing.T) {
+	root := t.TempDir()
+	cpuDir := filepath.Join(root, "cgroup")
+	procFS := filepath.Join(root, "proc")
+	writeFiles(t, cpuDir, map[string]string{
+		"cpu.cfs_quota_us":  "50000\n",
+		"cpu.cfs_period_us": "100000\n",
+	})
+	writeFiles(t, procFS, map[string]string{
+		"self/mountinfo": "31 23 0:24 / " + cpuDir + " rw,nosuid,nodev,noexec,relatime shared:1 - cgroup cgroup rw,cpu\n",
+		"self/cgroup":    "1:cpu:/\n",
+	})
+
+	maxProcs, status, err := Detector{ProcFS: procFS}.GOMAXPROCS(1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, maxProcs)
+	assert.Equal(t, MinUsed, status)
+
+	maxProcs, status, err = Detector{ProcFS: procFS}.GOMAXPROCS(0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, maxProcs, "a minimum of 0 should count as 1")
+	assert.Equal(t, MinUsed, status)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package detect
+
+import (
+	"math"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotaToGOMAXPROCS(t *testing.T) {
+	ceil := func(v float64) int { return int(math.Ceil(v)) }
+
+	tests := []struct {
+		name       string
+		quota      float64
+		minValue   int
+		round      func(float64) int
+		want       int
+		wantStatus Status
+	}{
+		{name: "whole", quota: 4, minValue: 1, want: 4, wantStatus: Quota},
+		{name: "fraction rounded down", quota: 2.5, minValue: 1, want: 2, wantStatus: Quota},
+		{name: "fraction rounded up", quota: 2.5, minValue: 1, round: ceil, want: 3, wantStatus: Quota},
+		{name: "below min", quota: 0.5, minValue: 1, want: 1, wantStatus: MinUsed},
+		{name: "below custom min", quota: 3, minValue: 4, want: 4, wantStatus: MinUsed},
+		{name: "no min", quota: 0.5, want: 1, wantStatus: MinUsed},
+		{name: "negative min", quota: 0.5, minValue: -2, want: 1, wantStatus: MinUsed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, status := QuotaToGOMAXPROCS(tt.quota, tt.minValue, tt.round)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantStatus, status)
+		})
+	}
+}
+
+func TestDetectorFS(t *testing.T) {
+	// The cgroups are read from FS on any OS, not just Linux.
+	v2 := func(files map[string]string) fstest.MapFS {
+		fsys := fstest.MapFS{
+			"proc/self/mountinfo": {Data: []byte("29 22 0:26 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:4 - cgroup2 cgroup2 rw,nsdelegate\n")},
+			"proc/self/cgroup":    {Data: []byte("0::/kubepods/pod1\n")},
+		}
+		for name, data := range files {
+			fsys["sys/fs/cgroup/kubepods/pod1/"+name] = &fstest.MapFile{Data: []byte(data)}
+		}
+		return fsys
+	}
+
+	t.Run("v2", func(t *testing.T) {
+		d := Detector{FS: v2(map[string]string{
+			"cpu.max":    "250000 100000\n",
+			"memory.max": "1073741824\n",
+		})}
+
+		quota, status, err := d.CPUQuota()
+		require.NoError(t, err)
+		assert.Equal(t, Quota, status)
+		assert.Equal(t, 2.5, quota)
+
+		procs, status, err := d.GOMAXPROCS(1, nil)
+		require.NoError(t, err)
+		assert.Equal(t, Quota, status)
+		assert.Equal(t, 2, procs)
+
+		limit, defined, err := d.MemoryLimit()
+		require.NoError(t, err)
+		assert.True(t, defined)
+		assert.Equal(t, uint64(1<<30), limit)
+	})
+
+	t.Run("v2 cpuset", func(t *testing.T) {
+		fsys := v2(map[string]string{
+			"cpu.max":               "400000 100000\n",
+			"cpuset.cpus.effective": "0-3\n",
+		})
+		fsys["sys/devices/system/cpu/isolated"] = &fstest.MapFile{Data: []byte("2-3\n")}
+
+		quota, status, err := Detector{FS: fsys}.CPUQuota()
+		require.NoError(t, err)
+		assert.Equal(t, CPUSet, status)
+		assert.Equal(t, 2.0, quota, "isolated CPUs should be left out")
+	})
+
+	t.Run("v1", func(t *testing.T) {
+		d := Detector{
+			FS: fstest.MapFS{
+				"proc/self/mountinfo": {Data: []byte("31 23 0:24 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:1 - cgroup cgroup rw,cpu,cpuacct\n")},
+				"proc/self/cgroup":    {Data: []byte("3:cpu,cpuacct:/docker/0123\n")},
+				"sys/fs/cgroup/cpu,cpuacct/docker/0123/cpu.cfs_quota_us":  {Data: []byte("150000\n")},
+				"sys/fs/cgroup/cpu,cpuacct/docker/0123/cpu.cfs_period_us": {Data: []byte("100000\n")},
+			},
+		}
+
+		quota, status, err := d.CPUQuota()
+		require.NoError(t, err)
+		assert.Equal(t, Quota, status)
+		assert.Equal(t, 1.5, quota)
+	})
+
+	t.Run("CPUCGroupPath", func(t *testing.T) {
+		d := Detector{
+			FS: fstest.MapFS{
+				"cpu/cpu.cfs_quota_us":  {Data: []byte("300000\n")},
+				"cpu/cpu.cfs_period_us": {Data: []byte("100000\n")},
+			},
+			CPUCGroupPath: "/cpu",
+		}
+
+		quota, status, err := d.CPUQuota()
+		require.NoError(t, err)
+		assert.Equal(t, Quota, status)
+		assert.Equal(t, 3.0, quota)
+	})
+
+	t.Run("missing procfs", func(t *testing.T) {
+		d := Detector{FS: fstest.MapFS{}}
+		assert.False(t, d.IsGVisor())
+		_, _, err := d.CPUQuota()
+		assert.ErrorIs(t, err, ErrCGroupsNotMounted)
+	})
+
+	t.Run("gVisor", func(t *testing.T) {
+		d := Detector{
+			ProcFS: "/host/proc",
+			FS: fstest.MapFS{
+				"host/proc/version": {Data: []byte("Linux version 4.4.0 #1 SMP Sun Jan 10 15:06:54 PST 2016\n")},
+			},
+		}
+		assert.True(t, d.IsGVisor())
+
+		quota, status, err := d.CPUQuota()
+		require.NoError(t, err, "missing cgroup files are expected under gVisor")
+		assert.Equal(t, Undefined, status)
+		assert.Equal(t, -1.0, quota)
+	})
+}
@@ -18,22 +18,40 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-//go:build linux
-// +build linux
-
 package cgroups
 
 import (
-	"bufio"
+	"errors"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
+)
+
+const (
+	// _cgroupValueMax is written by cgroups v2 for unlimited values.
+	_cgroupValueMax = "max"
+	// _cgroupValueUnlimited is written by cgroups v1 for unlimited values.
+	_cgroupValueUnlimited = "-1"
+
+	// _utf8BOM is the UTF-8 byte order mark, which some tools prepend to
+	// files they write.
+	_utf8BOM = "\ufeff"
 )
 
+// ErrUnlimited indicates that a cgroup parameter holds no limit, i.e. it is
+// set to `max` (cgroups v2) or `-1` (cgroups v1).
+var ErrUnlimited = errors.New("cgroup value is unlimited")
+
 // CGroup represents the data structure for a Linux control group.
 type CGroup struct {
 	path string
+	// fsys, if set, is the filesystem the params are read from, with path
+	// taken relative to its root.
+	fsys fs.FS
 }
 
 // NewCGroup returns a new *CGroup from a given path.
@@ -51,17 +69,83 @@ func (cg *CGroup) ParamPath(param string) string {
 	return filepath.Join(cg.path, param)
 }
 
+// open opens a cgroup param file for reading.
+func (cg *CGroup) open(param string) (fs.File, error) {
+	return openFile(cg.fsys, cg.ParamPath(param))
+}
+
+// openFile opens the file at the absolute path name, from fsys if set or
+// from the operating system otherwise. Reads are capped, see LimitReads.
+func openFile(fsys fs.FS, name string) (fs.File, error) {
+	fsys, maxReadSize := unwrapLimit(fsys)
+	if fsys == nil {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return limitReads(f, name, maxReadSize), nil
+	}
+
+	f, err := fsys.Open(fsPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return limitReads(f, name, maxReadSize), nil
+}
+
+// Stat returns the file info of the file at the absolute path name, from fsys
+// if set or from the operating system otherwise.
+func Stat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	fsys, _ = unwrapLimit(fsys)
+	if fsys == nil {
+		return os.Stat(name)
+	}
+	return fs.Stat(fsys, fsPath(name))
+}
+
+// ReadFile reads the file at the absolute path name, from fsys if set or from
+// the operating system otherwise, with reads capped like those of cgroup
+// files, see LimitReads.
+func ReadFile(fsys fs.FS, name string) ([]byte, error) {
+	f, err := openFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// fsPath converts the absolute path name to the path of the same file in an
+// fs.FS standing in for the root of the filesystem.
+func fsPath(name string) string {
+	rel := strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
 // readFirstLine reads the first line from a cgroup param file.
 func (cg *CGroup) readFirstLine(param string) (string, error) {
-	paramFile, err := os.Open(cg.ParamPath(param))
+	paramFile, err := cg.open(param)
 	if err != nil {
 		return "", err
 	}
 	defer paramFile.Close()
 
-	scanner := bufio.NewScanner(paramFile)
+	return readFirstLine(paramFile)
+}
+
+// readFirstLine reads the first line from r, trimmed with trimValue.
+func readFirstLine(r io.Reader) (string, error) {
+	scanner, buf := newScanner(r)
+	defer releaseScanBuffer(buf)
 	if scanner.Scan() {
-		return scanner.Text(), nil
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return trimValue(scanner.Text()), nil
 	}
 	if err := scanner.Err(); err != nil {
 		return "", err
@@ -69,11 +153,59 @@ func (cg *CGroup) readFirstLine(param string) (string, error) {
 	return "", io.ErrUnexpectedEOF
 }
 
+// trimValue strips a leading byte order mark and surrounding whitespace from
+// a line read from a cgroup param file.
+func trimValue(line string) string {
+	return strings.TrimSpace(strings.TrimPrefix(line, _utf8BOM))
+}
+
 // readInt parses the first line from a cgroup param file as int.
 func (cg *CGroup) readInt(param string) (int, error) {
 	text, err := cg.readFirstLine(param)
 	if err != nil {
 		return 0, err
 	}
-	return strconv.Atoi(text)
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, cg.parseError(param, text, err)
+	}
+	return n, nil
+}
+
+// parseError reports that text, the first line of a cgroup param file,
+// couldn't be parsed.
+func (cg *CGroup) parseError(param, text string, err error) error {
+	return &parseError{path: cg.ParamPath(param), line: 1, content: text, err: err}
+}
+
+// ReadUint64File reads a single unsigned integer from the cgroup parameter
+// file at relPath, a path in the cgroup hierarchy of mountPoint such as
+// `docker/0123456789abcdef/pids.max`. The path is resolved with Translate.
+// If the file holds no limit, ErrUnlimited is returned.
+func ReadUint64File(mountPoint *MountPoint, relPath string) (uint64, error) {
+	paramPath, err := mountPoint.Translate(path.Join("/", relPath))
+	if err != nil {
+		return 0, err
+	}
+
+	dir, param := filepath.Split(paramPath)
+	cgroup := NewCGroup(dir)
+	text, err := cgroup.readFirstLine(param)
+	if err != nil {
+		return 0, err
+	}
+	value, err := parseUint64Value(text)
+	if err != nil && !errors.Is(err, ErrUnlimited) {
+		return 0, cgroup.parseError(param, text, err)
+	}
+	return value, err
+}
+
+// parseUint64Value parses the contents of a single-value cgroup parameter.
+func parseUint64Value(text string) (uint64, error) {
+	text = strings.TrimSpace(text)
+	if text == _cgroupValueMax || text == _cgroupValueUnlimited {
+		return 0, ErrUnlimited
+	}
+	return strconv.ParseUint(text, 10, 64)
 }
@@ -125,3 +125,68 @@ func TestCGroupReadInt(t *testing.T) {
 		}
 	}
 }
+
+func TestReadUint64File(t *testing.T) {
+	mountPoint := &MountPoint{
+		Root:       "/kubepods",
+		MountPoint: filepath.Join(testDataCGroupsPath, "values"),
+	}
+
+	testTable := []struct {
+		name          string
+		relPath       string
+		expectedValue uint64
+		expectedErr   string
+	}{
+		{
+			name:          "value",
+			relPath:       "kubepods/docker/pids.max",
+			expectedValue: 4096,
+		},
+		{
+			name:          "absolute",
+			relPath:       "/kubepods/docker/pids.max",
+			expectedValue: 4096,
+		},
+		{
+			name:        "max",
+			relPath:     "kubepods/docker/memory.max",
+			expectedErr: ErrUnlimited.Error(),
+		},
+		{
+			name:        "unlimited",
+			relPath:     "kubepods/docker/memory.limit_in_bytes",
+			expectedErr: ErrUnlimited.Error(),
+		},
+		{
+			name:        "malformed",
+			relPath:     "kubepods/docker/io.weight",
+			expectedErr: `parsing "12abc": invalid syntax`,
+		},
+		{
+			name:        "absent",
+			relPath:     "kubepods/docker/cpu.max",
+			expectedErr: "no such file or directory",
+		},
+		{
+			name:        "not exposed",
+			relPath:     "system.slice/pids.max",
+			expectedErr: "is not a descendant of mount point root",
+		},
+	}
+
+	for _, tt := range testTable {
+		value, err := ReadUint64File(mountPoint, tt.relPath)
+		if tt.expectedErr != "" {
+			if assert.Error(t, err, tt.name) {
+				assert.Contains(t, err.Error(), tt.expectedErr, tt.name)
+			}
+			continue
+		}
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.expectedValue, value, tt.name)
+	}
+
+	_, err := ReadUint64File(mountPoint, "kubepods/docker/memory.max")
+	assert.ErrorIs(t, err, ErrUnlimited)
+}
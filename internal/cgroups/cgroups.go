@@ -18,15 +18,24 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-//go:build linux
-// +build linux
-
 package cgroups
 
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
 const (
 	// _cgroupFSType is the Linux CGroup file system type used in
 	// `/proc/$PID/mountinfo`.
 	_cgroupFSType = "cgroup"
+	// _lxcfsFSType is the file system type of the cgroup views LXCFS
+	// mounts into legacy LXC containers, see addLXCFSMount.
+	_lxcfsFSType = "fuse.lxcfs"
 	// _cgroupSubsysCPU is the CPU CGroup subsystem.
 	_cgroupSubsysCPU = "cpu"
 	// _cgroupSubsysCPUAcct is the CPU accounting CGroup subsystem.
@@ -42,11 +51,33 @@ const (
 	// _cgroupCPUCFSPeriodUsParam is the file name for the CGroup CFS period
 	// parameter.
 	_cgroupCPUCFSPeriodUsParam = "cpu.cfs_period_us"
+	// _cgroupCPUSharesParam is the file name for the CGroup CPU shares
+	// parameter.
+	_cgroupCPUSharesParam = "cpu.shares"
+	// _cgroupCPUSetCPUsParam is the file name for the CGroup cpuset
+	// configured by the user.
+	_cgroupCPUSetCPUsParam = "cpuset.cpus"
+	// _cgroupCPUSetEffectiveCPUsParam is the file name for the CGroup cpuset
+	// actually granted, which kernels mounting the cpuset controller in v2
+	// mode expose.
+	_cgroupCPUSetEffectiveCPUsParam = "cpuset.effective_cpus"
+
+	// _cgroupCPUSharesPerCPU is the amount of CPU shares that container
+	// runtimes assign per requested CPU.
+	_cgroupCPUSharesPerCPU = 1024
+	// _cgroupCPUSharesMin and _cgroupCPUSharesMax bound the values
+	// `cpu.shares` may hold.
+	_cgroupCPUSharesMin = 2
+	_cgroupCPUSharesMax = 262144
 )
 
 const (
 	_procPathCGroup    = "/proc/self/cgroup"
 	_procPathMountInfo = "/proc/self/mountinfo"
+
+	_procSelf      = "self"
+	_procCGroup    = "cgroup"
+	_procMountInfo = "mountinfo"
 )
 
 // CGroups is a map that associates each CGroup with its subsystem name.
@@ -56,13 +87,42 @@ type CGroups map[string]*CGroup
 // under for some process under `/proc` file system (see also proc(5) for more
 // information).
 func NewCGroups(procPathMountInfo, procPathCGroup string) (CGroups, error) {
-	cgroupSubsystems, err := parseCGroupSubsystems(procPathCGroup)
+	return newCGroupsFS(nil, procPathMountInfo, procPathCGroup)
+}
+
+// NewCGroupsFS returns a new CGroups for the process whose `mountinfo` and
+// `cgroup` files are at `proc/self` in fsys, reading its cgroup params from
+// fsys as well. Absolute paths such as mount points are taken relative to
+// the root of fsys, so a `testdata` tree mirroring `/proc` and
+// `/sys/fs/cgroup` can stand in for a real Linux host.
+func NewCGroupsFS(fsys fs.FS) (CGroups, error) {
+	return newCGroupsFS(fsys, _procPathMountInfo, _procPathCGroup)
+}
+
+// newCGroupsFS builds CGroups reading from fsys, or from the operating
+// system if fsys is nil.
+func newCGroupsFS(fsys fs.FS, procPathMountInfo, procPathCGroup string) (CGroups, error) {
+	cgroupSubsystems, err := parseCGroupSubsystemsFS(fsys, procPathCGroup)
 	if err != nil {
 		return nil, err
 	}
 
-	cgroups := make(CGroups)
+	var (
+		cgroups = make(CGroups)
+		// A subsystem may be mounted more than once with different roots
+		// (e.g. bind mounts). Track the root of the mount each cgroup was
+		// translated from so that the most specific one wins.
+		roots = make(map[string]string)
+		// Subsystems whose mounts couldn't be translated, in the order
+		// they were encountered.
+		untranslatable []string
+		translateErrs  = make(map[string]error)
+	)
 	newMountPoint := func(mp *MountPoint) error {
+		if mp.FSType == _lxcfsFSType {
+			addLXCFSMount(cgroups, fsys, mp, cgroupSubsystems)
+			return nil
+		}
 		if mp.FSType != _cgroupFSType {
 			return nil
 		}
@@ -72,47 +132,348 @@ func NewCGroups(procPathMountInfo, procPathCGroup string) (CGroups, error) {
 			if !exists {
 				continue
 			}
+			if root, found := roots[opt]; found && len(root) >= len(mp.Root) {
+				continue
+			}
 
-			cgroupPath, err := mp.Translate(subsys.Name)
+			cgroupPath, err := translateCGroupPath(mp, subsys.Name)
 			if err != nil {
-				return err
+				if _, seen := translateErrs[opt]; !seen {
+					untranslatable = append(untranslatable, opt)
+					translateErrs[opt] = err
+				}
+				continue
 			}
-			cgroups[opt] = NewCGroup(cgroupPath)
+			cgroups[opt] = &CGroup{path: cgroupPath, fsys: fsys}
+			roots[opt] = mp.Root
 		}
 
 		return nil
 	}
 
-	if err := parseMountInfo(procPathMountInfo, newMountPoint); err != nil {
+	if err := parseMountInfoFS(fsys, procPathMountInfo, newMountPoint); err != nil {
 		return nil, err
 	}
+
+	for _, opt := range untranslatable {
+		if _, found := cgroups[opt]; !found {
+			return nil, translateErrs[opt]
+		}
+	}
 	return cgroups, nil
 }
 
+// addLXCFSMount adds the cgroups of the subsystems mp holds to cgroups,
+// unless a cgroup mount provides them already.
+//
+// LXC containers without a cgroup namespace, as set up by LXC 1.x and 2.x
+// with LXCFS, don't mount cgroup file systems. Instead, LXCFS bind-mounts a
+// FUSE view of each hierarchy of the host, e.g.
+//
+//	40 35 0:41 /cpu,cpuacct /sys/fs/cgroup/cpu,cpuacct rw,relatime - fuse.lxcfs lxcfs rw,user_id=0,group_id=0
+//
+// naming the controllers in the mount root rather than in the super
+// options. The view mirrors the host's hierarchy, so the cgroup listed in
+// `/proc/$PID/cgroup`, e.g. `/lxc/c1`, lies under the mount point as is:
+// `/sys/fs/cgroup/cpu,cpuacct/lxc/c1/cpu.cfs_quota_us`.
+func addLXCFSMount(cgroups CGroups, fsys fs.FS, mp *MountPoint, subsystems map[string]*CGroupSubsys) {
+	controllers := strings.TrimPrefix(mp.Root, "/")
+	if controllers == "" || strings.Contains(controllers, "/") {
+		return
+	}
+
+	for rest, more := controllers, true; more; {
+		var opt string
+		opt, rest, more = strings.Cut(rest, _cgroupSubsysSep)
+		subsys, exists := subsystems[opt]
+		if !exists {
+			continue
+		}
+		if _, found := cgroups[opt]; found {
+			continue
+		}
+		cgroups[opt] = &CGroup{path: filepath.Join(mp.MountPoint, subsys.Name), fsys: fsys}
+	}
+}
+
 // NewCGroupsForCurrentProcess returns a new *CGroups instance for the current
 // process.
 func NewCGroupsForCurrentProcess() (CGroups, error) {
 	return NewCGroups(_procPathMountInfo, _procPathCGroup)
 }
 
+// translateCGroupPath returns the path of the cgroup at cgroupPath within the
+// given mount point. In a cgroup namespace, the process sees its own cgroup as
+// the namespace root "/", while the mount may still report the root of the
+// cgroup on the host. The cgroup is then the mount point itself.
+func translateCGroupPath(mp *MountPoint, cgroupPath string) (string, error) {
+	if cgroupPath == "/" {
+		return mp.MountPoint, nil
+	}
+	return mp.Translate(cgroupPath)
+}
+
+// NewCGroupsForPath returns a new CGroups whose CPU controller reads
+// `cpu.cfs_quota_us` and `cpu.cfs_period_us` directly from the directory
+// cpuPath, bypassing the translation of mount points. This is an escape hatch
+// for nested containers where the caller knows better where the CPU
+// controller is mounted. It fails if either file is missing from cpuPath.
+//
+// If fsys is set, cpuPath is read from it, see NewCGroupsFS.
+func NewCGroupsForPath(fsys fs.FS, cpuPath string) (CGroups, error) {
+	cgroup := &CGroup{path: cpuPath, fsys: fsys}
+	for _, param := range []string{_cgroupCPUCFSQuotaUsParam, _cgroupCPUCFSPeriodUsParam} {
+		if _, err := Stat(fsys, cgroup.ParamPath(param)); err != nil {
+			// Don't wrap err: a missing file is a configuration error here,
+			// not a sign that the process runs outside of a cgroup.
+			return nil, fmt.Errorf("no %v in %v: %v", param, cpuPath, err)
+		}
+	}
+	return CGroups{_cgroupSubsysCPU: cgroup}, nil
+}
+
+// NewCGroupsForProcFS returns a new *CGroups instance for the current
+// process, reading its `mountinfo` and `cgroup` files from the procfs
+// mounted at procFS rather than `/proc`.
+func NewCGroupsForProcFS(procFS string) (CGroups, error) {
+	return NewCGroupsForPID(nil, procFS, 0)
+}
+
+// NewCGroupsForPID is like NewCGroupsForProcFS, but returns the cgroups of
+// the process with the given PID instead, reading `<pid>/mountinfo` and
+// `<pid>/cgroup` under procFS. A pid of 0 stands for the current process.
+// If fsys is set, procFS and the cgroup files are read from it, see
+// NewCGroupsFS.
+func NewCGroupsForPID(fsys fs.FS, procFS string, pid int) (CGroups, error) {
+	procPathMountInfo, procPathCGroup := procPaths(procFS, pid)
+	return newCGroupsFS(fsys, procPathMountInfo, procPathCGroup)
+}
+
+// CGroupPathForProcFS returns the cgroup the current process belongs to,
+// as listed in the `cgroup` file read from the procfs mounted at procFS. It
+// is the cgroup of the CPU controller on cgroups v1 and that of the unified
+// hierarchy on cgroups v2. If there's neither, it returns "".
+func CGroupPathForProcFS(procFS string) (string, error) {
+	_, procPathCGroup := procPaths(procFS, 0)
+	subsystems, err := parseCGroupSubsystems(procPathCGroup)
+	if err != nil {
+		return "", err
+	}
+
+	if subsys, found := subsystems[_cgroupSubsysCPU]; found {
+		return subsys.Name, nil
+	}
+	for _, subsys := range subsystems {
+		if subsys.ID == 0 {
+			return subsys.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// CGroupPathsForProcFS returns the cgroup the current process belongs to
+// for each controller listed in the `cgroup` file read from the procfs
+// mounted at procFS, e.g. "cpu" and "cpuacct" for a `4:cpu,cpuacct:/docker`
+// line on cgroups v1. The unified hierarchy of cgroups v2 is listed under
+// the empty controller name.
+func CGroupPathsForProcFS(procFS string) (map[string]string, error) {
+	_, procPathCGroup := procPaths(procFS, 0)
+	subsystems, err := parseCGroupSubsystems(procPathCGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]string, len(subsystems))
+	for name, subsys := range subsystems {
+		paths[name] = subsys.Name
+	}
+	return paths, nil
+}
+
+// ValidateCPUQuotaDir checks that dir is a cgroup directory holding a
+// readable CPU quota, in the layout of either cgroups v1 (`cpu.cfs_quota_us`
+// and `cpu.cfs_period_us`) or cgroups v2 (`cpu.max`).
+func ValidateCPUQuotaDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+
+	cgroup := NewCGroup(dir)
+	if _, err := os.Stat(cgroup.ParamPath(_cgroupv2CPUMax)); err == nil {
+		_, _, err := (&CGroups2{mountPoint: dir, groupPath: "/", cpuMaxFile: _cgroupv2CPUMax}).CPUQuota()
+		return err
+	}
+	if _, err := os.Stat(cgroup.ParamPath(_cgroupCPUCFSQuotaUsParam)); err == nil {
+		if _, err := cgroup.readInt(_cgroupCPUCFSPeriodUsParam); err != nil {
+			return err
+		}
+		_, _, err := CGroups{_cgroupSubsysCPU: cgroup}.CPUQuota()
+		return err
+	}
+	return fmt.Errorf("no %v or %v in %v", _cgroupv2CPUMax, _cgroupCPUCFSQuotaUsParam, dir)
+}
+
+// procPaths returns the paths of the `mountinfo` and `cgroup` files of the
+// process with the given PID under the procfs mounted at procFS, or of the
+// current process if pid is 0.
+func procPaths(procFS string, pid int) (procPathMountInfo, procPathCGroup string) {
+	dir := _procSelf
+	if pid != 0 {
+		dir = strconv.Itoa(pid)
+	}
+	return filepath.Join(procFS, dir, _procMountInfo),
+		filepath.Join(procFS, dir, _procCGroup)
+}
+
 // CPUQuota returns the CPU quota applied with the CPU cgroup controller.
 // It is a result of `cpu.cfs_quota_us / cpu.cfs_period_us`. If the value of
 // `cpu.cfs_quota_us` was not set (-1), the method returns `(-1, nil)`.
 func (cg CGroups) CPUQuota() (float64, bool, error) {
+	quota, period, defined, err := cg.CPUQuotaPeriod()
+	if !defined || err != nil {
+		return -1, defined, err
+	}
+	return float64(quota) / float64(period), true, nil
+}
+
+// CPUQuotaPeriod returns the raw values of `cpu.cfs_quota_us` and
+// `cpu.cfs_period_us`, in microseconds. If no CPU quota is set, the method
+// returns `(-1, -1, false, nil)`. If a quota is set but the period isn't
+// positive, it fails with an error matching ErrInvalidPeriod.
+func (cg CGroups) CPUQuotaPeriod() (int, int, bool, error) {
 	cpuCGroup, exists := cg[_cgroupSubsysCPU]
 	if !exists {
-		return -1, false, nil
+		return -1, -1, false, nil
 	}
 
 	cfsQuotaUs, err := cpuCGroup.readInt(_cgroupCPUCFSQuotaUsParam)
 	if defined := cfsQuotaUs > 0; err != nil || !defined {
-		return -1, defined, err
+		return -1, -1, defined, err
 	}
 
 	cfsPeriodUs, err := cpuCGroup.readInt(_cgroupCPUCFSPeriodUsParam)
-	if defined := cfsPeriodUs > 0; err != nil || !defined {
-		return -1, defined, err
+	if err != nil {
+		return -1, -1, false, err
+	}
+	if cfsPeriodUs <= 0 {
+		return -1, -1, false, cpuCGroup.parseError(_cgroupCPUCFSPeriodUsParam, strconv.Itoa(cfsPeriodUs), ErrInvalidPeriod)
+	}
+
+	return cfsQuotaUs, cfsPeriodUs, true, nil
+}
+
+// Version returns 1, the version of cgroups read by CGroups.
+func (cg CGroups) Version() int {
+	return 1
+}
+
+// CPUSet returns the number of CPUs the cpuset cgroup controller allows the
+// process to run on, as listed in `cpuset.effective_cpus` if present or
+// `cpuset.cpus` otherwise, e.g. 5 for `0-3,8`. Like with cgroups v2, CPUs
+// isolated with the isolcpus boot parameter are left out, unless the cpuset
+// holds isolated CPUs only. If the cpuset is unavailable, the method returns
+// `(-1, false, nil)`.
+func (cg CGroups) CPUSet() (int, bool, error) {
+	cpusetCGroup, exists := cg[_cgroupSubsysCPUSet]
+	if !exists {
+		return -1, false, nil
+	}
+
+	isolated, err := readIsolatedCPUs(cpusetCGroup.fsys, _sysPathCPUIsolated)
+	if err != nil {
+		return -1, false, err
+	}
+	return readCPUSet(cpusetCGroup, isolated, _cgroupCPUSetEffectiveCPUsParam, _cgroupCPUSetCPUsParam)
+}
+
+// NrThrottled returns the number of CFS periods in which the CPU cgroup has
+// been throttled, as reported by `nr_throttled` in `cpu.stat`. The counter
+// only grows, so callers compare two readings to detect recent throttling.
+// If the counter is unavailable, the method returns `(0, false, nil)`.
+func (cg CGroups) NrThrottled() (uint64, bool, error) {
+	cpuCGroup, exists := cg[_cgroupSubsysCPU]
+	if !exists {
+		return 0, false, nil
+	}
+
+	stat, defined, err := readCPUStat(cpuCGroup)
+	return stat.NrThrottled, defined, err
+}
+
+// CPUStat returns the CFS throttling counters of the CPU cgroup, as reported
+// in `cpu.stat`. If they are unavailable, the method returns
+// `(CPUStat{}, false, nil)`.
+func (cg CGroups) CPUStat() (CPUStat, bool, error) {
+	cpuCGroup, exists := cg[_cgroupSubsysCPU]
+	if !exists {
+		return CPUStat{}, false, nil
+	}
+
+	return readCPUStat(cpuCGroup)
+}
+
+// ProcessCount returns the number of processes in the CPU cgroup, as listed
+// in `cgroup.procs`. Processes other than the caller compete for the same
+// CPU quota. If the list is unavailable, the method returns `(0, false, nil)`.
+func (cg CGroups) ProcessCount() (int, bool, error) {
+	cpuCGroup, exists := cg[_cgroupSubsysCPU]
+	if !exists {
+		return 0, false, nil
+	}
+
+	return readProcessCount(cpuCGroup)
+}
+
+// MemoryLimit returns the memory limit in bytes applied with the memory
+// cgroup controller. If no limit is set, it returns (0, false, nil).
+func (cg CGroups) MemoryLimit() (uint64, bool, error) {
+	memCGroup, exists := cg[_cgroupSubsysMemory]
+	if !exists {
+		return 0, false, nil
+	}
+
+	return readMemoryLimit(memCGroup, _cgroupMemoryLimitParam)
+}
+
+// CPUShares returns the relative CPU weight applied with the CPU cgroup
+// controller, as listed in `cpu.shares`. It's only meaningful relative to the
+// shares of other cgroups; the kernel's default is 1024. If `cpu.shares`
+// doesn't exist, the method returns `(0, false, nil)`.
+func (cg CGroups) CPUShares() (uint64, bool, error) {
+	cpuCGroup, exists := cg[_cgroupSubsysCPU]
+	if !exists {
+		return 0, false, nil
+	}
+
+	text, err := cpuCGroup.readFirstLine(_cgroupCPUSharesParam)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	shares, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return 0, false, cpuCGroup.parseError(_cgroupCPUSharesParam, text, err)
+	}
+	return shares, true, nil
+}
+
+// CPUSharesQuota estimates a CPU quota from the relative weight applied with
+// the CPU cgroup controller. It is a result of `cpu.shares / 1024`, which is
+// how container runtimes translate CPU requests (e.g. in Kubernetes) to
+// shares. If `cpu.shares` is not available, the method returns
+// `(-1, false, nil)`.
+func (cg CGroups) CPUSharesQuota() (float64, bool, error) {
+	shares, defined, err := cg.CPUShares()
+	if !defined || err != nil || shares == 0 {
+		return -1, false, err
 	}
 
-	return float64(cfsQuotaUs) / float64(cfsPeriodUs), true, nil
+	return float64(shares) / _cgroupCPUSharesPerCPU, true, nil
 }
@@ -18,16 +18,13 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-//go:build linux
-// +build linux
-
 package cgroups
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"strconv"
@@ -44,8 +41,26 @@ const (
 
 	_cgroupv2MountPoint = "/sys/fs/cgroup"
 
-	_cgroupV2CPUMaxDefaultPeriod = 100000
-	_cgroupV2CPUMaxQuotaMax      = "max"
+	_cgroupV2CPUMaxQuotaMax = "max"
+
+	// _cgroupv2CPUWeight is the file name for the CGroup-V2 CPU weight
+	// parameter.
+	_cgroupv2CPUWeight = "cpu.weight"
+	// _cgroupv2CPUWeightMin and _cgroupv2CPUWeightMax bound the values
+	// `cpu.weight` may hold.
+	_cgroupv2CPUWeightMin = 1
+	_cgroupv2CPUWeightMax = 10000
+
+	// _cgroupv2CPUSetCPUs is the file name for the CGroup-V2 cpuset
+	// configured by the user.
+	_cgroupv2CPUSetCPUs = "cpuset.cpus"
+	// _cgroupv2CPUSetCPUsEffective is the file name for the CGroup-V2 cpuset
+	// actually granted, after intersecting with the cpusets of ancestors.
+	_cgroupv2CPUSetCPUsEffective = "cpuset.cpus.effective"
+
+	// _sysPathCPUIsolated lists the CPUs isolated from the general scheduler
+	// with the isolcpus boot parameter.
+	_sysPathCPUIsolated = "/sys/devices/system/cpu/isolated"
 )
 
 const (
@@ -53,14 +68,22 @@ const (
 	_cgroupv2CPUMaxPeriodIndex
 )
 
+// DefaultCFSPeriod is the default CFS period in microseconds, used when
+// `cpu.max` holds a quota but no period.
+const DefaultCFSPeriod = 100000
+
 // ErrNotV2 indicates that the system is not using cgroups2.
 var ErrNotV2 = errors.New("not using cgroups2")
 
 // CGroups2 provides access to cgroups data for systems using cgroups2.
 type CGroups2 struct {
-	mountPoint string
-	groupPath  string
-	cpuMaxFile string
+	mountPoint   string
+	groupPath    string
+	cpuMaxFile   string
+	isolatedFile string
+	// fsys, if set, is the filesystem the cgroup files are read from, see
+	// CGroup.
+	fsys fs.FS
 }
 
 // NewCGroups2ForCurrentProcess builds a CGroups2 for the current process.
@@ -70,17 +93,61 @@ func NewCGroups2ForCurrentProcess() (*CGroups2, error) {
 	return newCGroups2From(_procPathMountInfo, _procPathCGroup)
 }
 
+// NewCGroups2ForProcFS builds a CGroups2 for the current process, reading
+// its `mountinfo` and `cgroup` files from the procfs mounted at procFS
+// rather than `/proc`.
+//
+// This returns ErrNotV2 if the system is not using cgroups2.
+func NewCGroups2ForProcFS(procFS string) (*CGroups2, error) {
+	return NewCGroups2ForPID(nil, procFS, 0)
+}
+
+// NewCGroups2ForPID is like NewCGroups2ForProcFS, but builds the CGroups2 of
+// the process with the given PID instead, reading `<pid>/mountinfo` and
+// `<pid>/cgroup` under procFS. A pid of 0 stands for the current process.
+// If fsys is set, procFS and the cgroup files are read from it, see
+// NewCGroupsFS.
+func NewCGroups2ForPID(fsys fs.FS, procFS string, pid int) (*CGroups2, error) {
+	mountInfoPath, procPathCGroup := procPaths(procFS, pid)
+	return newCGroups2At(fsys, mountInfoPath, procPathCGroup, false)
+}
+
+// NewUnifiedCGroups2ForProcFS builds a CGroups2 for the current process like
+// NewCGroups2ForProcFS, but also accepts a cgroups v2 hierarchy mounted
+// somewhere other than /sys/fs/cgroup, such as /sys/fs/cgroup/unified on
+// systems in hybrid mode.
+//
+// This returns ErrNotV2 if no cgroups v2 hierarchy is mounted.
+func NewUnifiedCGroups2ForProcFS(procFS string) (*CGroups2, error) {
+	return NewUnifiedCGroups2ForPID(nil, procFS, 0)
+}
+
+// NewUnifiedCGroups2ForPID is like NewUnifiedCGroups2ForProcFS, but builds
+// the CGroups2 of the process with the given PID instead, reading from fsys
+// if set like NewCGroups2ForPID. A pid of 0 stands for the current process.
+func NewUnifiedCGroups2ForPID(fsys fs.FS, procFS string, pid int) (*CGroups2, error) {
+	mountInfoPath, procPathCGroup := procPaths(procFS, pid)
+	return newCGroups2At(fsys, mountInfoPath, procPathCGroup, true)
+}
+
 func newCGroups2From(mountInfoPath, procPathCGroup string) (*CGroups2, error) {
-	isV2, err := isCGroupV2(mountInfoPath)
+	return newCGroups2At(nil, mountInfoPath, procPathCGroup, false)
+}
+
+// newCGroups2At builds a CGroups2 from the cgroups v2 hierarchy mounted at
+// /sys/fs/cgroup or, if anywhere is set, at whichever mount point comes
+// first, reading from fsys, or from the operating system if fsys is nil.
+func newCGroups2At(fsys fs.FS, mountInfoPath, procPathCGroup string, anywhere bool) (*CGroups2, error) {
+	mountPoint, err := cgroupV2MountPoint(fsys, mountInfoPath, anywhere)
 	if err != nil {
 		return nil, err
 	}
 
-	if !isV2 {
+	if mountPoint == "" {
 		return nil, ErrNotV2
 	}
 
-	subsystems, err := parseCGroupSubsystems(procPathCGroup)
+	subsystems, err := parseCGroupSubsystemsFS(fsys, procPathCGroup)
 	if err != nil {
 		return nil, err
 	}
@@ -99,26 +166,88 @@ func newCGroups2From(mountInfoPath, procPathCGroup string) (*CGroups2, error) {
 	}
 
 	return &CGroups2{
-		mountPoint: _cgroupv2MountPoint,
-		groupPath:  v2subsys.Name,
-		cpuMaxFile: _cgroupv2CPUMax,
+		mountPoint:   mountPoint,
+		groupPath:    v2subsys.Name,
+		cpuMaxFile:   _cgroupv2CPUMax,
+		isolatedFile: _sysPathCPUIsolated,
+		fsys:         fsys,
 	}, nil
 }
 
-func isCGroupV2(procPathMountInfo string) (bool, error) {
+// cgroupV2MountPoint returns /sys/fs/cgroup if a cgroups v2 hierarchy is
+// mounted there or, if anywhere is set, the first mount point of one
+// elsewhere. It returns "" if there is none.
+func cgroupV2MountPoint(fsys fs.FS, procPathMountInfo string, anywhere bool) (string, error) {
 	var (
-		isV2          bool
+		mountPoint    string
 		newMountPoint = func(mp *MountPoint) error {
-			isV2 = isV2 || (mp.FSType == _cgroupv2FSType && mp.MountPoint == _cgroupv2MountPoint)
+			if mp.FSType != _cgroupv2FSType {
+				return nil
+			}
+			switch {
+			case mp.MountPoint == _cgroupv2MountPoint:
+				mountPoint = mp.MountPoint
+			case anywhere && mountPoint == "":
+				mountPoint = mp.MountPoint
+			}
 			return nil
 		}
 	)
 
-	if err := parseMountInfo(procPathMountInfo, newMountPoint); err != nil {
-		return false, err
+	if err := parseMountInfoFS(fsys, procPathMountInfo, newMountPoint); err != nil {
+		return "", err
+	}
+
+	return mountPoint, nil
+}
+
+// VersionHybrid is the version VersionForProcFS reports for systems mounting
+// cgroups v1 controllers alongside a cgroups v2 hierarchy that holds none of
+// them, as systemd does in its hybrid mode. The v1 controllers are used then.
+const VersionHybrid = 3
+
+// VersionForProcFS returns the version of cgroups used by the current
+// process, telling them apart by the mounts listed in the `mountinfo` file
+// read from the procfs mounted at procFS, like NewCGroups2ForProcFS does: 2
+// if a cgroups v2 hierarchy is mounted at /sys/fs/cgroup, VersionHybrid if
+// one is mounted elsewhere alongside v1 controllers, 1 if there are only v1
+// controllers, and 0 if neither is in use.
+func VersionForProcFS(procFS string) (int, error) {
+	return VersionForPID(nil, procFS, 0)
+}
+
+// VersionForPID is like VersionForProcFS, but tells the version of cgroups
+// from the mounts seen by the process with the given PID instead, reading
+// from fsys if set like NewCGroups2ForPID. A pid of 0 stands for the current
+// process.
+func VersionForPID(fsys fs.FS, procFS string, pid int) (int, error) {
+	procPathMountInfo, _ := procPaths(procFS, pid)
+
+	var hasV1, hasV2, hasUnified bool
+	newMountPoint := func(mp *MountPoint) error {
+		switch mp.FSType {
+		case _cgroupFSType:
+			hasV1 = true
+		case _cgroupv2FSType:
+			hasV2 = true
+			hasUnified = hasUnified || mp.MountPoint == _cgroupv2MountPoint
+		}
+		return nil
+	}
+	if err := parseMountInfoFS(fsys, procPathMountInfo, newMountPoint); err != nil {
+		return 0, err
 	}
 
-	return isV2, nil
+	switch {
+	case hasUnified:
+		return 2, nil
+	case hasV1 && hasV2:
+		return VersionHybrid, nil
+	case hasV1:
+		return 1, nil
+	default:
+		return 0, nil
+	}
 }
 
 // CPUQuota returns the CPU quota applied with the CPU cgroup2 controller.
@@ -126,51 +255,228 @@ func isCGroupV2(procPathMountInfo string) (bool, error) {
 // It will return `cpu.max / cpu.period`. If cpu.max is set to max, it returns
 // (-1, false, nil)
 func (cg *CGroups2) CPUQuota() (float64, bool, error) {
-	cpuMaxParams, err := os.Open(path.Join(cg.mountPoint, cg.groupPath, cg.cpuMaxFile))
+	quota, period, defined, err := cg.CPUQuotaPeriod()
+	if !defined || err != nil {
+		return -1, defined, err
+	}
+	return float64(quota) / float64(period), true, nil
+}
+
+// CPUQuotaPeriod returns the raw CPU quota and period from the cpu.max file,
+// in microseconds. The period defaults to DefaultCFSPeriod if cpu.max only
+// lists the quota. If cpu.max is set to max, it returns (-1, -1, false, nil).
+// If the period isn't positive, it fails with an error matching
+// ErrInvalidPeriod.
+//
+// The limits of the ancestors of the cgroup, up to the root of the cgroup2
+// mount, apply as well; under delegation, e.g. by systemd, the cgroup of
+// the process may have no limit of its own while an ancestor enforces one.
+// The quota and period of the level allowing the fewest CPUs are returned.
+// Levels may have different periods, so they're compared by quota/period
+// rather than by raw quota.
+func (cg *CGroups2) CPUQuotaPeriod() (int, int, bool, error) {
+	minQuota, minPeriod, found := -1, -1, false
+	for dir := path.Join("/", cg.groupPath); ; dir = path.Dir(dir) {
+		quota, period, defined, err := cg.readCPUMax(dir)
+		if err != nil {
+			return -1, -1, false, err
+		}
+		if defined && (!found || float64(quota)/float64(period) < float64(minQuota)/float64(minPeriod)) {
+			minQuota, minPeriod, found = quota, period, true
+		}
+		if dir == "/" {
+			return minQuota, minPeriod, found, nil
+		}
+	}
+}
+
+// readCPUMax reads the cpu.max file of the cgroup at dir, relative to the
+// cgroup2 mount, see CPUQuotaPeriod.
+func (cg *CGroups2) readCPUMax(dir string) (int, int, bool, error) {
+	cpuMaxPath := path.Join(cg.mountPoint, dir, cg.cpuMaxFile)
+	cpuMaxParams, err := openFile(cg.fsys, cpuMaxPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return -1, false, nil
+			return -1, -1, false, nil
 		}
-		return -1, false, err
+		return -1, -1, false, err
 	}
 	defer cpuMaxParams.Close()
 
-	scanner := bufio.NewScanner(cpuMaxParams)
+	scanner, buf := newScanner(cpuMaxParams)
+	defer releaseScanBuffer(buf)
 	if scanner.Scan() {
-		fields := strings.Fields(scanner.Text())
+		if err := scanner.Err(); err != nil {
+			return -1, -1, false, err
+		}
+		text := trimValue(scanner.Text())
+		invalid := func(err error) (int, int, bool, error) {
+			return -1, -1, false, &parseError{path: cpuMaxPath, line: 1, content: text, err: err}
+		}
+
+		fields := strings.Fields(text)
 		if len(fields) == 0 || len(fields) > 2 {
-			return -1, false, fmt.Errorf("invalid format")
+			return invalid(ErrInvalidFormat)
 		}
 
+		// A quota of "max" means no limit, whatever the period.
 		if fields[_cgroupv2CPUMaxQuotaIndex] == _cgroupV2CPUMaxQuotaMax {
-			return -1, false, nil
+			return -1, -1, false, nil
 		}
 
 		max, err := strconv.Atoi(fields[_cgroupv2CPUMaxQuotaIndex])
 		if err != nil {
-			return -1, false, err
+			return invalid(err)
 		}
 
 		var period int
 		if len(fields) == 1 {
-			period = _cgroupV2CPUMaxDefaultPeriod
+			period = DefaultCFSPeriod
 		} else {
 			period, err = strconv.Atoi(fields[_cgroupv2CPUMaxPeriodIndex])
 			if err != nil {
-				return -1, false, err
+				return invalid(err)
 			}
 
-			if period == 0 {
-				return -1, false, errors.New("zero value for period is not allowed")
+			if period <= 0 {
+				return invalid(ErrInvalidPeriod)
 			}
 		}
 
-		return float64(max) / float64(period), true, nil
+		return max, period, true, nil
 	}
 
 	if err := scanner.Err(); err != nil {
+		return -1, -1, false, err
+	}
+
+	return -1, -1, false, io.ErrUnexpectedEOF
+}
+
+// group returns the cgroup of the process in the cgroup2 hierarchy.
+func (cg *CGroups2) group() *CGroup {
+	return &CGroup{path: path.Join(cg.mountPoint, cg.groupPath), fsys: cg.fsys}
+}
+
+// Version returns 2, the version of cgroups read by CGroups2.
+func (cg *CGroups2) Version() int {
+	return 2
+}
+
+// CPUSet returns the number of CPUs the cgroup2 cpuset controller allows the
+// process to run on. `cpuset.cpus.effective` is preferred over `cpuset.cpus`
+// because it also reflects the restrictions of ancestor cgroups. CPUs
+// isolated with the isolcpus boot parameter are left out, unless the cpuset
+// holds isolated CPUs only. If neither file is present or both are empty, it
+// returns (-1, false, nil).
+func (cg *CGroups2) CPUSet() (int, bool, error) {
+	isolated, err := cg.isolatedCPUs()
+	if err != nil {
 		return -1, false, err
 	}
 
-	return 0, false, io.ErrUnexpectedEOF
+	return readCPUSet(cg.group(), isolated, _cgroupv2CPUSetCPUsEffective, _cgroupv2CPUSetCPUs)
+}
+
+// isolatedCPUs returns the list of CPUs isolated from the general scheduler,
+// which is empty if there are none or if it's unknown.
+func (cg *CGroups2) isolatedCPUs() (string, error) {
+	if cg.isolatedFile == "" {
+		return "", nil
+	}
+	return readIsolatedCPUs(cg.fsys, cg.isolatedFile)
+}
+
+// NrThrottled returns the number of CFS periods in which the cgroup2 has been
+// throttled, as reported by `nr_throttled` in `cpu.stat`. If the counter is
+// unavailable, the method returns `(0, false, nil)`.
+func (cg *CGroups2) NrThrottled() (uint64, bool, error) {
+	stat, defined, err := cg.CPUStat()
+	return stat.NrThrottled, defined, err
+}
+
+// CPUStat returns the CFS throttling counters of the cgroup2, as reported in
+// `cpu.stat`. If they are unavailable, the method returns
+// `(CPUStat{}, false, nil)`.
+func (cg *CGroups2) CPUStat() (CPUStat, bool, error) {
+	return readCPUStat(cg.group())
+}
+
+// ProcessCount returns the number of processes in the cgroup2, as listed in
+// `cgroup.procs`. If the list is unavailable, the method returns
+// `(0, false, nil)`.
+func (cg *CGroups2) ProcessCount() (int, bool, error) {
+	return readProcessCount(cg.group())
+}
+
+// MemoryLimit returns the memory limit in bytes, which is the hard limit from
+// the `memory.max` file (see MemoryMax). If no limit is set, it returns
+// (0, false, nil).
+func (cg *CGroups2) MemoryLimit() (uint64, bool, error) {
+	return cg.MemoryMax()
+}
+
+// MemoryMax returns the hard memory limit in bytes from the `memory.max`
+// file, beyond which the cgroup is OOM-killed. If no limit is set, it returns
+// (0, false, nil).
+func (cg *CGroups2) MemoryMax() (uint64, bool, error) {
+	return readMemoryLimit(cg.group(), _cgroupv2MemoryMax)
+}
+
+// MemoryHigh returns the soft memory limit in bytes from the `memory.high`
+// file, beyond which the kernel throttles the cgroup and reclaims its memory
+// aggressively. Setting GOMEMLIMIT below it lets the Go GC kick in before
+// the throttling does. If no limit is set, it returns (0, false, nil).
+func (cg *CGroups2) MemoryHigh() (uint64, bool, error) {
+	return readMemoryLimit(cg.group(), _cgroupv2MemoryHigh)
+}
+
+// CPUWeight returns the relative CPU weight applied with the CPU cgroup2
+// controller, as listed in `cpu.weight`, in the range [1, 10000]. Kubernetes
+// derives it from the CPU requests of a pod, so it's only meaningful relative
+// to the weights of other cgroups and serves as an advisory hint when no CPU
+// quota is defined. If `cpu.weight` doesn't exist, the method returns
+// `(-1, false, nil)`.
+func (cg *CGroups2) CPUWeight() (int, bool, error) {
+	weight, err := cg.group().readInt(_cgroupv2CPUWeight)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return -1, false, nil
+		}
+		return -1, false, fmt.Errorf("invalid %v: %w", _cgroupv2CPUWeight, err)
+	}
+	if weight < _cgroupv2CPUWeightMin || weight > _cgroupv2CPUWeightMax {
+		return -1, false, formatInvalidf("%v %d out of range [%d, %d]",
+			_cgroupv2CPUWeight, weight, _cgroupv2CPUWeightMin, _cgroupv2CPUWeightMax)
+	}
+	return weight, true, nil
+}
+
+// CPUSharesQuota estimates a CPU quota from the relative weight applied with
+// the CPU cgroup2 controller. `cpu.weight` is converted back to cgroup v1 CPU
+// shares by inverting the mapping container runtimes (e.g. runc) use for
+// Kubernetes CPU requests, and the result is `shares / 1024`. If `cpu.weight`
+// is not available, the method returns `(-1, false, nil)`.
+func (cg *CGroups2) CPUSharesQuota() (float64, bool, error) {
+	weight, defined, err := cg.CPUWeight()
+	if !defined || err != nil {
+		return -1, defined, err
+	}
+
+	// runc converts shares to weight with
+	//
+	//   weight = 1 + ((shares - 2) * 9999) / 262142
+	//
+	// which maps a range of shares to each weight. Use the largest shares
+	// value of that range so that rounding the result down recovers
+	// whole-CPU requests.
+	const (
+		sharesSpan = _cgroupCPUSharesMax - _cgroupCPUSharesMin
+		weightSpan = _cgroupv2CPUWeightMax - _cgroupv2CPUWeightMin
+	)
+	shares := 1 + (weight*sharesSpan+weightSpan-1)/weightSpan
+	if shares > _cgroupCPUSharesMax {
+		shares = _cgroupCPUSharesMax
+	}
+	return float64(shares) / _cgroupCPUSharesPerCPU, true, nil
 }
@@ -24,10 +24,12 @@
 package cgroups
 
 import (
+	"errors"
 	"os"
 	"os/user"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -78,12 +80,80 @@ func TestCGroupsIsCGroupV2(t *testing.T) {
 	}
 }
 
+func TestVersionForProcFS(t *testing.T) {
+	tests := []struct {
+		mountInfo string
+		want      int
+	}{
+		{mountInfo: "mountinfo", want: 1},
+		{mountInfo: "mountinfo-v1-v2", want: VersionHybrid},
+		{mountInfo: "mountinfo-v2", want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mountInfo, func(t *testing.T) {
+			mountInfo, err := os.ReadFile(filepath.Join(testDataProcPath, "v2", tt.mountInfo))
+			require.NoError(t, err)
+			procFS := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(procFS, "self"), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(procFS, "self", "mountinfo"), mountInfo, 0o644))
+
+			got, err := VersionForProcFS(procFS)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		procFS := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(procFS, "self"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(procFS, "self", "mountinfo"), nil, 0o644))
+
+		got, err := VersionForProcFS(procFS)
+		require.NoError(t, err)
+		assert.Equal(t, 0, got)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := VersionForProcFS(t.TempDir())
+		assert.Error(t, err)
+	})
+}
+
+func TestVersionForPID(t *testing.T) {
+	procFS := t.TempDir()
+	for dir, name := range map[string]string{"self": "mountinfo", "42": "mountinfo-v2"} {
+		mountInfo, err := os.ReadFile(filepath.Join(testDataProcPath, "v2", name))
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Join(procFS, dir), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(procFS, dir, "mountinfo"), mountInfo, 0o644))
+	}
+
+	t.Run("pid", func(t *testing.T) {
+		got, err := VersionForPID(nil, procFS, 42)
+		require.NoError(t, err)
+		assert.Equal(t, 2, got)
+	})
+
+	t.Run("self", func(t *testing.T) {
+		got, err := VersionForPID(nil, procFS, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 1, got)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := VersionForPID(nil, procFS, 43)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
 func TestCGroupsCPUQuotaV2(t *testing.T) {
 	tests := []struct {
-		name    string
-		want    float64
-		wantOK  bool
-		wantErr string
+		name          string
+		want          float64
+		wantOK        bool
+		wantErr       string
+		wantErrFormat bool
 	}{
 		{
 			name:   "set",
@@ -95,11 +165,41 @@ func TestCGroupsCPUQuotaV2(t *testing.T) {
 			want:   -1.0,
 			wantOK: false,
 		},
+		{
+			name:   "unset-newline",
+			want:   -1.0,
+			wantOK: false,
+		},
+		{
+			name:   "unset-no-period",
+			want:   -1.0,
+			wantOK: false,
+		},
+		{
+			name:   "half",
+			want:   0.5,
+			wantOK: true,
+		},
 		{
 			name:   "only-max",
 			want:   5.0,
 			wantOK: true,
 		},
+		{
+			name:   "extra-whitespace",
+			want:   0.5,
+			wantOK: true,
+		},
+		{
+			name:   "tab-separated",
+			want:   0.5,
+			wantOK: true,
+		},
+		{
+			name:   "bom",
+			want:   0.5,
+			wantOK: true,
+		},
 		{
 			name:    "invalid-max",
 			wantErr: `parsing "asdf": invalid syntax`,
@@ -118,16 +218,18 @@ func TestCGroupsCPUQuotaV2(t *testing.T) {
 			wantErr: "unexpected EOF",
 		},
 		{
-			name:    "too-few-fields",
-			wantErr: "invalid format",
+			name:          "too-few-fields",
+			wantErr:       "invalid format",
+			wantErrFormat: true,
 		},
 		{
-			name:    "too-many-fields",
-			wantErr: "invalid format",
+			name:          "too-many-fields",
+			wantErr:       "invalid format",
+			wantErrFormat: true,
 		},
 		{
 			name:    "zero-period",
-			wantErr: "zero value for period is not allowed",
+			wantErr: `"250000 0": CFS period must be positive`,
 		},
 	}
 
@@ -143,6 +245,7 @@ func TestCGroupsCPUQuotaV2(t *testing.T) {
 			if len(tt.wantErr) > 0 {
 				require.Error(t, err, tt.name)
 				assert.Contains(t, err.Error(), tt.wantErr)
+				assert.Equal(t, tt.wantErrFormat, errors.Is(err, ErrInvalidFormat), "unexpected format error: %v", err)
 			} else {
 				require.NoError(t, err, tt.name)
 				assert.Equal(t, tt.want, quota, tt.name)
@@ -152,6 +255,121 @@ func TestCGroupsCPUQuotaV2(t *testing.T) {
 	}
 }
 
+func TestCGroupsCPUQuotaV2DefaultPeriod(t *testing.T) {
+	quota, defined, err := (&CGroups2{
+		mountPoint: filepath.Join(testDataCGroupsPath, "v2"),
+		groupPath:  "/",
+		cpuMaxFile: "only-max",
+	}).CPUQuota()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 500000.0/DefaultCFSPeriod, quota)
+}
+
+func TestCGroupsCPUQuotaPeriodV2(t *testing.T) {
+	tests := []struct {
+		name        string
+		wantQuota   int
+		wantPeriod  int
+		wantDefined bool
+	}{
+		{name: "set", wantQuota: 250000, wantPeriod: 100000, wantDefined: true},
+		{name: "only-max", wantQuota: 500000, wantPeriod: DefaultCFSPeriod, wantDefined: true},
+		{name: "unset", wantQuota: -1, wantPeriod: -1},
+		{name: "unset-newline", wantQuota: -1, wantPeriod: -1},
+		{name: "half", wantQuota: 50000, wantPeriod: 100000, wantDefined: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cgroups := &CGroups2{
+				mountPoint: filepath.Join(testDataCGroupsPath, "v2"),
+				groupPath:  "/",
+				cpuMaxFile: tt.name,
+			}
+			quota, period, defined, err := cgroups.CPUQuotaPeriod()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantQuota, quota)
+			assert.Equal(t, tt.wantPeriod, period)
+			assert.Equal(t, tt.wantDefined, defined)
+			assert.Equal(t, 2, cgroups.Version())
+		})
+	}
+}
+
+func TestCGroupsCPUQuotaV2Ancestor(t *testing.T) {
+	nested := filepath.Join(testDataCGroupsPath, "v2-nested")
+	tests := []struct {
+		name       string
+		mountPoint string
+		groupPath  string
+		want       float64
+		wantOK     bool
+		wantErr    string
+	}{
+		{
+			name:       "limit two levels up",
+			mountPoint: nested,
+			groupPath:  "/system.slice/app.service/leaf",
+			want:       2.0,
+			wantOK:     true,
+		},
+		{
+			name:       "limit on the cgroup itself",
+			mountPoint: nested,
+			groupPath:  "/system.slice",
+			want:       2.0,
+			wantOK:     true,
+		},
+		{
+			name:       "narrower ancestor with another period",
+			mountPoint: nested,
+			groupPath:  "/narrower.slice/app.service",
+			want:       1.0,
+			wantOK:     true,
+		},
+		{
+			name:       "no limit up to the root",
+			mountPoint: nested,
+			groupPath:  "/user.slice/leaf",
+			want:       -1.0,
+			wantOK:     false,
+		},
+		{
+			name:       "capped at the mount root",
+			mountPoint: filepath.Join(nested, "system.slice", "app.service"),
+			groupPath:  "/leaf",
+			want:       -1.0,
+			wantOK:     false,
+		},
+		{
+			name:       "invalid ancestor across missing cpu.max",
+			mountPoint: nested,
+			groupPath:  "/invalid.slice/app.service/leaf",
+			wantErr:    `parsing "asdf": invalid syntax`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quota, defined, err := (&CGroups2{
+				mountPoint: tt.mountPoint,
+				groupPath:  tt.groupPath,
+				cpuMaxFile: _cgroupv2CPUMax,
+			}).CPUQuota()
+
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, quota)
+			assert.Equal(t, tt.wantOK, defined)
+		})
+	}
+}
+
 func TestCGroup2GroupPathDiscovery(t *testing.T) {
 	tests := []struct {
 		procCgroup string
@@ -178,6 +396,19 @@ func TestCGroup2GroupPathDiscovery(t *testing.T) {
 	}
 }
 
+func TestCGroups2UnifiedMountPoint(t *testing.T) {
+	mountInfoPath := filepath.Join(testDataProcPath, "v2", "mountinfo-v1-v2")
+	procCgroupPath := filepath.Join(testDataProcPath, "v2", "cgroup-root")
+
+	_, err := newCGroups2From(mountInfoPath, procCgroupPath)
+	assert.ErrorIs(t, err, ErrNotV2, "hybrid mode is not cgroups2 by default")
+
+	cgroups, err := newCGroups2At(nil, mountInfoPath, procCgroupPath, true)
+	require.NoError(t, err)
+	assert.Equal(t, "/sys/fs/cgroup/unified", cgroups.mountPoint)
+	assert.Equal(t, "/", cgroups.groupPath)
+}
+
 func TestCGroup2GroupPathDiscovery_Errors(t *testing.T) {
 	t.Run("no matching subsystem", func(t *testing.T) {
 		mountInfoPath := filepath.Join(testDataProcPath, "v2", "mountinfo-v2")
@@ -212,3 +443,303 @@ func TestCGroupsCPUQuotaV2_OtherErrors(t *testing.T) {
 		assert.Contains(t, err.Error(), "permission denied")
 	})
 }
+
+func TestCGroupsCloudRun(t *testing.T) {
+	// Cloud Run (gen2) runs each instance in a cgroup2-only sandbox where the
+	// process sits at the root of the unified hierarchy, and CPU allocations
+	// below a full core show up as a fractional cpu.max.
+	mountInfoPath := filepath.Join(testDataProcPath, "cloudrun", "mountinfo")
+	procCgroupPath := filepath.Join(testDataProcPath, "cloudrun", "cgroup")
+
+	cgroups, err := newCGroups2From(mountInfoPath, procCgroupPath)
+	require.NoError(t, err)
+	assert.Equal(t, _cgroupv2MountPoint, cgroups.mountPoint)
+	assert.Equal(t, "/", cgroups.groupPath)
+
+	cgroups.mountPoint = filepath.Join(testDataCGroupsPath, "cloudrun")
+	quota, defined, err := cgroups.CPUQuota()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 0.5, quota)
+}
+
+func TestCGroupsCPUSetV2(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    int
+		wantOK  bool
+		wantErr string
+	}{
+		{
+			name:   "effective",
+			want:   4,
+			wantOK: true,
+		},
+		{
+			name:   "configured",
+			want:   3,
+			wantOK: true,
+		},
+		{
+			name:   "unset",
+			want:   -1,
+			wantOK: false,
+		},
+		{
+			name:   "nonexistent",
+			want:   -1,
+			wantOK: false,
+		},
+		{
+			name:    "invalid",
+			wantErr: `invalid cpu list "0-x"`,
+		},
+	}
+
+	mountPoint := filepath.Join(testDataCGroupsPath, "cpuset")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, defined, err := (&CGroups2{
+				mountPoint: mountPoint,
+				groupPath:  tt.name,
+			}).CPUSet()
+
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, count)
+			assert.Equal(t, tt.wantOK, defined)
+		})
+	}
+}
+
+func TestCGroupsCPUSetV2Isolated(t *testing.T) {
+	tests := []struct {
+		name     string
+		isolated string
+		want     int
+		wantErr  string
+	}{
+		{name: "some", want: 6},
+		{name: "none", want: 8},
+		{name: "all", want: 8},
+		{name: "nonexistent", want: 8},
+		{name: "invalid", wantErr: `invalid cpu list "6-x"`},
+	}
+
+	mountPoint := filepath.Join(testDataCGroupsPath, "cpuset")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, defined, err := (&CGroups2{
+				mountPoint:   mountPoint,
+				groupPath:    "wide",
+				isolatedFile: filepath.Join(testDataCGroupsPath, "isolated", tt.name),
+			}).CPUSet()
+
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, defined)
+			assert.Equal(t, tt.want, count)
+		})
+	}
+}
+
+func TestCGroupsNrThrottledV2(t *testing.T) {
+	mountPoint := filepath.Join(testDataCGroupsPath, "cpustat")
+
+	value, defined, err := (&CGroups2{mountPoint: mountPoint, groupPath: "v2"}).NrThrottled()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, uint64(37), value)
+
+	_, defined, err = (&CGroups2{mountPoint: mountPoint, groupPath: "nonexistent"}).NrThrottled()
+	require.NoError(t, err)
+	assert.False(t, defined)
+
+	stat, defined, err := (&CGroups2{mountPoint: mountPoint, groupPath: "v2"}).CPUStat()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, CPUStat{NrPeriods: 4512, NrThrottled: 37, ThrottledTime: 1830147 * time.Microsecond}, stat)
+}
+
+func TestCGroupsProcessCountV2(t *testing.T) {
+	mountPoint := filepath.Join(testDataCGroupsPath, "procs")
+
+	count, defined, err := (&CGroups2{mountPoint: mountPoint, groupPath: "siblings"}).ProcessCount()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 3, count)
+
+	_, defined, err = (&CGroups2{mountPoint: mountPoint, groupPath: "nonexistent"}).ProcessCount()
+	require.NoError(t, err)
+	assert.False(t, defined)
+}
+
+func TestCGroupsMemoryLimitV2(t *testing.T) {
+	tests := []struct {
+		name        string
+		want        uint64
+		wantDefined bool
+		wantErr     bool
+	}{
+		{name: "v2", want: 2 << 30, wantDefined: true},
+		{name: "v2-unlimited"},
+		{name: "nonexistent"},
+		{name: "invalid", wantErr: true},
+	}
+
+	mountPoint := filepath.Join(testDataCGroupsPath, "memory")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, defined, err := (&CGroups2{mountPoint: mountPoint, groupPath: tt.name}).MemoryLimit()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantDefined, defined)
+			assert.Equal(t, tt.want, value)
+		})
+	}
+}
+
+func TestCGroupsMemoryHighV2(t *testing.T) {
+	tests := []struct {
+		name        string
+		want        uint64
+		wantDefined bool
+		wantErr     bool
+	}{
+		{name: "v2", want: 1932735283, wantDefined: true},
+		{name: "v2-unlimited"},
+		{name: "nonexistent"},
+		{name: "invalid", wantErr: true},
+	}
+
+	mountPoint := filepath.Join(testDataCGroupsPath, "memory")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cgroups := &CGroups2{mountPoint: mountPoint, groupPath: tt.name}
+			value, defined, err := cgroups.MemoryHigh()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantDefined, defined)
+			assert.Equal(t, tt.want, value)
+
+			if tt.wantDefined {
+				max, _, err := cgroups.MemoryMax()
+				require.NoError(t, err)
+				assert.NotEqual(t, value, max, "memory.high should be read separately from memory.max")
+			}
+		})
+	}
+}
+
+func TestCGroupsCPUWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    int
+		wantOK  bool
+		wantErr string
+	}{
+		{
+			name:   "request-1cpu",
+			want:   39,
+			wantOK: true,
+		},
+		{
+			name:   "nonexistent",
+			want:   -1,
+			wantOK: false,
+		},
+		{
+			name:    "out-of-range",
+			wantErr: "cpu.weight 10001 out of range [1, 10000]",
+		},
+		{
+			name:    "invalid",
+			wantErr: `invalid/cpu.weight:1: "x": strconv.Atoi: parsing "x": invalid syntax`,
+		},
+	}
+
+	mountPoint := filepath.Join(testDataCGroupsPath, "weight")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weight, defined, err := (&CGroups2{
+				mountPoint: mountPoint,
+				groupPath:  tt.name,
+			}).CPUWeight()
+
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, weight)
+			assert.Equal(t, tt.wantOK, defined)
+		})
+	}
+}
+
+func TestCGroupsCPUSharesQuotaV2(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    float64
+		wantOK  bool
+		wantErr string
+	}{
+		{
+			name:   "request-1cpu",
+			want:   1.0,
+			wantOK: true,
+		},
+		{
+			name:   "request-2cpu",
+			want:   2073.0 / 1024,
+			wantOK: true,
+		},
+		{
+			name:   "nonexistent",
+			want:   -1.0,
+			wantOK: false,
+		},
+		{
+			name:    "out-of-range",
+			wantErr: "cpu.weight 10001 out of range [1, 10000]",
+		},
+		{
+			name:    "invalid",
+			wantErr: `parsing "x": invalid syntax`,
+		},
+	}
+
+	mountPoint := filepath.Join(testDataCGroupsPath, "weight")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quota, defined, err := (&CGroups2{
+				mountPoint: mountPoint,
+				groupPath:  tt.name,
+			}).CPUSharesQuota()
+
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, quota)
+			assert.Equal(t, tt.wantOK, defined)
+		})
+	}
+}
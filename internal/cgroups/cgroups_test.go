@@ -24,10 +24,17 @@
 package cgroups
 
 import (
+	"io/fs"
+	"os"
 	"path/filepath"
+	"strconv"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewCGroups(t *testing.T) {
@@ -55,6 +62,74 @@ func TestNewCGroups(t *testing.T) {
 	}
 }
 
+func TestNewCGroupsMultipleMounts(t *testing.T) {
+	// The cpu,cpuacct hierarchy is mounted three times: once with the host
+	// root, once with the container's root and once with a root that doesn't
+	// contain the process' cgroup at all. The deepest root containing the
+	// cgroup must win, regardless of the order of the mounts.
+	cgroupPath := filepath.Join(testDataProcPath, "multi-root", "cgroup")
+	for _, mountInfo := range []string{"mountinfo", "mountinfo-reversed"} {
+		t.Run(mountInfo, func(t *testing.T) {
+			mountInfoPath := filepath.Join(testDataProcPath, "multi-root", mountInfo)
+
+			cgroups, err := NewCGroups(mountInfoPath, cgroupPath)
+			require.NoError(t, err)
+			for _, subsys := range []string{_cgroupSubsysCPU, _cgroupSubsysCPUAcct} {
+				if assert.Contains(t, cgroups, subsys) {
+					assert.Equal(t, "/sys/fs/cgroup/cpu,cpuacct/sub", cgroups[subsys].Path(), subsys)
+				}
+			}
+		})
+	}
+}
+
+func TestNewCGroupsNamespaceRoot(t *testing.T) {
+	// With a cgroup namespace, /proc/self/cgroup reports the namespace root
+	// "/" while mountinfo still shows the root of the cgroup on the host. The
+	// cgroup files then sit directly at the mount point.
+	mountInfoPath := filepath.Join(testDataProcPath, "cgroupns", "mountinfo")
+	cgroupPath := filepath.Join(testDataProcPath, "cgroupns", "cgroup")
+
+	testTable := []struct {
+		subsys string
+		path   string
+	}{
+		{_cgroupSubsysCPU, "/sys/fs/cgroup/cpu,cpuacct"},
+		{_cgroupSubsysCPUAcct, "/sys/fs/cgroup/cpu,cpuacct"},
+		{_cgroupSubsysCPUSet, "/sys/fs/cgroup/cpuset"},
+		{_cgroupSubsysMemory, "/sys/fs/cgroup/memory"},
+	}
+
+	cgroups, err := NewCGroups(mountInfoPath, cgroupPath)
+	require.NoError(t, err)
+	assert.Equal(t, len(testTable), len(cgroups))
+
+	for _, tt := range testTable {
+		if assert.Contains(t, cgroups, tt.subsys) {
+			assert.Equal(t, tt.path, cgroups[tt.subsys].Path(), tt.subsys)
+		}
+	}
+}
+
+func TestNewCGroupsGVisor(t *testing.T) {
+	// gVisor emulates /proc and may report neither cgroup memberships nor
+	// cgroup mounts. That must look like "no quota" rather than an error.
+	mountInfoPath := filepath.Join(testDataProcPath, "gvisor", "mountinfo")
+	cgroupPath := filepath.Join(testDataProcPath, "gvisor", "cgroup")
+
+	cgroups, err := NewCGroups(mountInfoPath, cgroupPath)
+	require.NoError(t, err)
+	assert.Empty(t, cgroups)
+
+	quota, defined, err := cgroups.CPUQuota()
+	require.NoError(t, err)
+	assert.False(t, defined)
+	assert.Equal(t, -1.0, quota)
+
+	_, err = newCGroups2From(mountInfoPath, cgroupPath)
+	assert.ErrorIs(t, err, ErrNotV2)
+}
+
 func TestNewCGroupsWithErrors(t *testing.T) {
 	testTable := []struct {
 		mountInfoPath string
@@ -83,6 +158,216 @@ func TestNewCGroupsWithErrors(t *testing.T) {
 	}
 }
 
+func TestParseErrorContext(t *testing.T) {
+	t.Run("cgroup", func(t *testing.T) {
+		cgroupPath := filepath.Join(testDataProcPath, "invalid-cgroup", "cgroup")
+		_, err := NewCGroups("/dev/null", cgroupPath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), cgroupPath+`:2: invalid format for CGroupSubsys: "invalid-line:"`)
+		assert.ErrorIs(t, err, ErrInvalidFormat)
+	})
+
+	t.Run("mountinfo", func(t *testing.T) {
+		mountInfoPath := filepath.Join(testDataProcPath, "invalid-mountinfo", "mountinfo")
+		_, err := NewCGroups(mountInfoPath, filepath.Join(testDataProcPath, "cgroups", "cgroup"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), mountInfoPath+":1: invalid format for MountPoint")
+		assert.ErrorIs(t, err, ErrInvalidFormat)
+	})
+
+	t.Run("v1 quota", func(t *testing.T) {
+		cgroupPath := filepath.Join(testDataCGroupsPath, "invalid")
+		_, _, err := CGroups{_cgroupSubsysCPU: NewCGroup(cgroupPath)}.CPUQuota()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), filepath.Join(cgroupPath, _cgroupCPUCFSQuotaUsParam)+`:1: "non-an-integer"`)
+		var numErr *strconv.NumError
+		assert.ErrorAs(t, err, &numErr, "should still match the parse error")
+	})
+
+	t.Run("v2 cpu.max", func(t *testing.T) {
+		mountPoint := filepath.Join(testDataCGroupsPath, "v2")
+		_, _, err := (&CGroups2{mountPoint: mountPoint, groupPath: "/", cpuMaxFile: "invalid-max"}).CPUQuota()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), filepath.Join(mountPoint, "invalid-max")+`:1: "asdf 100000"`)
+	})
+}
+
+func TestNewCGroupsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"proc/self/mountinfo": {Data: []byte(
+			"1 0 8:1 / / rw,noatime shared:1 - overlay overlay rw\n" +
+				"7 1 0:6 /docker/0123456789abcdef /sys/fs/cgroup/cpu,cpuacct ro,nosuid,nodev,noexec,relatime shared:7 - cgroup cgroup rw,cpu,cpuacct\n" +
+				"8 1 0:7 /docker/0123456789abcdef /sys/fs/cgroup/memory ro,nosuid,nodev,noexec,relatime shared:8 - cgroup cgroup rw,memory\n",
+		)},
+		"proc/self/cgroup": {Data: []byte(
+			"4:memory:/docker/0123456789abcdef\n" +
+				"3:cpu,cpuacct:/docker/0123456789abcdef\n",
+		)},
+		"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":  {Data: []byte("150000\n")},
+		"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us": {Data: []byte("100000\n")},
+		"sys/fs/cgroup/cpu,cpuacct/cpu.stat":          {Data: []byte("nr_periods 10\nnr_throttled 3\n")},
+		"sys/fs/cgroup/cpu,cpuacct/cgroup.procs":      {Data: []byte("1\n42\n")},
+		"sys/fs/cgroup/memory/memory.limit_in_bytes":  {Data: []byte("1073741824\n")},
+	}
+
+	cgroups, err := NewCGroupsFS(fsys)
+	require.NoError(t, err)
+	assert.Equal(t, "/sys/fs/cgroup/cpu,cpuacct", cgroups[_cgroupSubsysCPU].Path())
+
+	quota, defined, err := cgroups.CPUQuota()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 1.5, quota)
+
+	throttled, defined, err := cgroups.NrThrottled()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, uint64(3), throttled)
+
+	processes, defined, err := cgroups.ProcessCount()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 2, processes)
+
+	limit, defined, err := cgroups.MemoryLimit()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, uint64(1<<30), limit)
+
+	shares, defined, err := cgroups.CPUShares()
+	require.NoError(t, err, "missing params should read as undefined")
+	assert.False(t, defined)
+	assert.Zero(t, shares)
+
+	_, err = NewCGroupsFS(fstest.MapFS{})
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestCGroupsLXCFS(t *testing.T) {
+	cgroups, err := NewCGroupsFS(os.DirFS(filepath.Join(testDataPath, "lxc")))
+	require.NoError(t, err)
+	assert.Equal(t, "/sys/fs/cgroup/cpu,cpuacct/lxc/c1", cgroups[_cgroupSubsysCPU].Path())
+	assert.Equal(t, "/sys/fs/cgroup/cpu,cpuacct/lxc/c1", cgroups[_cgroupSubsysCPUAcct].Path())
+	assert.Equal(t, "/sys/fs/cgroup/memory/lxc/c1", cgroups[_cgroupSubsysMemory].Path())
+	assert.NotContains(t, cgroups, "name=systemd", "no mount holds name=systemd")
+
+	quota, defined, err := cgroups.CPUQuota()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 2.0, quota)
+
+	cpus, defined, err := cgroups.CPUSet()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 8, cpus)
+
+	limit, defined, err := cgroups.MemoryLimit()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, uint64(512<<20), limit)
+
+	t.Run("cgroup mount wins", func(t *testing.T) {
+		for _, order := range []string{"lxcfs first", "cgroup first"} {
+			lxcfs := "34 33 0:41 /cpu,cpuacct /sys/fs/cgroup/lxcfs rw,relatime - fuse.lxcfs lxcfs rw,user_id=0\n"
+			cgroup := "35 33 0:42 / /sys/fs/cgroup/cpu,cpuacct rw,relatime - cgroup cgroup rw,cpu,cpuacct\n"
+			mountInfo := lxcfs + cgroup
+			if order == "cgroup first" {
+				mountInfo = cgroup + lxcfs
+			}
+
+			cgroups, err := NewCGroupsFS(fstest.MapFS{
+				"proc/self/mountinfo": {Data: []byte(mountInfo)},
+				"proc/self/cgroup":    {Data: []byte("2:cpu,cpuacct:/lxc/c1\n")},
+			})
+			require.NoError(t, err, order)
+			assert.Equal(t, "/sys/fs/cgroup/cpu,cpuacct/lxc/c1", cgroups[_cgroupSubsysCPU].Path(), order)
+		}
+	})
+
+	t.Run("other lxcfs mounts", func(t *testing.T) {
+		cgroups, err := NewCGroupsFS(fstest.MapFS{
+			"proc/self/mountinfo": {Data: []byte(
+				"37 31 0:41 /proc/cpuinfo /proc/cpuinfo rw,relatime - fuse.lxcfs lxcfs rw,user_id=0\n" +
+					"38 31 0:41 / /var/lib/lxcfs rw,relatime - fuse.lxcfs lxcfs rw,user_id=0\n",
+			)},
+			"proc/self/cgroup": {Data: []byte("2:cpu,cpuacct:/lxc/c1\n")},
+		})
+		require.NoError(t, err)
+		assert.Empty(t, cgroups)
+	})
+}
+
+func TestCGroupsReadOnlyMount(t *testing.T) {
+	// Hardened containers bind-mount /sys/fs/cgroup read-only, so detection
+	// must get by with opening files for reading. File modes don't stop
+	// root, so read through an actual read-only bind mount instead.
+	dir := t.TempDir()
+	src := filepath.Join(testDataPath, "lxc")
+	if err := syscall.Mount(src, dir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		t.Skipf("can't bind-mount %v: %v", src, err)
+	}
+	t.Cleanup(func() {
+		assert.NoError(t, syscall.Unmount(dir, 0))
+	})
+	if err := syscall.Mount("", dir, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+		t.Skipf("can't remount %v read-only: %v", dir, err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "proc", "self", "cgroup"), os.O_WRONLY, 0)
+	if err == nil {
+		f.Close()
+	}
+	require.ErrorIs(t, err, syscall.EROFS, "mount isn't read-only")
+
+	cgroups, err := NewCGroupsFS(os.DirFS(dir))
+	require.NoError(t, err)
+
+	quota, defined, err := cgroups.CPUQuota()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 2.0, quota)
+
+	_, _, err = cgroups.CPUSet()
+	require.NoError(t, err)
+	_, _, err = cgroups.CPUShares()
+	require.NoError(t, err)
+	_, _, err = cgroups.NrThrottled()
+	require.NoError(t, err)
+	_, _, err = cgroups.CPUStat()
+	require.NoError(t, err)
+	_, _, err = cgroups.ProcessCount()
+	require.NoError(t, err)
+	_, _, err = cgroups.MemoryLimit()
+	require.NoError(t, err)
+}
+
+func TestNewCGroupsForPath(t *testing.T) {
+	cgroups, err := NewCGroupsForPath(nil, filepath.Join(testDataCGroupsPath, "cpu"))
+	require.NoError(t, err)
+	quota, defined, err := cgroups.CPUQuota()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 6.0, quota)
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "missing", path: filepath.Join(t.TempDir(), "missing"), wantErr: "no cpu.cfs_quota_us in"},
+		{name: "v2", path: filepath.Join(testDataCGroupsPath, "v2"), wantErr: "no cpu.cfs_quota_us in"},
+		{name: "missing period", path: filepath.Join(testDataCGroupsPath, "undefined-period"), wantErr: "no cpu.cfs_period_us in"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCGroupsForPath(nil, tt.path)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.NotErrorIs(t, err, os.ErrNotExist, "shouldn't look like a process outside of cgroups")
+		})
+	}
+}
+
 func TestCGroupsCPUQuota(t *testing.T) {
 	testTable := []struct {
 		name            string
@@ -96,6 +381,12 @@ func TestCGroupsCPUQuota(t *testing.T) {
 			expectedDefined: true,
 			shouldHaveError: false,
 		},
+		{
+			name:            "bom",
+			expectedQuota:   2.5,
+			expectedDefined: true,
+			shouldHaveError: false,
+		},
 		{
 			name:            "undefined",
 			expectedQuota:   -1.0,
@@ -106,7 +397,7 @@ func TestCGroupsCPUQuota(t *testing.T) {
 			name:            "zero-period",
 			expectedQuota:   -1.0,
 			expectedDefined: false,
-			shouldHaveError: false,
+			shouldHaveError: true,
 		},
 		{
 			name:            "undefined-period",
@@ -138,3 +429,625 @@ func TestCGroupsCPUQuota(t *testing.T) {
 		}
 	}
 }
+
+func TestCGroupsCPUQuotaPeriod(t *testing.T) {
+	cgroups := CGroups{_cgroupSubsysCPU: NewCGroup(filepath.Join(testDataCGroupsPath, "cpu"))}
+	quota, period, defined, err := cgroups.CPUQuotaPeriod()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 600000, quota)
+	assert.Equal(t, 100000, period)
+	assert.Equal(t, 1, cgroups.Version())
+
+	cgroups[_cgroupSubsysCPU] = NewCGroup(filepath.Join(testDataCGroupsPath, "undefined"))
+	quota, period, defined, err = cgroups.CPUQuotaPeriod()
+	require.NoError(t, err)
+	assert.False(t, defined)
+	assert.Equal(t, -1, quota)
+	assert.Equal(t, -1, period)
+}
+
+func TestCGroupsProcessCount(t *testing.T) {
+	testTable := []struct {
+		name            string
+		expectedCount   int
+		expectedDefined bool
+	}{
+		{
+			name:            "single",
+			expectedCount:   1,
+			expectedDefined: true,
+		},
+		{
+			name:            "siblings",
+			expectedCount:   3,
+			expectedDefined: true,
+		},
+		{
+			name:            "empty",
+			expectedDefined: false,
+		},
+		{
+			name:            "nonexistent",
+			expectedDefined: false,
+		},
+	}
+
+	cgroups := make(CGroups)
+
+	count, defined, err := cgroups.ProcessCount()
+	assert.Equal(t, 0, count, "no cpu cgroup")
+	assert.False(t, defined, "no cpu cgroup")
+	assert.NoError(t, err, "no cpu cgroup")
+
+	for _, tt := range testTable {
+		cgroups[_cgroupSubsysCPU] = NewCGroup(filepath.Join(testDataCGroupsPath, "procs", tt.name))
+
+		count, defined, err := cgroups.ProcessCount()
+		assert.Equal(t, tt.expectedCount, count, tt.name)
+		assert.Equal(t, tt.expectedDefined, defined, tt.name)
+		assert.NoError(t, err, tt.name)
+	}
+}
+
+func TestCGroupsCPUSet(t *testing.T) {
+	testTable := []struct {
+		name            string
+		expectedCount   int
+		expectedDefined bool
+		shouldHaveError bool
+	}{
+		{
+			name:            "list",
+			expectedCount:   5,
+			expectedDefined: true,
+		},
+		{
+			name:            "effective",
+			expectedCount:   4,
+			expectedDefined: true,
+		},
+		{
+			name:            "single",
+			expectedCount:   1,
+			expectedDefined: true,
+		},
+		{
+			name:            "nonexistent",
+			expectedCount:   -1,
+			expectedDefined: false,
+		},
+		{
+			name:            "invalid",
+			expectedCount:   -1,
+			expectedDefined: false,
+			shouldHaveError: true,
+		},
+	}
+
+	cgroups := make(CGroups)
+
+	count, defined, err := cgroups.CPUSet()
+	assert.Equal(t, -1, count, "no cpuset cgroup")
+	assert.False(t, defined, "no cpuset cgroup")
+	assert.NoError(t, err, "no cpuset cgroup")
+
+	for _, tt := range testTable {
+		cgroups[_cgroupSubsysCPUSet] = NewCGroup(filepath.Join(testDataCGroupsPath, "cpuset-v1", tt.name))
+
+		count, defined, err := cgroups.CPUSet()
+		assert.Equal(t, tt.expectedCount, count, tt.name)
+		assert.Equal(t, tt.expectedDefined, defined, tt.name)
+
+		if tt.shouldHaveError {
+			assert.Error(t, err, tt.name)
+		} else {
+			assert.NoError(t, err, tt.name)
+		}
+	}
+
+	t.Run("isolated", func(t *testing.T) {
+		// Isolated CPUs are left out as on cgroups v2, so that the same host
+		// gets the same GOMAXPROCS whichever version it uses.
+		fsys := fstest.MapFS{
+			"sys/fs/cgroup/cpuset/cpuset.cpus": {Data: []byte("0-7\n")},
+			"sys/devices/system/cpu/isolated":  {Data: []byte("6-7\n")},
+		}
+		cgroups := CGroups{_cgroupSubsysCPUSet: &CGroup{path: "/sys/fs/cgroup/cpuset", fsys: fsys}}
+
+		count, defined, err := cgroups.CPUSet()
+		require.NoError(t, err)
+		assert.True(t, defined)
+		assert.Equal(t, 6, count)
+
+		fsys["sys/fs/cgroup/cpuset/cpuset.cpus"] = &fstest.MapFile{Data: []byte("6-7\n")}
+		count, defined, err = cgroups.CPUSet()
+		require.NoError(t, err)
+		assert.True(t, defined)
+		assert.Equal(t, 2, count, "a cpuset of isolated CPUs only should count them")
+	})
+}
+
+func TestCGroupsNrThrottled(t *testing.T) {
+	testTable := []struct {
+		name            string
+		expectedValue   uint64
+		expectedDefined bool
+		shouldHaveError bool
+	}{
+		{
+			name:            "v1",
+			expectedValue:   131923,
+			expectedDefined: true,
+		},
+		{
+			name:            "missing-key",
+			expectedDefined: false,
+		},
+		{
+			name:            "nonexistent",
+			expectedDefined: false,
+		},
+		{
+			name:            "invalid",
+			expectedDefined: false,
+			shouldHaveError: true,
+		},
+	}
+
+	cgroups := make(CGroups)
+
+	value, defined, err := cgroups.NrThrottled()
+	assert.Equal(t, uint64(0), value, "no cpu cgroup")
+	assert.False(t, defined, "no cpu cgroup")
+	assert.NoError(t, err, "no cpu cgroup")
+
+	for _, tt := range testTable {
+		cgroups[_cgroupSubsysCPU] = NewCGroup(filepath.Join(testDataCGroupsPath, "cpustat", tt.name))
+
+		value, defined, err := cgroups.NrThrottled()
+		assert.Equal(t, tt.expectedValue, value, tt.name)
+		assert.Equal(t, tt.expectedDefined, defined, tt.name)
+
+		if tt.shouldHaveError {
+			assert.Error(t, err, tt.name)
+		} else {
+			assert.NoError(t, err, tt.name)
+		}
+	}
+}
+
+func TestCGroupsCPUStat(t *testing.T) {
+	cgroups := CGroups{_cgroupSubsysCPU: NewCGroup(filepath.Join(testDataCGroupsPath, "cpustat", "v1"))}
+	stat, defined, err := cgroups.CPUStat()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, CPUStat{
+		NrPeriods:     42227334,
+		NrThrottled:   131923,
+		ThrottledTime: 88613212216618 * time.Nanosecond,
+	}, stat)
+
+	cgroups[_cgroupSubsysCPU] = NewCGroup(filepath.Join(testDataCGroupsPath, "cpustat", "invalid"))
+	_, _, err = cgroups.CPUStat()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `cpu.stat:2: "nr_throttled lots"`)
+
+	_, defined, err = CGroups{}.CPUStat()
+	require.NoError(t, err)
+	assert.False(t, defined, "no cpu cgroup")
+}
+
+func TestNewCGroupsKubernetesQoS(t *testing.T) {
+	// Kubernetes places Guaranteed pods directly under the kubepods cgroup,
+	// and Burstable and BestEffort pods under a per-QoS subdirectory. The
+	// systemd cgroup driver uses .slice units for each level instead.
+	paths := map[string]string{
+		"guaranteed":         "/kubepods/pod2c48913c-b29f-11e7-9350-020968147796/9bca8d63d5fa610783847915bcff0ecac1273e5b4bed3f6fa1b07350e0135961",
+		"burstable":          "/kubepods/burstable/pod2c48913c-b29f-11e7-9350-020968147796/9bca8d63d5fa610783847915bcff0ecac1273e5b4bed3f6fa1b07350e0135961",
+		"besteffort":         "/kubepods/besteffort/pod2c48913c-b29f-11e7-9350-020968147796/9bca8d63d5fa610783847915bcff0ecac1273e5b4bed3f6fa1b07350e0135961",
+		"guaranteed-systemd": "/kubepods.slice/kubepods-pod2c48913c_b29f_11e7_9350_020968147796.slice/cri-containerd-9bca8d63d5fa610783847915bcff0ecac1273e5b4bed3f6fa1b07350e0135961.scope",
+		"burstable-systemd":  "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod2c48913c_b29f_11e7_9350_020968147796.slice/cri-containerd-9bca8d63d5fa610783847915bcff0ecac1273e5b4bed3f6fa1b07350e0135961.scope",
+		"besteffort-systemd": "/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod2c48913c_b29f_11e7_9350_020968147796.slice/cri-containerd-9bca8d63d5fa610783847915bcff0ecac1273e5b4bed3f6fa1b07350e0135961.scope",
+	}
+
+	for name, cgroupPath := range paths {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			procPathCGroup := filepath.Join(dir, "cgroup")
+			require.NoError(t, os.WriteFile(procPathCGroup, []byte("4:cpu,cpuacct:"+cgroupPath+"\n"), 0o644))
+
+			t.Run("container root", func(t *testing.T) {
+				// Inside the container, the cgroup filesystem is mounted
+				// with the container's own cgroup as its root.
+				procPathMountInfo := filepath.Join(dir, "mountinfo-container")
+				line := "1165 1158 0:30 " + cgroupPath + " /sys/fs/cgroup/cpu,cpuacct ro,nosuid,nodev,noexec,relatime master:11 - cgroup cgroup rw,cpu,cpuacct\n"
+				require.NoError(t, os.WriteFile(procPathMountInfo, []byte(line), 0o644))
+
+				cgroups, err := NewCGroups(procPathMountInfo, procPathCGroup)
+				require.NoError(t, err)
+				require.Contains(t, cgroups, _cgroupSubsysCPU)
+				assert.Equal(t, "/sys/fs/cgroup/cpu,cpuacct", cgroups[_cgroupSubsysCPU].Path())
+			})
+
+			t.Run("host root", func(t *testing.T) {
+				procPathMountInfo := filepath.Join(dir, "mountinfo-host")
+				line := "28 22 0:25 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:11 - cgroup cgroup rw,cpu,cpuacct\n"
+				require.NoError(t, os.WriteFile(procPathMountInfo, []byte(line), 0o644))
+
+				cgroups, err := NewCGroups(procPathMountInfo, procPathCGroup)
+				require.NoError(t, err)
+				require.Contains(t, cgroups, _cgroupSubsysCPU)
+				assert.Equal(t, "/sys/fs/cgroup/cpu,cpuacct"+cgroupPath, cgroups[_cgroupSubsysCPU].Path())
+			})
+
+			t.Run("v2", func(t *testing.T) {
+				procPathCGroup2 := filepath.Join(dir, "cgroup2")
+				require.NoError(t, os.WriteFile(procPathCGroup2, []byte("0::"+cgroupPath+"\n"), 0o644))
+
+				cgroups, err := newCGroups2From(filepath.Join(testDataProcPath, "v2", "mountinfo-v2"), procPathCGroup2)
+				require.NoError(t, err)
+				assert.Equal(t, cgroupPath, cgroups.groupPath)
+			})
+		})
+	}
+}
+
+func TestCGroupsCPUShares(t *testing.T) {
+	testTable := []struct {
+		name            string
+		expectedShares  uint64
+		expectedDefined bool
+		shouldHaveError bool
+	}{
+		{
+			name:            "set",
+			expectedShares:  2048,
+			expectedDefined: true,
+		},
+		{
+			name:            "default",
+			expectedShares:  1024,
+			expectedDefined: true,
+		},
+		{
+			name:            "zero",
+			expectedShares:  0,
+			expectedDefined: true,
+		},
+		{
+			name:            "nonexistent",
+			expectedDefined: false,
+		},
+		{
+			name:            "invalid",
+			expectedDefined: false,
+			shouldHaveError: true,
+		},
+	}
+
+	cgroups := make(CGroups)
+
+	shares, defined, err := cgroups.CPUShares()
+	assert.Equal(t, uint64(0), shares, "no cpu cgroup")
+	assert.False(t, defined, "no cpu cgroup")
+	assert.NoError(t, err, "no cpu cgroup")
+
+	for _, tt := range testTable {
+		cgroups[_cgroupSubsysCPU] = NewCGroup(filepath.Join(testDataCGroupsPath, "shares", tt.name))
+
+		shares, defined, err := cgroups.CPUShares()
+		assert.Equal(t, tt.expectedShares, shares, tt.name)
+		assert.Equal(t, tt.expectedDefined, defined, tt.name)
+
+		if tt.shouldHaveError {
+			assert.Error(t, err, tt.name)
+		} else {
+			assert.NoError(t, err, tt.name)
+		}
+	}
+}
+
+func TestCGroupsCPUSharesQuota(t *testing.T) {
+	testTable := []struct {
+		name            string
+		expectedQuota   float64
+		expectedDefined bool
+		shouldHaveError bool
+	}{
+		{
+			name:            "set",
+			expectedQuota:   2.0,
+			expectedDefined: true,
+		},
+		{
+			name:            "zero",
+			expectedQuota:   -1.0,
+			expectedDefined: false,
+		},
+		{
+			name:            "nonexistent",
+			expectedQuota:   -1.0,
+			expectedDefined: false,
+		},
+		{
+			name:            "invalid",
+			expectedQuota:   -1.0,
+			expectedDefined: false,
+			shouldHaveError: true,
+		},
+	}
+
+	cgroups := make(CGroups)
+
+	quota, defined, err := cgroups.CPUSharesQuota()
+	assert.Equal(t, -1.0, quota, "no cpu cgroup")
+	assert.False(t, defined, "no cpu cgroup")
+	assert.NoError(t, err, "no cpu cgroup")
+
+	for _, tt := range testTable {
+		cgroups[_cgroupSubsysCPU] = NewCGroup(filepath.Join(testDataCGroupsPath, "shares", tt.name))
+
+		quota, defined, err := cgroups.CPUSharesQuota()
+		assert.Equal(t, tt.expectedQuota, quota, tt.name)
+		assert.Equal(t, tt.expectedDefined, defined, tt.name)
+
+		if tt.shouldHaveError {
+			assert.Error(t, err, tt.name)
+		} else {
+			assert.NoError(t, err, tt.name)
+		}
+	}
+}
+
+func TestNewCGroupsNomad(t *testing.T) {
+	// Nomad places each task in a cgroup named after its allocation and task.
+	// Since Nomad 1.7, tasks are further grouped by whether they share or
+	// reserve cores.
+	tests := []struct {
+		name       string
+		cgroupLine string
+		wantPath   string
+	}{
+		{
+			name:       "v1",
+			cgroupLine: "4:cpu,cpuacct:/nomad/d5ab8ee4-a1f5-0d9b-4bd5-b0c8e5f2ae6c.web",
+			wantPath:   "/sys/fs/cgroup/cpu,cpuacct/nomad/d5ab8ee4-a1f5-0d9b-4bd5-b0c8e5f2ae6c.web",
+		},
+		{
+			name:       "v1 reserved cores",
+			cgroupLine: "4:cpu,cpuacct:/nomad/reserve/d5ab8ee4-a1f5-0d9b-4bd5-b0c8e5f2ae6c.web",
+			wantPath:   "/sys/fs/cgroup/cpu,cpuacct/nomad/reserve/d5ab8ee4-a1f5-0d9b-4bd5-b0c8e5f2ae6c.web",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			procPathCGroup := filepath.Join(dir, "cgroup")
+			require.NoError(t, os.WriteFile(procPathCGroup, []byte(tt.cgroupLine+"\n"), 0o644))
+			procPathMountInfo := filepath.Join(dir, "mountinfo")
+			line := "28 22 0:25 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:11 - cgroup cgroup rw,cpu,cpuacct\n"
+			require.NoError(t, os.WriteFile(procPathMountInfo, []byte(line), 0o644))
+
+			cgroups, err := NewCGroups(procPathMountInfo, procPathCGroup)
+			require.NoError(t, err)
+			require.Contains(t, cgroups, _cgroupSubsysCPU)
+			assert.Equal(t, tt.wantPath, cgroups[_cgroupSubsysCPU].Path())
+		})
+	}
+
+	v2Paths := map[string]string{
+		"v2":                "/nomad.slice/d5ab8ee4-a1f5-0d9b-4bd5-b0c8e5f2ae6c.web.scope",
+		"v2 shared cores":   "/nomad.slice/share.slice/d5ab8ee4-a1f5-0d9b-4bd5-b0c8e5f2ae6c.web.scope",
+		"v2 reserved cores": "/nomad.slice/reserve.slice/d5ab8ee4-a1f5-0d9b-4bd5-b0c8e5f2ae6c.web.scope",
+	}
+	for name, cgroupPath := range v2Paths {
+		t.Run(name, func(t *testing.T) {
+			procPathCGroup := filepath.Join(t.TempDir(), "cgroup")
+			require.NoError(t, os.WriteFile(procPathCGroup, []byte("0::"+cgroupPath+"\n"), 0o644))
+
+			cgroups, err := newCGroups2From(filepath.Join(testDataProcPath, "v2", "mountinfo-v2"), procPathCGroup)
+			require.NoError(t, err)
+			assert.Equal(t, cgroupPath, cgroups.groupPath)
+		})
+	}
+}
+
+func TestCGroupPathForProcFS(t *testing.T) {
+	tests := []struct {
+		name   string
+		cgroup string
+		want   string
+	}{
+		{
+			name: "v1",
+			cgroup: "5:memory:/kubepods/burstable/pod1234/0123456789abcdef\n" +
+				"4:cpu,cpuacct:/kubepods/burstable/pod1234/0123456789abcdef\n" +
+				"1:name=systemd:/kubepods/burstable/pod1234/0123456789abcdef\n",
+			want: "/kubepods/burstable/pod1234/0123456789abcdef",
+		},
+		{
+			name:   "v2",
+			cgroup: "0::/kubepods.slice/kubepods-pod1234.slice/cri-containerd-0123456789abcdef.scope\n",
+			want:   "/kubepods.slice/kubepods-pod1234.slice/cri-containerd-0123456789abcdef.scope",
+		},
+		{
+			name:   "hybrid",
+			cgroup: "4:cpu,cpuacct:/system.slice/app.service\n0::/init.scope\n",
+			want:   "/system.slice/app.service",
+		},
+		{
+			name:   "none",
+			cgroup: "",
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procFS := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(procFS, "self"), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(procFS, "self", "cgroup"), []byte(tt.cgroup), 0o644))
+
+			got, err := CGroupPathForProcFS(procFS)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := CGroupPathForProcFS(t.TempDir())
+		assert.Error(t, err)
+	})
+}
+
+func TestCGroupPathsForProcFS(t *testing.T) {
+	tests := []struct {
+		name   string
+		cgroup string
+		want   map[string]string
+	}{
+		{
+			name: "v1",
+			cgroup: "5:memory:/kubepods/burstable/pod1234/0123456789abcdef\n" +
+				"4:cpu,cpuacct:/kubepods/burstable/pod1234/0123456789abcdef\n" +
+				"1:name=systemd:/system.slice/containerd.service\n",
+			want: map[string]string{
+				"memory":       "/kubepods/burstable/pod1234/0123456789abcdef",
+				"cpu":          "/kubepods/burstable/pod1234/0123456789abcdef",
+				"cpuacct":      "/kubepods/burstable/pod1234/0123456789abcdef",
+				"name=systemd": "/system.slice/containerd.service",
+			},
+		},
+		{
+			name:   "v2",
+			cgroup: "0::/kubepods.slice/kubepods-pod1234.slice\n",
+			want:   map[string]string{"": "/kubepods.slice/kubepods-pod1234.slice"},
+		},
+		{
+			name:   "hybrid",
+			cgroup: "4:cpu,cpuacct:/system.slice/app.service\n0::/init.scope\n",
+			want: map[string]string{
+				"cpu":     "/system.slice/app.service",
+				"cpuacct": "/system.slice/app.service",
+				"":        "/init.scope",
+			},
+		},
+		{
+			name:   "none",
+			cgroup: "",
+			want:   map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procFS := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(procFS, "self"), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(procFS, "self", "cgroup"), []byte(tt.cgroup), 0o644))
+
+			got, err := CGroupPathsForProcFS(procFS)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		procFS := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(procFS, "self"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(procFS, "self", "cgroup"), []byte("cpu:/docker\n"), 0o644))
+
+		_, err := CGroupPathsForProcFS(procFS)
+		assert.ErrorContains(t, err, "invalid format for CGroupSubsys")
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := CGroupPathsForProcFS(t.TempDir())
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestCGroupsMemoryLimit(t *testing.T) {
+	testTable := []struct {
+		name            string
+		expectedValue   uint64
+		expectedDefined bool
+		shouldHaveError bool
+	}{
+		{
+			name:            "v1",
+			expectedValue:   2 << 30,
+			expectedDefined: true,
+		},
+		{
+			name:            "v1-unlimited",
+			expectedDefined: false,
+		},
+		{
+			// The sentinel depends on the page size; this is for 64K pages.
+			name:            "v1-unlimited-64k",
+			expectedDefined: false,
+		},
+		{
+			name:            "nonexistent",
+			expectedDefined: false,
+		},
+		{
+			name:            "invalid",
+			expectedDefined: false,
+			shouldHaveError: true,
+		},
+	}
+
+	cgroups := make(CGroups)
+
+	value, defined, err := cgroups.MemoryLimit()
+	assert.Equal(t, uint64(0), value, "no memory cgroup")
+	assert.False(t, defined, "no memory cgroup")
+	assert.NoError(t, err, "no memory cgroup")
+
+	for _, tt := range testTable {
+		cgroups[_cgroupSubsysMemory] = NewCGroup(filepath.Join(testDataCGroupsPath, "memory", tt.name))
+
+		value, defined, err := cgroups.MemoryLimit()
+		assert.Equal(t, tt.expectedValue, value, tt.name)
+		assert.Equal(t, tt.expectedDefined, defined, tt.name)
+
+		if tt.shouldHaveError {
+			assert.Error(t, err, tt.name)
+		} else {
+			assert.NoError(t, err, tt.name)
+		}
+	}
+}
+
+func TestValidateCPUQuotaDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr string
+	}{
+		{name: "v1", dir: "cpu"},
+		{name: "v1 unlimited", dir: "undefined"},
+		{name: "v2", dir: "cloudrun"},
+		{name: "v1 missing period", dir: "undefined-period", wantErr: "no such file"},
+		{name: "v1 invalid quota", dir: filepath.Join("quota-dirs", "v1-invalid"), wantErr: `parsing "abc"`},
+		{name: "v2 invalid", dir: filepath.Join("quota-dirs", "v2-invalid"), wantErr: "invalid format"},
+		{name: "no quota files", dir: filepath.Join("cpuset", "wide"), wantErr: "no cpu.max or cpu.cfs_quota_us"},
+		{name: "nonexistent", dir: "nonexistent", wantErr: "no such file"},
+		{name: "not a directory", dir: filepath.Join("cpu", "cpu.cfs_quota_us"), wantErr: "not a directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCPUQuotaDir(filepath.Join(testDataCGroupsPath, tt.dir))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cgroups
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	_cpuListSep      = ","
+	_cpuListRangeSep = "-"
+
+	// _sysPathCPUOnline lists the CPUs the kernel has online.
+	_sysPathCPUOnline = "/sys/devices/system/cpu/online"
+
+	// _maxCPUNumber is the highest CPU number accepted in a CPU list, well
+	// above the 8192 CPUs the kernel supports at most, so that a corrupt
+	// list can't claim billions of CPUs.
+	_maxCPUNumber = 1<<16 - 1
+)
+
+// OnlineCPUs returns the number of CPUs the kernel has online, as listed in
+// `/sys/devices/system/cpu/online`, which leaves out offlined CPUs. If the
+// list is unavailable, it returns (0, false, nil).
+func OnlineCPUs() (int, bool, error) {
+	return readOnlineCPUs(_sysPathCPUOnline)
+}
+
+func readOnlineCPUs(onlinePath string) (int, bool, error) {
+	dir, file := filepath.Split(onlinePath)
+	list, err := NewCGroup(dir).readFirstLine(file)
+	if os.IsNotExist(err) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	count, err := parseCPUList(list)
+	if err != nil {
+		return 0, false, err
+	}
+	return count, count > 0, nil
+}
+
+// readIsolatedCPUs returns the list of CPUs isolated from the general
+// scheduler with the isolcpus boot parameter, as listed in the file at
+// isolatedPath, which is empty if there are none or if it's unknown.
+func readIsolatedCPUs(fsys fs.FS, isolatedPath string) (string, error) {
+	dir, file := filepath.Split(isolatedPath)
+	list, err := (&CGroup{path: dir, fsys: fsys}).readFirstLine(file)
+	if os.IsNotExist(err) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", nil
+	}
+	return list, err
+}
+
+// readCPUSet returns the number of CPUs listed in the first non-empty one of
+// the given cpuset params of the cgroup, leaving out those in isolated unless
+// the cpuset holds isolated CPUs only. If there is none, it returns
+// (-1, false, nil).
+func readCPUSet(group *CGroup, isolated string, params ...string) (int, bool, error) {
+	for _, param := range params {
+		list, err := group.readFirstLine(param)
+		if os.IsNotExist(err) || errors.Is(err, io.ErrUnexpectedEOF) {
+			continue
+		}
+		if err != nil {
+			return -1, false, err
+		}
+
+		count, err := countCPUsExcluding(list, isolated)
+		if err != nil {
+			return -1, false, err
+		}
+		if count == 0 {
+			count, err = parseCPUList(list)
+			if err != nil {
+				return -1, false, err
+			}
+		}
+		if count > 0 {
+			return count, true, nil
+		}
+	}
+	return -1, false, nil
+}
+
+// cpuRange is an inclusive range of CPU numbers from a CPU list.
+type cpuRange struct {
+	first, last int
+}
+
+// size returns the number of CPUs in the range.
+func (r cpuRange) size() int {
+	return r.last - r.first + 1
+}
+
+// overlap returns the number of CPUs the range shares with o.
+func (r cpuRange) overlap(o cpuRange) int {
+	first, last := r.first, r.last
+	if o.first > first {
+		first = o.first
+	}
+	if o.last < last {
+		last = o.last
+	}
+	if last < first {
+		return 0
+	}
+	return last - first + 1
+}
+
+// parseCPUList returns the number of CPUs in a list using the kernel's
+// List Format (see cpuset(7)), e.g. `0-3,8,10-11`. An empty list holds no
+// CPUs.
+func parseCPUList(list string) (int, error) {
+	ranges, err := parseCPURanges(list)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, r := range ranges {
+		count += r.size()
+	}
+	return count, nil
+}
+
+// countCPUsExcluding returns the number of CPUs in list that aren't in
+// excluded. Both lists use the kernel's List Format.
+func countCPUsExcluding(list, excluded string) (int, error) {
+	ranges, err := parseCPURanges(list)
+	if err != nil {
+		return 0, err
+	}
+	excludedRanges, err := parseCPURanges(excluded)
+	if err != nil {
+		return 0, err
+	}
+
+	// Merge the excluded ranges so that no CPU is subtracted twice.
+	excludedRanges = mergeCPURanges(excludedRanges)
+
+	var count int
+	for _, r := range ranges {
+		count += r.size()
+		for _, e := range excludedRanges {
+			count -= r.overlap(e)
+		}
+	}
+	return count, nil
+}
+
+// mergeCPURanges sorts ranges and merges those that overlap or adjoin, in
+// place.
+func mergeCPURanges(ranges []cpuRange) []cpuRange {
+	if len(ranges) < 2 {
+		return ranges
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first < ranges[j].first })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.first > last.last+1 {
+			merged = append(merged, r)
+			continue
+		}
+		if r.last > last.last {
+			last.last = r.last
+		}
+	}
+	return merged
+}
+
+// parseCPURanges parses a CPU list using the kernel's List Format into its
+// ranges.
+func parseCPURanges(list string) ([]cpuRange, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+
+	var ranges []cpuRange
+	for rest, more := list, true; more; {
+		var item string
+		item, rest, more = strings.Cut(rest, _cpuListSep)
+		first, last, isRange := strings.Cut(item, _cpuListRangeSep)
+
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q: %w", list, err)
+		}
+
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpu list %q: %w", list, err)
+			}
+		}
+
+		if start < 0 || end < start || end > _maxCPUNumber {
+			return nil, formatInvalidf("invalid cpu range %q in cpu list %q", item, list)
+		}
+		ranges = append(ranges, cpuRange{first: start, last: end})
+	}
+	return ranges, nil
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		list string
+		want int
+	}{
+		{list: "", want: 0},
+		{list: "\n", want: 0},
+		{list: "0", want: 1},
+		{list: "0-3", want: 4},
+		{list: "0-3,8", want: 5},
+		{list: "0-3,6,8-11\n", want: 9},
+		{list: "5-5", want: 1},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCPUList(tt.list)
+		require.NoError(t, err, "%q", tt.list)
+		assert.Equal(t, tt.want, got, "%q", tt.list)
+	}
+}
+
+func TestParseCPUListErrors(t *testing.T) {
+	lists := []string{
+		"a",
+		"0-b",
+		"3-1",
+		"0,,1",
+		"-1",
+		"0-3 8",
+		"65536",
+		"0-4000000000",
+	}
+
+	for _, list := range lists {
+		_, err := parseCPUList(list)
+		assert.Error(t, err, "%q", list)
+	}
+}
+
+func TestCountCPUsExcluding(t *testing.T) {
+	tests := []struct {
+		list     string
+		excluded string
+		want     int
+	}{
+		{list: "0-7", excluded: "", want: 8},
+		{list: "0-7", excluded: "6-7", want: 6},
+		{list: "0-7", excluded: "1,3,5,7", want: 4},
+		{list: "0-3", excluded: "8-11", want: 4},
+		{list: "2-5", excluded: "0-3", want: 2},
+		{list: "0-3", excluded: "0-3", want: 0},
+		{list: "0-7", excluded: "6-7,2-6,7", want: 2},
+		{list: "0-3,8-11", excluded: "2-9", want: 4},
+		{list: "0-65535", excluded: "1-65535", want: 1},
+	}
+
+	for _, tt := range tests {
+		got, err := countCPUsExcluding(tt.list, tt.excluded)
+		require.NoError(t, err, "%q excluding %q", tt.list, tt.excluded)
+		assert.Equal(t, tt.want, got, "%q excluding %q", tt.list, tt.excluded)
+	}
+
+	_, err := countCPUsExcluding("0-3", "x")
+	assert.Error(t, err)
+
+	// Corrupt cpusets claiming billions of CPUs are rejected rather than
+	// counted.
+	_, err = countCPUsExcluding("0-4000000000", "")
+	assert.Error(t, err)
+	_, err = countCPUsExcluding("0-3", "0-9223372036854775807")
+	assert.Error(t, err)
+}
+
+func TestReadOnlineCPUs(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name        string
+		content     string
+		wantCount   int
+		wantDefined bool
+		wantErr     bool
+	}{
+		{name: "ranges", content: "0-3,6,8-11\n", wantCount: 9, wantDefined: true},
+		{name: "single", content: "0\n", wantCount: 1, wantDefined: true},
+		{name: "empty", content: ""},
+		{name: "invalid", content: "0-x\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o644))
+
+			count, defined, err := readOnlineCPUs(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCount, count)
+			assert.Equal(t, tt.wantDefined, defined)
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		count, defined, err := readOnlineCPUs(filepath.Join(dir, "missing"))
+		require.NoError(t, err)
+		assert.False(t, defined)
+		assert.Zero(t, count)
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cgroups
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// _cgroupCPUStatParam is the file name for the CGroup CPU statistics. It
+	// is present in both cgroup v1 and v2.
+	_cgroupCPUStatParam = "cpu.stat"
+	// _cpuStatNrPeriods is the `cpu.stat` key counting the CFS periods that
+	// elapsed with runnable tasks in the cgroup.
+	_cpuStatNrPeriods = "nr_periods"
+	// _cpuStatNrThrottled is the `cpu.stat` key counting the CFS periods in
+	// which the cgroup was throttled.
+	_cpuStatNrThrottled = "nr_throttled"
+	// _cpuStatThrottledTime is the cgroups v1 `cpu.stat` key for the total
+	// time the cgroup was throttled, in nanoseconds.
+	_cpuStatThrottledTime = "throttled_time"
+	// _cpuStatThrottledUsec is the cgroups v2 `cpu.stat` key for the total
+	// time the cgroup was throttled, in microseconds.
+	_cpuStatThrottledUsec = "throttled_usec"
+)
+
+// CPUStat holds the CFS throttling counters of a cgroup, as reported in
+// `cpu.stat`. The counters only grow, so callers compare two readings to
+// tell how much the cgroup was throttled in between.
+type CPUStat struct {
+	// NrPeriods is the number of CFS periods that elapsed with runnable
+	// tasks in the cgroup.
+	NrPeriods uint64
+	// NrThrottled is the number of those periods in which the cgroup used
+	// up its quota and was throttled.
+	NrThrottled uint64
+	// ThrottledTime is the total time the tasks of the cgroup were
+	// throttled for.
+	ThrottledTime time.Duration
+}
+
+// readCPUStat reads the throttling counters from the `cpu.stat` file of the
+// cgroup, in the format of either cgroups v1 or v2. If the file is absent or
+// doesn't report throttling, it returns (CPUStat{}, false, nil).
+func readCPUStat(group *CGroup) (CPUStat, bool, error) {
+	statFile, err := group.open(_cgroupCPUStatParam)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return CPUStat{}, false, nil
+		}
+		return CPUStat{}, false, err
+	}
+	defer statFile.Close()
+
+	return scanCPUStat(statFile, group.ParamPath(_cgroupCPUStatParam))
+}
+
+// scanCPUStat reads the throttling counters from the flat-keyed contents of
+// a `cpu.stat` file read from r. Parse errors report name as the path of the
+// file.
+func scanCPUStat(r io.Reader, name string) (CPUStat, bool, error) {
+	var (
+		stat    CPUStat
+		defined bool
+	)
+	scanner, buf := newScanner(r)
+	defer releaseScanBuffer(buf)
+	for line := 1; scanner.Scan(); line++ {
+		if err := scanner.Err(); err != nil {
+			return CPUStat{}, false, err
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case _cpuStatNrPeriods, _cpuStatNrThrottled, _cpuStatThrottledTime, _cpuStatThrottledUsec:
+		default:
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return CPUStat{}, false, &parseError{path: name, line: line, content: scanner.Text(), err: err}
+		}
+		switch fields[0] {
+		case _cpuStatNrPeriods:
+			stat.NrPeriods = value
+		case _cpuStatNrThrottled:
+			stat.NrThrottled = value
+			defined = true
+		case _cpuStatThrottledTime:
+			stat.ThrottledTime = time.Duration(value)
+		case _cpuStatThrottledUsec:
+			stat.ThrottledTime = time.Duration(value) * time.Microsecond
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return CPUStat{}, false, err
+	}
+
+	if !defined {
+		return CPUStat{}, false, nil
+	}
+	return stat, true, nil
+}
@@ -20,4 +20,8 @@
 
 // Package cgroups provides utilities to access Linux control group (CGroups)
 // parameters (CPU quota, for example) for a given process.
+//
+// The package builds on every OS so that cgroups read from an fs.FS, see
+// NewCGroupsFS, can be inspected anywhere; only a Linux host has cgroups of
+// its own to read.
 package cgroups
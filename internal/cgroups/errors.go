@@ -18,12 +18,25 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-//go:build linux
-// +build linux
-
 package cgroups
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidFormat is matched by the errors reporting cgroup or procfs files
+// whose contents are in an unexpected format.
+var ErrInvalidFormat = errors.New("invalid format")
+
+// ErrInvalidPeriod indicates a CPU quota whose CFS period is zero or
+// negative, which happens with misconfigured or transiently written cgroups.
+// The quota can't be converted into cores then.
+var ErrInvalidPeriod = errors.New("CFS period must be positive")
+
+// ErrFileTooLarge is matched by errors reading a cgroup or procfs file larger
+// than the cap set with LimitReads.
+var ErrFileTooLarge = errors.New("file exceeds maximum read size")
 
 type cgroupSubsysFormatInvalidError struct {
 	line string
@@ -39,14 +52,64 @@ type pathNotExposedFromMountPointError struct {
 	path       string
 }
 
+type formatInvalidError struct {
+	err error
+}
+
+// parseError reports the file and line that caused err, along with the
+// content of the line if err doesn't already quote it.
+type parseError struct {
+	path    string
+	line    int
+	content string
+	err     error
+}
+
+// formatInvalidf formats an error reporting a cgroup parameter in an
+// unexpected format.
+func formatInvalidf(format string, args ...interface{}) error {
+	return formatInvalidError{fmt.Errorf(format, args...)}
+}
+
 func (err cgroupSubsysFormatInvalidError) Error() string {
 	return fmt.Sprintf("invalid format for CGroupSubsys: %q", err.line)
 }
 
+func (err cgroupSubsysFormatInvalidError) Is(target error) bool {
+	return target == ErrInvalidFormat
+}
+
 func (err mountPointFormatInvalidError) Error() string {
 	return fmt.Sprintf("invalid format for MountPoint: %q", err.line)
 }
 
+func (err mountPointFormatInvalidError) Is(target error) bool {
+	return target == ErrInvalidFormat
+}
+
 func (err pathNotExposedFromMountPointError) Error() string {
 	return fmt.Sprintf("path %q is not a descendant of mount point root %q and cannot be exposed from %q", err.path, err.root, err.mountPoint)
 }
+
+func (err *parseError) Error() string {
+	if err.content == "" {
+		return fmt.Sprintf("%v:%d: %v", err.path, err.line, err.err)
+	}
+	return fmt.Sprintf("%v:%d: %q: %v", err.path, err.line, err.content, err.err)
+}
+
+func (err *parseError) Unwrap() error {
+	return err.err
+}
+
+func (err formatInvalidError) Error() string {
+	return err.err.Error()
+}
+
+func (err formatInvalidError) Unwrap() error {
+	return err.err
+}
+
+func (err formatInvalidError) Is(target error) bool {
+	return target == ErrInvalidFormat
+}
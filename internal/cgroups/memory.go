@@ -0,0 +1,65 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cgroups
+
+import (
+	"errors"
+	"os"
+)
+
+const (
+	// _cgroupMemoryLimitParam is the file name for the CGroup memory limit
+	// parameter.
+	_cgroupMemoryLimitParam = "memory.limit_in_bytes"
+	// _cgroupv2MemoryMax is the file name for the CGroup-V2 memory limit
+	// parameter.
+	_cgroupv2MemoryMax = "memory.max"
+	// _cgroupv2MemoryHigh is the file name for the CGroup-V2 memory
+	// throttling threshold parameter.
+	_cgroupv2MemoryHigh = "memory.high"
+
+	// _cgroupMemoryUnlimitedMin is the smallest memory limit considered to
+	// be no limit at all. cgroups v1 reports an unlimited cgroup as the
+	// largest page-aligned int64, whose exact value depends on the page size.
+	_cgroupMemoryUnlimitedMin = 1 << 62
+)
+
+// readMemoryLimit reads the memory limit in bytes from the given parameter
+// of the cgroup. If the parameter is absent or holds no limit, it returns
+// (0, false, nil).
+func readMemoryLimit(cg *CGroup, param string) (uint64, bool, error) {
+	text, err := cg.readFirstLine(param)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	limit, err := parseUint64Value(text)
+	if errors.Is(err, ErrUnlimited) || limit >= _cgroupMemoryUnlimitedMin {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, cg.parseError(param, text, err)
+	}
+	return limit, true, nil
+}
@@ -18,23 +18,25 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-//go:build linux
-// +build linux
-
 package cgroups
 
 import (
-	"bufio"
-	"os"
+	"io"
+	"io/fs"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const (
 	_mountInfoSep               = " "
 	_mountInfoOptsSep           = ","
 	_mountInfoOptionalFieldsSep = "-"
+
+	// _9pFSType is the filesystem type of the drvfs mounts of WSL, whose
+	// super options hold Windows paths with unescaped spaces.
+	_9pFSType = "9p"
 )
 
 const (
@@ -77,7 +79,33 @@ type MountPoint struct {
 // NewMountPointFromLine parses a line read from `/proc/$PID/mountinfo` and
 // returns a new *MountPoint.
 func NewMountPointFromLine(line string) (*MountPoint, error) {
-	fields := strings.Split(line, _mountInfoSep)
+	var p mountInfoParser
+	return p.parse(line)
+}
+
+// _mountInfoParsers pools the parsers of `mountinfo` files, which are read
+// line by line several times over during detection.
+var _mountInfoParsers = sync.Pool{New: func() any { return new(mountInfoParser) }}
+
+// mountInfoParser parses lines of `/proc/$PID/mountinfo`, reusing the slice
+// holding the fields of a line from one line to the next.
+type mountInfoParser struct {
+	fields []string
+}
+
+// parse parses a line read from `/proc/$PID/mountinfo` into a new
+// *MountPoint, which doesn't refer to the parser's buffers.
+func (p *mountInfoParser) parse(line string) (*MountPoint, error) {
+	// Find where the optional fields end before splitting, so that the line
+	// is split only once, with a limit to avoid issues with spaces in super
+	// options as present on WSL.
+	fsTypeStart := findFSTypeStart(line)
+	limit := -1
+	if fsTypeStart >= 0 {
+		limit = fsTypeStart + _miFieldCountSecondHalf
+	}
+	p.fields = splitFields(p.fields[:0], line, _mountInfoSep, limit)
+	fields := p.fields
 
 	if len(fields) < _miFieldCountMin {
 		return nil, mountPointFormatInvalidError{line}
@@ -93,42 +121,139 @@ func NewMountPointFromLine(line string) (*MountPoint, error) {
 		return nil, err
 	}
 
-	for i, field := range fields[_miFieldIDOptionalFields:] {
-		if field == _mountInfoOptionalFieldsSep {
-			// End of optional fields.
-			fsTypeStart := _miFieldIDOptionalFields + i + 1
+	if fsTypeStart < 0 || len(fields) != fsTypeStart+_miFieldCountSecondHalf {
+		return nil, mountPointFormatInvalidError{line}
+	}
+
+	miFieldIDFSType := _miFieldOffsetFSType + fsTypeStart
+	miFieldIDMountSource := _miFieldOffsetMountSource + fsTypeStart
+	miFieldIDSuperOptions := _miFieldOffsetSuperOptions + fsTypeStart
+
+	// Back the three lists of the mount point with a single slice, capping
+	// each so that appending to one can't overwrite the next.
+	options := fields[_miFieldIDOptions]
+	optionalFields := fields[_miFieldIDOptionalFields:(fsTypeStart - 1)]
+	superOptions := trimTrailingFields(fields[miFieldIDFSType], fields[miFieldIDSuperOptions])
+	optionsEnd := strings.Count(options, _mountInfoOptsSep) + 1
+	optionalFieldsEnd := optionsEnd + len(optionalFields)
+	lists := make([]string, 0, optionalFieldsEnd+strings.Count(superOptions, _mountInfoOptsSep)+1)
+	lists = splitFields(lists, options, _mountInfoOptsSep, -1)
+	lists = append(lists, optionalFields...)
+	lists = splitFields(lists, superOptions, _mountInfoOptsSep, -1)
+
+	return &MountPoint{
+		MountID:        mountID,
+		ParentID:       parentID,
+		DeviceID:       fields[_miFieldIDDeviceID],
+		Root:           unescapeMountInfo(fields[_miFieldIDRoot]),
+		MountPoint:     unescapeMountInfo(fields[_miFieldIDMountPoint]),
+		Options:        lists[:optionsEnd:optionsEnd],
+		OptionalFields: lists[optionsEnd:optionalFieldsEnd:optionalFieldsEnd],
+		FSType:         fields[miFieldIDFSType],
+		MountSource:    unescapeMountInfo(fields[miFieldIDMountSource]),
+		SuperOptions:   lists[optionalFieldsEnd:],
+	}, nil
+}
+
+// splitFields appends the substrings of s separated by sep to dst, like
+// strings.SplitN with a non-zero limit of n, without allocating if dst has
+// room.
+func splitFields(dst []string, s, sep string, n int) []string {
+	for ; n != 1; n-- {
+		i := strings.Index(s, sep)
+		if i < 0 {
+			break
+		}
+		dst = append(dst, s[:i])
+		s = s[i+len(sep):]
+	}
+	return append(dst, s)
+}
+
+// unescapeMountInfo decodes the octal escapes, such as `\040` for a space,
+// that the kernel writes in place of spaces, tabs, newlines and backslashes
+// in the paths of `/proc/$PID/mountinfo`. A backslash not followed by three
+// octal digits is kept as is.
+func unescapeMountInfo(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
 
-			// Now we know where the optional fields end, split the line again with a
-			// limit to avoid issues with spaces in super options as present on WSL.
-			fields = strings.SplitN(line, _mountInfoSep, fsTypeStart+_miFieldCountSecondHalf)
-			if len(fields) != fsTypeStart+_miFieldCountSecondHalf {
-				return nil, mountPointFormatInvalidError{line}
+	var b strings.Builder
+	b.Grow(len(field))
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if c, ok := octalByte(field[i+1 : i+4]); ok {
+				b.WriteByte(c)
+				i += 3
+				continue
 			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// octalByte decodes the three octal digits of an escape, reporting false if
+// s doesn't hold a byte in octal.
+func octalByte(s string) (byte, bool) {
+	v, err := strconv.ParseUint(s, 8, 8)
+	if err != nil {
+		return 0, false
+	}
+	return byte(v), true
+}
 
-			miFieldIDFSType := _miFieldOffsetFSType + fsTypeStart
-			miFieldIDMountSource := _miFieldOffsetMountSource + fsTypeStart
-			miFieldIDSuperOptions := _miFieldOffsetSuperOptions + fsTypeStart
-
-			return &MountPoint{
-				MountID:        mountID,
-				ParentID:       parentID,
-				DeviceID:       fields[_miFieldIDDeviceID],
-				Root:           fields[_miFieldIDRoot],
-				MountPoint:     fields[_miFieldIDMountPoint],
-				Options:        strings.Split(fields[_miFieldIDOptions], _mountInfoOptsSep),
-				OptionalFields: fields[_miFieldIDOptionalFields:(fsTypeStart - 1)],
-				FSType:         fields[miFieldIDFSType],
-				MountSource:    fields[miFieldIDMountSource],
-				SuperOptions:   strings.Split(fields[miFieldIDSuperOptions], _mountInfoOptsSep),
-			}, nil
+// trimTrailingFields removes any fields following the super options, which
+// future kernels may add, from the rest of a line of `/proc/$PID/mountinfo`.
+// The kernel escapes spaces in super options, so they're a single field,
+// except on the 9p mounts of WSL: there they're taken to extend up to the
+// field holding their last separator.
+func trimTrailingFields(fsType, rest string) string {
+	if fsType != _9pFSType {
+		if end := strings.Index(rest, _mountInfoSep); end >= 0 {
+			return rest[:end]
 		}
+		return rest
 	}
 
-	return nil, mountPointFormatInvalidError{line}
+	lastOpt := strings.LastIndex(rest, _mountInfoOptsSep)
+	if lastOpt < 0 {
+		lastOpt = 0
+	}
+	if end := strings.Index(rest[lastOpt:], _mountInfoSep); end >= 0 {
+		return rest[:lastOpt+end]
+	}
+	return rest
 }
 
-// Translate converts an absolute path inside the *MountPoint's file system to
-// the host file system path in the mount namespace the *MountPoint belongs to.
+// findFSTypeStart returns the index of the filesystem type field in a line
+// of `/proc/$PID/mountinfo`, i.e. the index following the separator that
+// ends the optional fields, or -1 if there is no such separator.
+func findFSTypeStart(line string) int {
+	for i := 0; ; i++ {
+		end := strings.Index(line, _mountInfoSep)
+		field := line
+		if end >= 0 {
+			field = line[:end]
+		}
+		if i >= _miFieldIDOptionalFields && field == _mountInfoOptionalFieldsSep {
+			return i + 1
+		}
+		if end < 0 {
+			return -1
+		}
+		line = line[end+len(_mountInfoSep):]
+	}
+}
+
+// Translate converts absPath, a path in the cgroup hierarchy such as a cgroup
+// listed in `/proc/$PID/cgroup`, to the corresponding path under the mount
+// point. absPath must lie within the root of the mount. If it doesn't, but
+// starts with a trailing part of the root, it's taken to be relative to a
+// cgroup namespace rooted at the leading part of the root instead; this is
+// the case for bind-mounted cgroups of nested containers, e.g. kubelet
+// running inside a Docker container.
 func (mp *MountPoint) Translate(absPath string) (string, error) {
 	relPath, err := filepath.Rel(mp.Root, absPath)
 
@@ -136,6 +261,9 @@ func (mp *MountPoint) Translate(absPath string) (string, error) {
 		return "", err
 	}
 	if relPath == ".." || strings.HasPrefix(relPath, "../") {
+		if relPath, ok := mp.relToRootSuffix(absPath); ok {
+			return filepath.Join(mp.MountPoint, relPath), nil
+		}
 		return "", pathNotExposedFromMountPointError{
 			mountPoint: mp.MountPoint,
 			root:       mp.Root,
@@ -146,22 +274,67 @@ func (mp *MountPoint) Translate(absPath string) (string, error) {
 	return filepath.Join(mp.MountPoint, relPath), nil
 }
 
+// relToRootSuffix returns absPath relative to the longest trailing part of
+// the mount root, made of whole path components, that absPath lies within.
+// It reports false if there is none.
+func (mp *MountPoint) relToRootSuffix(absPath string) (string, bool) {
+	if !filepath.IsAbs(absPath) {
+		return "", false
+	}
+	absPath = filepath.Clean(absPath)
+
+	root := filepath.Clean(mp.Root)
+	for i := 1; i < len(root); i++ {
+		if root[i] != '/' {
+			continue
+		}
+		suffix := root[i:]
+		if absPath == suffix {
+			return ".", true
+		}
+		if strings.HasPrefix(absPath, suffix+"/") {
+			return absPath[len(suffix)+1:], true
+		}
+	}
+	return "", false
+}
+
 // parseMountInfo parses procPathMountInfo (usually at `/proc/$PID/mountinfo`)
 // and yields parsed *MountPoint into newMountPoint.
 func parseMountInfo(procPathMountInfo string, newMountPoint func(*MountPoint) error) error {
-	mountInfoFile, err := os.Open(procPathMountInfo)
+	return parseMountInfoFS(nil, procPathMountInfo, newMountPoint)
+}
+
+// parseMountInfoFS is parseMountInfo reading procPathMountInfo from fsys, or
+// from the operating system if fsys is nil.
+func parseMountInfoFS(fsys fs.FS, procPathMountInfo string, newMountPoint func(*MountPoint) error) error {
+	mountInfoFile, err := openFile(fsys, procPathMountInfo)
 	if err != nil {
 		return err
 	}
 	defer mountInfoFile.Close()
 
-	scanner := bufio.NewScanner(mountInfoFile)
+	return readMountInfo(mountInfoFile, procPathMountInfo, newMountPoint)
+}
 
-	for scanner.Scan() {
-		mountPoint, err := NewMountPointFromLine(scanner.Text())
-		if err != nil {
+// readMountInfo parses the contents of a `mountinfo` file from r and yields
+// parsed *MountPoint into newMountPoint. Parse errors report name as the
+// path of the file.
+func readMountInfo(r io.Reader, name string, newMountPoint func(*MountPoint) error) error {
+	scanner, buf := newScanner(r)
+	defer releaseScanBuffer(buf)
+
+	parser := _mountInfoParsers.Get().(*mountInfoParser)
+	defer _mountInfoParsers.Put(parser)
+
+	for line := 1; scanner.Scan(); line++ {
+		if err := scanner.Err(); err != nil {
 			return err
 		}
+		mountPoint, err := parser.parse(scanner.Text())
+		if err != nil {
+			return &parseError{path: name, line: line, err: err}
+		}
 		if err := newMountPoint(mountPoint); err != nil {
 			return err
 		}
@@ -27,6 +27,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewMountPointFromLine(t *testing.T) {
@@ -67,6 +68,102 @@ func TestNewMountPointFromLine(t *testing.T) {
 				SuperOptions:   []string{"rw", "cpu"},
 			},
 		},
+		{
+			name: "multiple optional fields",
+			line: "31 23 0:24 /docker /sys/fs/cgroup/cpu rw,nosuid,nodev,noexec,relatime shared:1 master:2 propagate_from:3 - cgroup cgroup rw,cpu",
+			expected: &MountPoint{
+				MountID:        31,
+				ParentID:       23,
+				DeviceID:       "0:24",
+				Root:           "/docker",
+				MountPoint:     "/sys/fs/cgroup/cpu",
+				Options:        []string{"rw", "nosuid", "nodev", "noexec", "relatime"},
+				OptionalFields: []string{"shared:1", "master:2", "propagate_from:3"},
+				FSType:         "cgroup",
+				MountSource:    "cgroup",
+				SuperOptions:   []string{"rw", "cpu"},
+			},
+		},
+		{
+			name: "unknown optional fields",
+			line: "31 23 0:24 /docker /sys/fs/cgroup/cpu rw,nosuid,nodev,noexec,relatime unbindable future-tag:a:b - cgroup cgroup rw,cpu",
+			expected: &MountPoint{
+				MountID:        31,
+				ParentID:       23,
+				DeviceID:       "0:24",
+				Root:           "/docker",
+				MountPoint:     "/sys/fs/cgroup/cpu",
+				Options:        []string{"rw", "nosuid", "nodev", "noexec", "relatime"},
+				OptionalFields: []string{"unbindable", "future-tag:a:b"},
+				FSType:         "cgroup",
+				MountSource:    "cgroup",
+				SuperOptions:   []string{"rw", "cpu"},
+			},
+		},
+		{
+			name: "trailing fields",
+			line: "31 23 0:24 /docker /sys/fs/cgroup/cpu rw,nosuid,nodev,noexec,relatime shared:1 - cgroup cgroup rw,cpu future:1 future:2",
+			expected: &MountPoint{
+				MountID:        31,
+				ParentID:       23,
+				DeviceID:       "0:24",
+				Root:           "/docker",
+				MountPoint:     "/sys/fs/cgroup/cpu",
+				Options:        []string{"rw", "nosuid", "nodev", "noexec", "relatime"},
+				OptionalFields: []string{"shared:1"},
+				FSType:         "cgroup",
+				MountSource:    "cgroup",
+				SuperOptions:   []string{"rw", "cpu"},
+			},
+		},
+		{
+			name: "trailing field after single super option",
+			line: "3 1 0:2 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw future:1",
+			expected: &MountPoint{
+				MountID:        3,
+				ParentID:       1,
+				DeviceID:       "0:2",
+				Root:           "/",
+				MountPoint:     "/proc",
+				Options:        []string{"rw", "nosuid", "nodev", "noexec", "relatime"},
+				OptionalFields: []string{},
+				FSType:         "proc",
+				MountSource:    "proc",
+				SuperOptions:   []string{"rw"},
+			},
+		},
+		{
+			name: "trailing field with separator",
+			line: "31 23 0:24 /docker /sys/fs/cgroup/cpu rw,nosuid - cgroup cgroup rw,x=1 extra,field",
+			expected: &MountPoint{
+				MountID:        31,
+				ParentID:       23,
+				DeviceID:       "0:24",
+				Root:           "/docker",
+				MountPoint:     "/sys/fs/cgroup/cpu",
+				Options:        []string{"rw", "nosuid"},
+				OptionalFields: []string{},
+				FSType:         "cgroup",
+				MountSource:    "cgroup",
+				SuperOptions:   []string{"rw", "x=1"},
+			},
+		},
+		{
+			name: "escaped",
+			line: `42 23 0:24 /my\040pod /sys/fs/cgroup/cpu\011x rw,nosuid - cgroup back\134slash rw,cpu`,
+			expected: &MountPoint{
+				MountID:        42,
+				ParentID:       23,
+				DeviceID:       "0:24",
+				Root:           "/my pod",
+				MountPoint:     "/sys/fs/cgroup/cpu\tx",
+				Options:        []string{"rw", "nosuid"},
+				OptionalFields: []string{},
+				FSType:         "cgroup",
+				MountSource:    `back\slash`,
+				SuperOptions:   []string{"rw", "cpu"},
+			},
+		},
 		{
 			name: "wsl",
 			line: `560 77 0:138 / /Docker/host rw,noatime - 9p drvfs rw,dirsync,aname=drvfs;path=C:\Program Files\Docker\Docker\resources;symlinkroot=/mnt/,mmap,access=client,msize=262144,trans=virtio`,
@@ -101,6 +198,36 @@ func TestNewMountPointFromLine(t *testing.T) {
 	}
 }
 
+func TestNewMountPointFromLineListsDontAlias(t *testing.T) {
+	mountPoint, err := NewMountPointFromLine(
+		"31 23 0:24 /docker /sys/fs/cgroup/cpu rw,nosuid shared:1 - cgroup cgroup rw,cpu")
+	require.NoError(t, err)
+
+	_ = append(mountPoint.Options, "x")
+	_ = append(mountPoint.OptionalFields, "y")
+	assert.Equal(t, []string{"shared:1"}, mountPoint.OptionalFields)
+	assert.Equal(t, []string{"rw", "cpu"}, mountPoint.SuperOptions)
+}
+
+func TestUnescapeMountInfo(t *testing.T) {
+	tests := []struct {
+		give string
+		want string
+	}{
+		{give: "/plain", want: "/plain"},
+		{give: `/a\040b`, want: "/a b"},
+		{give: `\040\011\012\134`, want: " \t\n\\"},
+		{give: `/trailing\04`, want: `/trailing\04`},
+		{give: `/not\08octal`, want: `/not\08octal`},
+		{give: `/overflow\400`, want: `/overflow\400`},
+		{give: `/docker\abc`, want: `/docker\abc`},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, unescapeMountInfo(tt.give), tt.give)
+	}
+}
+
 func TestNewMountPointFromLineErr(t *testing.T) {
 	linesWithInvalidIDs := []string{
 		"invalidMountID 0 252:0 / / rw,noatime - ext4 /dev/dm-0 rw,errors=remount-ro,data=ordered",
@@ -119,6 +246,7 @@ func TestNewMountPointFromLineErr(t *testing.T) {
 		"1 0 252:0 / / rw,noatime shared:1 - ext4 /dev/dm-0",
 		"1 0 252:0 / / rw,noatime shared:1 ext4 - /dev/dm-0 rw,errors=remount-ro,data=ordered",
 		"1 0 252:0 / / rw,noatime shared:1 ext4 /dev/dm-0 rw,errors=remount-ro,data=ordered",
+		"1 0 252:0 / / rw,noatime shared:1 master:2 propagate_from:3 ext4 /dev/dm-0 rw,errors=remount-ro,data=ordered",
 		"random line",
 	}
 
@@ -167,6 +295,74 @@ func TestMountPointTranslate(t *testing.T) {
 	}
 }
 
+func TestMountPointTranslateBackslash(t *testing.T) {
+	// cgroup paths are POSIX paths, so a backslash is an ordinary character
+	// and never a separator.
+	line := `31 23 0:24 /docker\abc /sys/fs/cgroup/cpu rw,nosuid,nodev,noexec,relatime shared:1 - cgroup cgroup rw,cpu`
+	cgroupMountPoint, err := NewMountPointFromLine(line)
+	require.NoError(t, err)
+	assert.Equal(t, `/docker\abc`, cgroupMountPoint.Root)
+
+	path, err := cgroupMountPoint.Translate(`/docker\abc/large\child`)
+	require.NoError(t, err)
+	assert.Equal(t, `/sys/fs/cgroup/cpu/large\child`, path)
+
+	_, err = cgroupMountPoint.Translate(`/docker`)
+	assert.Error(t, err, "a backslash must not split the root into path components")
+}
+
+func TestMountPointTranslateBindMountedRoot(t *testing.T) {
+	// A pod of a kubelet running in a Docker container (e.g. kind): the
+	// mountinfo root includes the node container's cgroup, while the pod's
+	// cgroups, relative to the node's cgroup namespace, don't.
+	line := "1352 1343 0:27 /docker/9f3e5b1a2c4d/kubepods/burstable/pod6b2d0c4e-6b7a-4b8e-9c1d-2f3a4b5c6d7e/1a2b3c4d5e6f " +
+		"/sys/fs/cgroup/cpu,cpuacct ro,nosuid,nodev,noexec,relatime master:12 - cgroup cgroup rw,cpu,cpuacct"
+	cgroupMountPoint, err := NewMountPointFromLine(line)
+	require.NoError(t, err)
+
+	testTable := []struct {
+		name            string
+		pathToTranslate string
+		pathTranslated  string
+	}{
+		{
+			name:            "container",
+			pathToTranslate: "/kubepods/burstable/pod6b2d0c4e-6b7a-4b8e-9c1d-2f3a4b5c6d7e/1a2b3c4d5e6f",
+			pathTranslated:  "/sys/fs/cgroup/cpu,cpuacct",
+		},
+		{
+			name:            "descendant-of-container",
+			pathToTranslate: "/kubepods/burstable/pod6b2d0c4e-6b7a-4b8e-9c1d-2f3a4b5c6d7e/1a2b3c4d5e6f/child",
+			pathTranslated:  "/sys/fs/cgroup/cpu,cpuacct/child",
+		},
+		{
+			name:            "shorter-suffix",
+			pathToTranslate: "/1a2b3c4d5e6f",
+			pathTranslated:  "/sys/fs/cgroup/cpu,cpuacct",
+		},
+	}
+
+	for _, tt := range testTable {
+		path, err := cgroupMountPoint.Translate(tt.pathToTranslate)
+		assert.Equal(t, tt.pathTranslated, path, tt.name)
+		assert.NoError(t, err, tt.name)
+	}
+
+	inaccessiblePaths := []string{
+		"/kubepods/burstable",
+		"/kubepods/burstable/pod6b2d0c4e-6b7a-4b8e-9c1d-2f3a4b5c6d7e/1a2b3c4d5e6f-other",
+		"/kubepods/burstable/pod6b2d0c4e-6b7a-4b8e-9c1d-2f3a4b5c6d7e/1a2b3c4d5e6f/../../other",
+		"/1a2b3c4d5e6f/../../etc",
+		"1a2b3c4d5e6f",
+	}
+
+	for _, path := range inaccessiblePaths {
+		translated, err := cgroupMountPoint.Translate(path)
+		assert.Equal(t, "", translated, path)
+		assert.Error(t, err, path)
+	}
+}
+
 func TestMountPointTranslateError(t *testing.T) {
 	line := "31 23 0:24 /docker/0123456789abcdef /sys/fs/cgroup/cpu rw,nosuid,nodev,noexec,relatime shared:1 - cgroup cgroup rw,cpu"
 	cgroupMountPoint, err := NewMountPointFromLine(line)
@@ -0,0 +1,63 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cgroups
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// _cgroupProcsParam is the file name listing the PIDs of the processes in a
+// cgroup, one per line. It is present in both cgroup v1 and v2.
+const _cgroupProcsParam = "cgroup.procs"
+
+// readProcessCount counts the processes listed in the `cgroup.procs` file of
+// the cgroup. If the file is absent, it returns (0, false, nil).
+func readProcessCount(group *CGroup) (int, bool, error) {
+	procsFile, err := group.open(_cgroupProcsParam)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	defer procsFile.Close()
+
+	return countProcesses(procsFile)
+}
+
+// countProcesses counts the non-empty lines of a `cgroup.procs` file read
+// from r.
+func countProcesses(r io.Reader) (int, bool, error) {
+	count := 0
+	scanner, buf := newScanner(r)
+	defer releaseScanBuffer(buf)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+			count++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, false, err
+	}
+	return count, count > 0, nil
+}
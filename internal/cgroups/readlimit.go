@@ -0,0 +1,97 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cgroups
+
+import (
+	"io"
+	"io/fs"
+)
+
+// DefaultMaxReadSize is the most bytes read from a cgroup or procfs file
+// unless changed with LimitReads. Real files are far smaller; the cap guards
+// against a corrupt or hostile procfs presenting an endless one.
+const DefaultMaxReadSize = 4 << 20
+
+// LimitReads returns a filesystem reading the same files as fsys, or as the
+// operating system if fsys is nil, but failing reads past the first n bytes
+// of each file with ErrFileTooLarge. With n <= 0, it returns fsys as is,
+// whose files are capped at DefaultMaxReadSize.
+func LimitReads(fsys fs.FS, n int64) fs.FS {
+	if n <= 0 {
+		return fsys
+	}
+	return limitedFS{fsys: fsys, maxReadSize: n}
+}
+
+// limitedFS is a filesystem whose reads are capped, see LimitReads. openFile
+// and Stat look through it to fsys.
+type limitedFS struct {
+	fsys        fs.FS
+	maxReadSize int64
+}
+
+func (l limitedFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return openFile(l, "/"+name)
+}
+
+// unwrapLimit returns the filesystem fsys reads from, and the cap on reads
+// from each of its files.
+func unwrapLimit(fsys fs.FS) (fs.FS, int64) {
+	if l, ok := fsys.(limitedFS); ok {
+		return l.fsys, l.maxReadSize
+	}
+	return fsys, DefaultMaxReadSize
+}
+
+// limitedFile fails reads past the first remaining bytes of a file. Note that
+// a bufio.Scanner still yields the line cut off by such a failure, so
+// scanners check Err before parsing each line.
+type limitedFile struct {
+	fs.File
+	name      string
+	remaining int64
+}
+
+func limitReads(f fs.File, name string, maxReadSize int64) fs.File {
+	return &limitedFile{File: f, name: name, remaining: maxReadSize}
+}
+
+func (f *limitedFile) Read(p []byte) (int, error) {
+	if f.remaining <= 0 {
+		// Files of exactly the maximum size are fine; only fail if there's
+		// more to read.
+		var probe [1]byte
+		if n, _ := f.File.Read(probe[:]); n > 0 {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: ErrFileTooLarge}
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.File.Read(p)
+	f.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package cgroups
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxReadSizeMountInfo(t *testing.T) {
+	mountInfoPath := filepath.Join(testDataProcPath, "cgroups", "mountinfo")
+	cgroupPath := filepath.Join(testDataProcPath, "cgroups", "cgroup")
+	info, err := os.Stat(mountInfoPath)
+	require.NoError(t, err)
+
+	t.Run("default", func(t *testing.T) {
+		_, err := newCGroupsFS(nil, mountInfoPath, cgroupPath)
+		assert.NoError(t, err)
+	})
+
+	t.Run("exact", func(t *testing.T) {
+		_, err := newCGroupsFS(LimitReads(nil, info.Size()), mountInfoPath, cgroupPath)
+		assert.NoError(t, err)
+	})
+
+	t.Run("oversized", func(t *testing.T) {
+		_, err := newCGroupsFS(LimitReads(nil, info.Size()/2), mountInfoPath, cgroupPath)
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+		assert.ErrorContains(t, err, mountInfoPath)
+	})
+}
+
+func TestMaxReadSizeCPUMax(t *testing.T) {
+	mountPoint := t.TempDir()
+	padded := "50000" + strings.Repeat(" ", 1<<10) + "100000\n"
+	require.NoError(t, os.WriteFile(filepath.Join(mountPoint, _cgroupv2CPUMax), []byte(padded), 0o644))
+	cgroups := &CGroups2{mountPoint: mountPoint, groupPath: "/", cpuMaxFile: _cgroupv2CPUMax}
+
+	quota, defined, err := cgroups.CPUQuota()
+	require.NoError(t, err)
+	assert.True(t, defined)
+	assert.Equal(t, 0.5, quota)
+
+	cgroups.fsys = LimitReads(nil, 512)
+	_, _, err = cgroups.CPUQuota()
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+}
+
+func TestMaxReadSizeCGroupParam(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, _cgroupCPUCFSQuotaUsParam), []byte(strings.Repeat("1", 64)+"\n"), 0o644))
+
+	cgroup := &CGroup{path: dir, fsys: LimitReads(nil, 16)}
+	_, err := cgroup.readInt(_cgroupCPUCFSQuotaUsParam)
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+}
+
+func TestLimitReadsFS(t *testing.T) {
+	data := []byte(strings.Repeat("1", 32))
+	fsys := fstest.MapFS{"proc/version": {Data: data}}
+
+	got, err := ReadFile(LimitReads(fsys, 32), "/proc/version")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	_, err = ReadFile(LimitReads(fsys, 16), "/proc/version")
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+
+	_, err = fs.ReadFile(LimitReads(fsys, 16), "proc/version")
+	assert.ErrorIs(t, err, ErrFileTooLarge, "should cap reads through Open too")
+
+	info, err := Stat(LimitReads(fsys, 16), "/proc/version")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size())
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cgroups
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// _scanBufferSize is the initial size of the buffers of line scanners, as
+// used by bufio.Scanner. Longer lines grow the buffer, up to
+// bufio.MaxScanTokenSize.
+const _scanBufferSize = 4096
+
+// _scanBuffers pools the buffers of line scanners, so that the many small
+// cgroup and procfs files read during detection share them.
+var _scanBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, _scanBufferSize)
+		return &buf
+	},
+}
+
+// newScanner returns a line scanner reading from r into a buffer taken from
+// the pool. Once done with the scanner, and the bytes it returned, hand the
+// buffer back with releaseScanBuffer.
+func newScanner(r io.Reader) (*bufio.Scanner, *[]byte) {
+	buf := _scanBuffers.Get().(*[]byte)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(*buf, bufio.MaxScanTokenSize)
+	return scanner, buf
+}
+
+// releaseScanBuffer hands a buffer returned by newScanner back to the pool.
+func releaseScanBuffer(buf *[]byte) {
+	_scanBuffers.Put(buf)
+}
@@ -18,14 +18,11 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-//go:build linux
-// +build linux
-
 package cgroups
 
 import (
-	"bufio"
-	"os"
+	"io"
+	"io/fs"
 	"strconv"
 	"strings"
 )
@@ -35,13 +32,6 @@ const (
 	_cgroupSubsysSep = ","
 )
 
-const (
-	_csFieldIDID = iota
-	_csFieldIDSubsystems
-	_csFieldIDName
-	_csFieldCount
-)
-
 // CGroupSubsys represents the data structure for entities in
 // `/proc/$PID/cgroup`. See also proc(5) for more information.
 type CGroupSubsys struct {
@@ -53,21 +43,23 @@ type CGroupSubsys struct {
 // NewCGroupSubsysFromLine returns a new *CGroupSubsys by parsing a string in
 // the format of `/proc/$PID/cgroup`
 func NewCGroupSubsysFromLine(line string) (*CGroupSubsys, error) {
-	fields := strings.SplitN(line, _cgroupSep, _csFieldCount)
-
-	if len(fields) != _csFieldCount {
+	// Cut the line into its three fields rather than splitting it, saving
+	// the slice of fields; the name may itself hold the separator.
+	idField, rest, ok := strings.Cut(line, _cgroupSep)
+	subsysField, name, ok2 := strings.Cut(rest, _cgroupSep)
+	if !ok || !ok2 {
 		return nil, cgroupSubsysFormatInvalidError{line}
 	}
 
-	id, err := strconv.Atoi(fields[_csFieldIDID])
+	id, err := strconv.Atoi(idField)
 	if err != nil {
 		return nil, err
 	}
 
 	cgroup := &CGroupSubsys{
 		ID:         id,
-		Subsystems: strings.Split(fields[_csFieldIDSubsystems], _cgroupSubsysSep),
-		Name:       fields[_csFieldIDName],
+		Subsystems: strings.Split(subsysField, _cgroupSubsysSep),
+		Name:       name,
 	}
 
 	return cgroup, nil
@@ -76,19 +68,35 @@ func NewCGroupSubsysFromLine(line string) (*CGroupSubsys, error) {
 // parseCGroupSubsystems parses procPathCGroup (usually at `/proc/$PID/cgroup`)
 // and returns a new map[string]*CGroupSubsys.
 func parseCGroupSubsystems(procPathCGroup string) (map[string]*CGroupSubsys, error) {
-	cgroupFile, err := os.Open(procPathCGroup)
+	return parseCGroupSubsystemsFS(nil, procPathCGroup)
+}
+
+// parseCGroupSubsystemsFS is parseCGroupSubsystems reading procPathCGroup
+// from fsys, or from the operating system if fsys is nil.
+func parseCGroupSubsystemsFS(fsys fs.FS, procPathCGroup string) (map[string]*CGroupSubsys, error) {
+	cgroupFile, err := openFile(fsys, procPathCGroup)
 	if err != nil {
 		return nil, err
 	}
 	defer cgroupFile.Close()
 
-	scanner := bufio.NewScanner(cgroupFile)
+	return readCGroupSubsystems(cgroupFile, procPathCGroup)
+}
+
+// readCGroupSubsystems parses the contents of a `cgroup` file from r. Parse
+// errors report name as the path of the file.
+func readCGroupSubsystems(r io.Reader, name string) (map[string]*CGroupSubsys, error) {
+	scanner, buf := newScanner(r)
+	defer releaseScanBuffer(buf)
 	subsystems := make(map[string]*CGroupSubsys)
 
-	for scanner.Scan() {
+	for line := 1; scanner.Scan(); line++ {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
 		cgroup, err := NewCGroupSubsysFromLine(scanner.Text())
 		if err != nil {
-			return nil, err
+			return nil, &parseError{path: name, line: line, err: err}
 		}
 		for _, subsys := range cgroup.Subsystems {
 			subsystems[subsys] = cgroup
@@ -63,6 +63,15 @@ func TestNewCGroupSubsysFromLine(t *testing.T) {
 				Name:       "/system.slice/containerd.service/kubepods-besteffort-podb41662f7_b03a_4c65_8ef9_6e4e55c3cf27.slice:cri-containerd:1753b7cbbf62734d812936961224d5bc0cf8f45214e0d5cdd1a781a053e7c48f",
 			},
 		},
+		{
+			name: "backslash-in-path",
+			line: `4:cpu:/docker\abc\def`,
+			expectedSubsys: &CGroupSubsys{
+				ID:         4,
+				Subsystems: []string{"cpu"},
+				Name:       `/docker\abc\def`,
+			},
+		},
 	}
 
 	for _, tt := range testTable {
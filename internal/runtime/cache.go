@@ -0,0 +1,67 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runtime
+
+import (
+	"sync"
+
+	cg "go.uber.org/automaxprocs/internal/cgroups"
+)
+
+// A Cache keeps the cgroups of the calling process once a Detector has
+// located them, so that repeated detection, e.g. by Watch, re-reads the CPU
+// quota without parsing mountinfo and the process' cgroup file again. Mounts
+// rarely change; call Refresh when they do. The zero value is an empty cache
+// ready to use, and a Cache is safe for concurrent use.
+type Cache struct {
+	mu          sync.Mutex
+	procFS      string
+	pid         int
+	maxReadSize int64
+	cgroups     queryer
+}
+
+// Refresh empties the cache, so that the next detection locates the cgroups
+// of the calling process anew.
+func (c *Cache) Refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cgroups = nil
+}
+
+// queryer returns the cached queryer for procFS and pid, locating the
+// cgroups first if the cache is empty or holds those of another procfs or
+// process, or read with another cap, see Detector.MaxReadSize. Errors
+// aren't cached.
+func (c *Cache) queryer(procFS string, pid int, maxReadSize int64) (queryer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cgroups != nil && c.procFS == procFS && c.pid == pid && c.maxReadSize == maxReadSize {
+		return c.cgroups, nil
+	}
+	cgroups, err := _newQueryer(cg.LimitReads(nil, maxReadSize), procFS, pid)
+	if err != nil {
+		return nil, err
+	}
+	c.procFS, c.pid, c.maxReadSize, c.cgroups = procFS, pid, maxReadSize, cgroups
+	return cgroups, nil
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"strconv"
+
+	cg "go.uber.org/automaxprocs/internal/cgroups"
+)
+
+// The methods below read the limits of the calling process from cgroups, on
+// Linux or from FS on any OS. See the Linux variants of the exported methods
+// they implement for details.
+
+// cgroupsCPUQuota implements CPUQuota.
+func (d Detector) cgroupsCPUQuota() (float64, CPUQuotaStatus, error) {
+	cgroups, err := d.queryer()
+	if d.notExposed(err) {
+		return -1, CPUQuotaUndefined, nil
+	}
+	if err != nil {
+		return -1, CPUQuotaUndefined, classifyError(err)
+	}
+
+	quota, status, err := d.cgroupsQuota(cgroups)
+	if err != nil {
+		return -1, CPUQuotaUndefined, classifyError(err)
+	}
+	if status == CPUQuotaUndefined && d.CPUCGroupPath == "" {
+		cgroups, quota, status = d.fallbackQuota(cgroups)
+	}
+
+	cpus, defined, err := cgroups.CPUSet()
+	if err != nil {
+		return -1, CPUQuotaUndefined, classifyError(err)
+	}
+	limit := quota
+	if status == CPUQuotaUndefined {
+		limit = float64(_numCPU())
+	}
+	if defined && float64(cpus) < limit {
+		return float64(cpus), CPUQuotaCPUSetUsed, nil
+	}
+	return quota, status, nil
+}
+
+// cgroupsQuota returns the CPU quota of cgroups, falling back to CPU shares
+// if requested with SharesFallback, or preferring CPU shares capped to the
+// quota if requested with PreferShares.
+func (d Detector) cgroupsQuota(cgroups queryer) (float64, CPUQuotaStatus, error) {
+	quota, defined, err := cgroups.CPUQuota()
+	if errors.Is(err, cg.ErrInvalidPeriod) {
+		d.warnInvalidPeriod(err)
+		quota, defined, err = -1, false, nil
+	}
+	if err != nil {
+		return -1, CPUQuotaUndefined, err
+	}
+	if defined && d.PreferShares {
+		shares, sharesDefined, err := cgroups.CPUSharesQuota()
+		if err != nil {
+			return -1, CPUQuotaUndefined, err
+		}
+		if sharesDefined && shares < quota {
+			return shares, CPUQuotaSharesUsed, nil
+		}
+	}
+	if defined {
+		return quota, CPUQuotaUsed, nil
+	}
+	if !d.SharesFallback {
+		return -1, CPUQuotaUndefined, nil
+	}
+
+	quota, defined, err = cgroups.CPUSharesQuota()
+	if !defined || err != nil {
+		return -1, CPUQuotaUndefined, err
+	}
+	return quota, CPUQuotaSharesUsed, nil
+}
+
+// fallbackQuota looks for a CPU quota in the version of cgroups other than
+// the one of cgroups, which defines none. It returns cgroups unchanged
+// along with an undefined quota if the other version isn't mounted or
+// doesn't define one either.
+func (d Detector) fallbackQuota(cgroups queryer) (queryer, float64, CPUQuotaStatus) {
+	from := cgroups.Version()
+	fallback, err := _newFallbackQueryer(d.fsys(), d.procFS(), d.PID, from)
+	if err != nil {
+		// The primary version was readable; failing to read the other one
+		// doesn't make the lack of a quota an error.
+		return cgroups, -1, CPUQuotaUndefined
+	}
+
+	quota, status, err := d.cgroupsQuota(fallback)
+	if err != nil || status == CPUQuotaUndefined {
+		return cgroups, -1, CPUQuotaUndefined
+	}
+	d.log("maxprocs: No CPU quota in cgroups v%d, falling back to cgroups v%d", from, fallback.Version())
+	return fallback, quota, status
+}
+
+// warnInvalidPeriod logs that the CPU quota is ignored because its period
+// isn't positive. Such a quota is treated as undefined rather than failing
+// the detection.
+func (d Detector) warnInvalidPeriod(err error) {
+	d.log("maxprocs: Ignoring CPU quota with invalid period: %v", err)
+}
+
+// cgroupsCPUQuotaPeriod implements CPUQuotaPeriod.
+func (d Detector) cgroupsCPUQuotaPeriod() (quota, period, version int, err error) {
+	cgroups, err := d.queryer()
+	if d.notExposed(err) {
+		return -1, -1, 0, nil
+	}
+	if err != nil {
+		return -1, -1, 0, classifyError(err)
+	}
+
+	quota, period, _, err = cgroups.CPUQuotaPeriod()
+	if errors.Is(err, cg.ErrInvalidPeriod) {
+		d.warnInvalidPeriod(err)
+		return -1, -1, cgroups.Version(), nil
+	}
+	if err != nil {
+		return -1, -1, 0, classifyError(err)
+	}
+	return quota, period, cgroups.Version(), nil
+}
+
+// cgroupsVersion implements CGroupVersion.
+func (d Detector) cgroupsVersion() (int, error) {
+	version, err := cg.VersionForPID(d.fsys(), d.procFS(), d.PID)
+	if d.notExposed(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	if version == cg.VersionHybrid {
+		return CGroupHybrid, nil
+	}
+	return version, nil
+}
+
+// cgroupsMemoryLimit implements MemoryLimit.
+func (d Detector) cgroupsMemoryLimit() (uint64, bool, error) {
+	cgroups, err := d.queryer()
+	if d.notExposed(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, classifyError(err)
+	}
+
+	limit, defined, err := cgroups.MemoryLimit()
+	return limit, defined, classifyError(err)
+}
+
+// cgroupsProcessCount implements ProcessCount.
+func (d Detector) cgroupsProcessCount() (int, bool, error) {
+	cgroups, err := d.queryer()
+	if d.notExposed(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, classifyError(err)
+	}
+
+	count, defined, err := cgroups.ProcessCount()
+	return count, defined, classifyError(err)
+}
+
+// Stat returns the file info of the file at the absolute path name, from
+// fsys if set like Detector.FS, or from the operating system otherwise.
+func Stat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	return cg.Stat(fsys, name)
+}
+
+// notExposed reports whether err, returned while locating the cgroups of the
+// process, means that the sandbox doesn't expose them: gVisor may not list
+// the cgroup files under ProcFS at all, and there's nothing to detect then.
+// Elsewhere, missing files point at a wrong ProcFS or PID and are reported
+// as ErrCGroupsNotMounted.
+func (d Detector) notExposed(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) && _isGVisor(d)
+}
+
+// classifyError wraps an error reading cgroups so that it matches
+// ErrCGroupsNotMounted, ErrCGroupsUnavailable or *ParseError, while still
+// matching the original error.
+func classifyError(err error) error {
+	var (
+		numErr   *strconv.NumError
+		parseErr *ParseError
+	)
+	switch {
+	case err == nil, errors.Is(err, ErrCGroupsNotMounted), errors.Is(err, ErrCGroupsUnavailable), errors.As(err, &parseErr):
+		return err
+	case errors.As(err, &numErr), errors.Is(err, cg.ErrInvalidFormat), errors.Is(err, io.ErrUnexpectedEOF):
+		return &ParseError{Err: err}
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %w", ErrCGroupsNotMounted, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrCGroupsUnavailable, err)
+	}
+}
+
+type queryer interface {
+	CPUQuota() (float64, bool, error)
+	CPUQuotaPeriod() (int, int, bool, error)
+	CPUSharesQuota() (float64, bool, error)
+	CPUSet() (int, bool, error)
+	CPUStat() (cg.CPUStat, bool, error)
+	MemoryLimit() (uint64, bool, error)
+	ProcessCount() (int, bool, error)
+	Version() int
+}
+
+var (
+	_numCPU      = runtime.NumCPU
+	_isGVisor    = Detector.IsGVisor
+	_newCgroups2 = cg.NewCGroups2ForPID
+	_newCgroups  = cg.NewCGroupsForPID
+	_newQueryer  = newQueryer
+
+	_newFallbackQueryer = newFallbackQueryer
+)
+
+// fsys returns the filesystem to read procfs and cgroup files from, FS or
+// the operating system's if nil, with reads capped at MaxReadSize.
+func (d Detector) fsys() fs.FS {
+	return cg.LimitReads(d.FS, d.MaxReadSize)
+}
+
+// queryer returns the queryer for the cgroups of the calling process, or of
+// the one with the PID, if set, from the Cache if set, or for the
+// CPUCGroupPath, if set. The Cache is bypassed with FS, since filesystems
+// can't be told apart reliably.
+func (d Detector) queryer() (queryer, error) {
+	if d.CPUCGroupPath != "" {
+		cgroups, err := cg.NewCGroupsForPath(d.fsys(), d.CPUCGroupPath)
+		if err != nil {
+			// The error deliberately doesn't match fs.ErrNotExist, which
+			// would pass for a process outside of cgroups.
+			return nil, fmt.Errorf("%w: %v", ErrCGroupsNotMounted, err)
+		}
+		return cgroups, nil
+	}
+	if d.Cache != nil && d.FS == nil {
+		return d.Cache.queryer(d.procFS(), d.PID, d.MaxReadSize)
+	}
+	return _newQueryer(d.fsys(), d.procFS(), d.PID)
+}
+
+func newQueryer(fsys fs.FS, procFS string, pid int) (queryer, error) {
+	cgroups, err := _newCgroups2(fsys, procFS, pid)
+	if err == nil {
+		return cgroups, nil
+	}
+	if errors.Is(err, cg.ErrNotV2) {
+		return _newCgroups(fsys, procFS, pid)
+	}
+	return nil, err
+}
+
+// newFallbackQueryer returns the queryer for the version of cgroups other
+// than version, accepting a cgroups v2 hierarchy mounted anywhere since it
+// only sits next to v1 controllers on hybrid systems.
+func newFallbackQueryer(fsys fs.FS, procFS string, pid, version int) (queryer, error) {
+	if version == 2 {
+		return _newCgroups(fsys, procFS, pid)
+	}
+	return cg.NewUnifiedCGroups2ForPID(fsys, procFS, pid)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !linux
+// +build !linux
+
+package runtime
+
+import "fmt"
+
+// CPUQuotaPeriod returns the raw CPU quota and period applied to the calling
+// process. This is Linux-specific and not supported in the current OS, so
+// the quota and period are always -1 unless read from FS.
+func (d Detector) CPUQuotaPeriod() (quota, period, version int, err error) {
+	if d.FS != nil {
+		return d.cgroupsCPUQuotaPeriod()
+	}
+	return -1, -1, 0, nil
+}
+
+// CGroupVersion returns the version of cgroups the calling process uses.
+// This is Linux-specific and not supported in the current OS, so it always
+// fails unless read from FS.
+func (d Detector) CGroupVersion() (int, error) {
+	if d.FS != nil {
+		return d.cgroupsVersion()
+	}
+	return 0, fmt.Errorf("%w: cgroups are only supported on Linux", ErrCGroupsUnavailable)
+}
+
+// MemoryLimit returns the memory limit in bytes applied to the calling
+// process. This is Linux-specific and not supported in the current OS unless
+// read from FS.
+func (d Detector) MemoryLimit() (uint64, bool, error) {
+	if d.FS != nil {
+		return d.cgroupsMemoryLimit()
+	}
+	return 0, false, nil
+}
+
+// ProcessCount returns the number of processes in the CPU cgroup of the
+// calling process. This is Linux-specific and not supported in the current
+// OS unless read from FS.
+func (d Detector) ProcessCount() (int, bool, error) {
+	if d.FS != nil {
+		return d.cgroupsProcessCount()
+	}
+	return 0, false, nil
+}
+
+// CPUThrottledPeriods returns the number of CFS periods in which the calling
+// process' CPU cgroup has been throttled. This is Linux-specific and not
+// supported in the current OS.
+func CPUThrottledPeriods() (uint64, bool, error) {
+	return 0, false, nil
+}
+
+// OnlineCPUs returns the number of CPUs the kernel has online. This is
+// Linux-specific and not supported in the current OS.
+func OnlineCPUs() (int, bool, error) {
+	return 0, false, nil
+}
+
+// CPUThrottleStats returns the CFS throttling counters of the calling
+// process' CPU cgroup. This is Linux-specific and not supported in the
+// current OS.
+func CPUThrottleStats() (CPUStat, bool, error) {
+	return CPUStat{}, false, nil
+}
+
+// CGroupPath returns the cgroup the calling process belongs to. This is
+// Linux-specific and not supported in the current OS.
+func CGroupPath() (string, error) {
+	return "", nil
+}
+
+// CGroupPaths returns the cgroup the calling process belongs to for each
+// controller. This is Linux-specific and not supported in the current OS.
+func CGroupPaths() (map[string]string, error) {
+	return nil, nil
+}
+
+// ValidateCPUQuotaDir checks that dir is a cgroup directory holding a
+// readable CPU quota. This is Linux-specific and not supported in the current
+// OS, so it always fails.
+func ValidateCPUQuotaDir(_ string) error {
+	return fmt.Errorf("%w: cgroups are only supported on Linux", ErrCGroupsUnavailable)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runtime
+
+import "strings"
+
+// Container runtimes reported by ContainerRuntime.
+const (
+	RuntimeDocker     = "docker"
+	RuntimeContainerd = "containerd"
+	RuntimeCRIO       = "cri-o"
+	RuntimeKubernetes = "kubernetes"
+	RuntimeSystemd    = "systemd"
+	RuntimeLXC        = "lxc"
+	RuntimeNomad      = "nomad"
+	RuntimeMesos      = "mesos"
+	RuntimeUnknown    = "unknown"
+)
+
+// _runtimeMarkers maps substrings of cgroup paths to the runtime that
+// creates them. The order matters: container runtimes nest their cgroups
+// under orchestrators' and systemd's, so the most specific markers go first.
+var _runtimeMarkers = []struct {
+	marker  string
+	runtime string
+}{
+	{"crio-", RuntimeCRIO},
+	{"/crio", RuntimeCRIO},
+	{"containerd", RuntimeContainerd},
+	{"docker", RuntimeDocker},
+	{"kubepods", RuntimeKubernetes},
+	{"lxc", RuntimeLXC},
+	{"nomad", RuntimeNomad},
+	{"mesos", RuntimeMesos},
+	{".slice", RuntimeSystemd},
+	{".scope", RuntimeSystemd},
+}
+
+// ContainerRuntime returns a best guess of the container runtime that
+// created cgroupPath, e.g. RuntimeDocker for
+// "/docker/0123456789abcdef", or RuntimeUnknown if nothing in the path is
+// recognizable.
+func ContainerRuntime(cgroupPath string) string {
+	for _, m := range _runtimeMarkers {
+		if strings.Contains(cgroupPath, m.marker) {
+			return m.runtime
+		}
+	}
+	return RuntimeUnknown
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerRuntime(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/docker/0123456789abcdef", want: RuntimeDocker},
+		{path: "/system.slice/docker-0123456789abcdef.scope", want: RuntimeDocker},
+		{path: "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice/docker-0123456789abcdef.scope", want: RuntimeDocker},
+		{path: "/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1234.slice/cri-containerd-0123456789abcdef.scope", want: RuntimeContainerd},
+		{path: "/system.slice/containerd.service", want: RuntimeContainerd},
+		{path: "/kubepods.slice/kubepods-pod1234.slice/crio-0123456789abcdef.scope", want: RuntimeCRIO},
+		{path: "/kubepods/burstable/pod1234/crio/0123456789abcdef", want: RuntimeCRIO},
+		{path: "/kubepods/burstable/pod1234/0123456789abcdef", want: RuntimeKubernetes},
+		{path: "/user.slice/user-1000.slice/session-1.scope", want: RuntimeSystemd},
+		{path: "/system.slice/nginx.service", want: RuntimeSystemd},
+		{path: "/lxc.payload.web", want: RuntimeLXC},
+		{path: "/lxc/web", want: RuntimeLXC},
+		{path: "/nomad/0123-4567.web", want: RuntimeNomad},
+		{path: "/nomad.slice/0123-4567.web.scope", want: RuntimeNomad},
+		{path: "/mesos/0123-4567", want: RuntimeMesos},
+		{path: "/", want: RuntimeUnknown},
+		{path: "", want: RuntimeUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, ContainerRuntime(tt.path))
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build darwin
+// +build darwin
+
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+// _cpuQuotaHintKey is the environment variable holding the CPU quota to
+// simulate on macOS, which has no CPU quotas of its own.
+const _cpuQuotaHintKey = "AUTOMAXPROCS_CPU"
+
+// CPUQuota returns the CPU quota in cores given by the AUTOMAXPROCS_CPU
+// environment variable, e.g. "1.5", which lets developers simulate the CPU
+// quota of their deployments on macOS. The status is CPUQuotaUsed, or
+// CPUQuotaUndefined with a quota of -1 if the variable isn't set. With FS,
+// the CPU quota is read from the cgroups there instead, as on Linux.
+func (d Detector) CPUQuota() (float64, CPUQuotaStatus, error) {
+	if d.FS != nil {
+		return d.cgroupsCPUQuota()
+	}
+
+	hint, exists := os.LookupEnv(_cpuQuotaHintKey)
+	if !exists {
+		return -1, CPUQuotaUndefined, nil
+	}
+
+	quota, err := strconv.ParseFloat(hint, 64)
+	if err != nil {
+		return -1, CPUQuotaUndefined, &ParseError{Err: fmt.Errorf("invalid %v=%q: %w", _cpuQuotaHintKey, hint, err)}
+	}
+	if !(quota > 0) || math.IsInf(quota, 1) {
+		return -1, CPUQuotaUndefined, &ParseError{Err: fmt.Errorf("invalid %v=%q: must be positive and finite", _cpuQuotaHintKey, hint)}
+	}
+	return quota, CPUQuotaUsed, nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build darwin
+// +build darwin
+
+package runtime
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUQuotaHint(t *testing.T) {
+	tests := []struct {
+		name       string
+		hint       string
+		unset      bool
+		wantQuota  float64
+		wantStatus CPUQuotaStatus
+		wantErr    string
+	}{
+		{name: "unset", unset: true, wantQuota: -1, wantStatus: CPUQuotaUndefined},
+		{name: "fractional", hint: "1.5", wantQuota: 1.5, wantStatus: CPUQuotaUsed},
+		{name: "invalid", hint: "two", wantQuota: -1, wantStatus: CPUQuotaUndefined, wantErr: `invalid AUTOMAXPROCS_CPU="two"`},
+		{name: "zero", hint: "0", wantQuota: -1, wantStatus: CPUQuotaUndefined, wantErr: "must be positive"},
+		{name: "NaN", hint: "NaN", wantQuota: -1, wantStatus: CPUQuotaUndefined, wantErr: "must be positive and finite"},
+		{name: "infinite", hint: "Inf", wantQuota: -1, wantStatus: CPUQuotaUndefined, wantErr: "must be positive and finite"},
+		{name: "negative infinite", hint: "-Inf", wantQuota: -1, wantStatus: CPUQuotaUndefined, wantErr: "must be positive and finite"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(_cpuQuotaHintKey, tt.hint)
+			if tt.unset {
+				require.NoError(t, os.Unsetenv(_cpuQuotaHintKey))
+			}
+
+			quota, status, err := Detector{}.CPUQuota()
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				var parseErr *ParseError
+				assert.ErrorAs(t, err, &parseErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantQuota, quota)
+			assert.Equal(t, tt.wantStatus, status)
+		})
+	}
+}
+
+func TestCPUQuotaHintGOMAXPROCS(t *testing.T) {
+	t.Setenv(_cpuQuotaHintKey, "2.5")
+	procs, status, err := Detector{}.CPUQuotaToGOMAXPROCS(1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, procs)
+	assert.Equal(t, CPUQuotaUsed, status)
+}
@@ -23,53 +23,106 @@
 
 package runtime
 
-import (
-	"errors"
-
-	cg "go.uber.org/automaxprocs/internal/cgroups"
-)
-
-// CPUQuotaToGOMAXPROCS converts the CPU quota applied to the calling process
-// to a valid GOMAXPROCS value. The quota is converted from float to int using round.
-// If round == nil, DefaultRoundFunc is used.
-func CPUQuotaToGOMAXPROCS(minValue int, round func(v float64) int) (int, CPUQuotaStatus, error) {
-	if round == nil {
-		round = DefaultRoundFunc
+import cg "go.uber.org/automaxprocs/internal/cgroups"
+
+// CPUQuota returns the CPU quota applied to the calling process in cores,
+// e.g. 1.5 for a quota of one and a half CPUs. The status is CPUQuotaUsed or
+// CPUQuotaSharesUsed depending on where the quota comes from, or
+// CPUQuotaUndefined with a quota of -1 if there is none. If the cpuset of
+// the process allows fewer CPUs than that, or than the host has, the quota
+// is the number of CPUs in the cpuset instead and the status is
+// CPUQuotaCPUSetUsed.
+//
+// If the version of cgroups the process uses defines no quota, the other
+// version is tried in case a hybrid system holds the CPU controller there.
+func (d Detector) CPUQuota() (float64, CPUQuotaStatus, error) {
+	return d.cgroupsCPUQuota()
+}
+
+// CPUQuotaPeriod returns the raw CPU quota and period applied to the calling
+// process in microseconds, along with the version of cgroups, 1 or 2, they
+// were read from. The quota and period are -1 if there is no quota, and the
+// version is 0 if the process isn't in a cgroup.
+func (d Detector) CPUQuotaPeriod() (quota, period, version int, err error) {
+	return d.cgroupsCPUQuotaPeriod()
+}
+
+// CGroupVersion returns the version of cgroups the calling process uses: 1,
+// 2 or CGroupHybrid, or 0 if it doesn't use cgroups.
+func (d Detector) CGroupVersion() (int, error) {
+	return d.cgroupsVersion()
+}
+
+// MemoryLimit returns the memory limit in bytes applied to the calling
+// process. The boolean is false if there is no memory limit.
+func (d Detector) MemoryLimit() (uint64, bool, error) {
+	return d.cgroupsMemoryLimit()
+}
+
+// ProcessCount returns the number of processes in the CPU cgroup of the
+// calling process, all of which share its CPU quota. The boolean is false if
+// the count isn't available.
+func (d Detector) ProcessCount() (int, bool, error) {
+	return d.cgroupsProcessCount()
+}
+
+// CPUThrottledPeriods returns the number of CFS periods in which the calling
+// process' CPU cgroup has been throttled. The boolean is false if the counter
+// isn't available.
+func CPUThrottledPeriods() (uint64, bool, error) {
+	cgroups, err := _newQueryer(nil, _defaultProcFS, 0)
+	if (Detector{}).notExposed(err) {
+		return 0, false, nil
 	}
-	cgroups, err := _newQueryer()
 	if err != nil {
-		return -1, CPUQuotaUndefined, err
+		return 0, false, classifyError(err)
 	}
 
-	quota, defined, err := cgroups.CPUQuota()
-	if !defined || err != nil {
-		return -1, CPUQuotaUndefined, err
+	stat, defined, err := cgroups.CPUStat()
+	return stat.NrThrottled, defined, classifyError(err)
+}
+
+// CPUThrottleStats returns the CFS throttling counters of the calling
+// process' CPU cgroup, for cgroups v1 or v2. The boolean is false if the
+// counters aren't available.
+func CPUThrottleStats() (CPUStat, bool, error) {
+	cgroups, err := _newQueryer(nil, _defaultProcFS, 0)
+	if (Detector{}).notExposed(err) {
+		return CPUStat{}, false, nil
+	}
+	if err != nil {
+		return CPUStat{}, false, classifyError(err)
 	}
 
-	maxProcs := round(quota)
-	if minValue > 0 && maxProcs < minValue {
-		return minValue, CPUQuotaMinUsed, nil
+	stat, defined, err := cgroups.CPUStat()
+	if err != nil {
+		return CPUStat{}, false, classifyError(err)
 	}
-	return maxProcs, CPUQuotaUsed, nil
+	return CPUStat(stat), defined, nil
 }
 
-type queryer interface {
-	CPUQuota() (float64, bool, error)
+// OnlineCPUs returns the number of CPUs the kernel has online. The boolean
+// is false if the count isn't available.
+func OnlineCPUs() (int, bool, error) {
+	return cg.OnlineCPUs()
 }
 
-var (
-	_newCgroups2 = cg.NewCGroups2ForCurrentProcess
-	_newCgroups  = cg.NewCGroupsForCurrentProcess
-	_newQueryer  = newQueryer
-)
+// CGroupPath returns the cgroup the calling process belongs to, e.g.
+// `/kubepods/burstable/pod1234/0123456789abcdef`, or "" if there is none.
+func CGroupPath() (string, error) {
+	path, err := cg.CGroupPathForProcFS(_defaultProcFS)
+	return path, classifyError(err)
+}
 
-func newQueryer() (queryer, error) {
-	cgroups, err := _newCgroups2()
-	if err == nil {
-		return cgroups, nil
-	}
-	if errors.Is(err, cg.ErrNotV2) {
-		return _newCgroups()
-	}
-	return nil, err
+// CGroupPaths returns the cgroup the calling process belongs to for each
+// controller, with the unified hierarchy of cgroups v2 under "".
+func CGroupPaths() (map[string]string, error) {
+	paths, err := cg.CGroupPathsForProcFS(_defaultProcFS)
+	return paths, classifyError(err)
+}
+
+// ValidateCPUQuotaDir checks that dir is a cgroup directory holding a
+// readable CPU quota, for cgroups v1 or v2.
+func ValidateCPUQuotaDir(dir string) error {
+	return classifyError(cg.ValidateCPUQuotaDir(dir))
 }
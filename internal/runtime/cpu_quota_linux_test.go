@@ -26,8 +26,14 @@ package runtime
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/prashantv/gostub"
 	"github.com/stretchr/testify/assert"
@@ -42,7 +48,7 @@ func TestNewQueryer(t *testing.T) {
 		c2 := new(cgroups.CGroups2)
 		stubs.StubFunc(&_newCgroups2, c2, nil)
 
-		got, err := newQueryer()
+		got, err := newQueryer(nil, _defaultProcFS, 0)
 		require.NoError(t, err)
 		assert.Same(t, c2, got)
 	})
@@ -53,7 +59,7 @@ func TestNewQueryer(t *testing.T) {
 		giveErr := errors.New("great sadness")
 		stubs.StubFunc(&_newCgroups2, nil, giveErr)
 
-		_, err := newQueryer()
+		_, err := newQueryer(nil, _defaultProcFS, 0)
 		assert.ErrorIs(t, err, giveErr)
 	})
 
@@ -66,7 +72,7 @@ func TestNewQueryer(t *testing.T) {
 		c1 := make(cgroups.CGroups)
 		stubs.StubFunc(&_newCgroups, c1, nil)
 
-		got, err := newQueryer()
+		got, err := newQueryer(nil, _defaultProcFS, 0)
 		require.NoError(t, err)
 		assert.IsType(t, c1, got, "must be a v1 cgroup")
 	})
@@ -79,7 +85,7 @@ func TestNewQueryer(t *testing.T) {
 		giveErr := errors.New("great sadness")
 		stubs.StubFunc(&_newCgroups, nil, giveErr)
 
-		_, err := newQueryer()
+		_, err := newQueryer(nil, _defaultProcFS, 0)
 		assert.ErrorIs(t, err, giveErr)
 	})
 
@@ -118,16 +124,831 @@ func TestNewQueryer(t *testing.T) {
 	})
 }
 
+func TestDetectorCPUSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		queryer    testQueryer
+		detector   Detector
+		wantQuota  float64
+		wantStatus CPUQuotaStatus
+	}{
+		{
+			name:       "cpuset below quota",
+			queryer:    testQueryer{v: 8, cpuset: 4},
+			wantQuota:  4,
+			wantStatus: CPUQuotaCPUSetUsed,
+		},
+		{
+			name:       "quota below cpuset",
+			queryer:    testQueryer{v: 2.5, cpuset: 4},
+			wantQuota:  2.5,
+			wantStatus: CPUQuotaUsed,
+		},
+		{
+			name:       "cpuset without quota",
+			queryer:    testQueryer{undefined: true, cpuset: 2},
+			wantQuota:  2,
+			wantStatus: CPUQuotaCPUSetUsed,
+		},
+		{
+			name:       "cpuset of all CPUs without quota",
+			queryer:    testQueryer{undefined: true, cpuset: 8},
+			wantQuota:  -1,
+			wantStatus: CPUQuotaUndefined,
+		},
+		{
+			name:       "cpuset below shares",
+			queryer:    testQueryer{undefined: true, shares: 6, cpuset: 4},
+			detector:   Detector{SharesFallback: true},
+			wantQuota:  4,
+			wantStatus: CPUQuotaCPUSetUsed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubs := newStubs(t)
+			stubs.StubFunc(&_newQueryer, tt.queryer, nil)
+			stubs.StubFunc(&_numCPU, 8)
+
+			quota, status, err := tt.detector.CPUQuota()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantQuota, quota)
+		})
+	}
+}
+
+func TestCPUQuotaToGOMAXPROCSMissingProcFiles(t *testing.T) {
+	missing := &fs.PathError{Op: "open", Path: "/proc/self/cgroup", Err: fs.ErrNotExist}
+
+	t.Run("gVisor", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, missing)
+		stubs.StubFunc(&_isGVisor, true)
+
+		got, status, err := CPUQuotaToGOMAXPROCS(1, nil)
+		require.NoError(t, err, "gVisor may not expose the proc files")
+		assert.Equal(t, CPUQuotaUndefined, status)
+		assert.Equal(t, -1, got)
+	})
+
+	t.Run("elsewhere", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, missing)
+
+		_, status, err := CPUQuotaToGOMAXPROCS(1, nil)
+		assert.ErrorIs(t, err, ErrCGroupsNotMounted, "missing proc files should be reported")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		assert.Equal(t, CPUQuotaUndefined, status)
+	})
+}
+
+// newTestProcFS lays out a procfs resembling that of a container with a
+// 3 CPU quota. Its mountinfo points the cpu controller at a directory inside
+// the test's temporary directory.
+func newTestProcFS(tb testing.TB) string {
+	root := tb.TempDir()
+	procFS := filepath.Join(root, "proc")
+	cpuDir := filepath.Join(root, "cgroup", "cpu,cpuacct")
+	cpusetDir := filepath.Join(root, "cgroup", "cpuset")
+	require.NoError(tb, os.MkdirAll(filepath.Join(procFS, "self"), 0o755))
+	require.NoError(tb, os.MkdirAll(cpuDir, 0o755))
+	require.NoError(tb, os.MkdirAll(cpusetDir, 0o755))
+
+	mountInfo := strings.Join([]string{
+		"1 0 8:1 / / rw,noatime shared:1 - overlay overlay rw,lowerdir=/l,upperdir=/u,workdir=/w",
+		"2 1 0:1 / /dev rw,nosuid shared:2 - tmpfs tmpfs rw,size=65536k,mode=755",
+		"3 1 0:2 / /proc rw,nosuid,nodev,noexec,relatime shared:3 - proc proc rw",
+		"4 1 0:3 / /sys ro,nosuid,nodev,noexec,relatime shared:4 - sysfs sysfs ro",
+		"5 4 0:4 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime - tmpfs tmpfs rw,mode=755",
+		fmt.Sprintf("6 5 0:5 /docker/0123456789abcdef %s ro,nosuid,nodev,noexec,relatime shared:6 - cgroup cgroup rw,cpuset", cpusetDir),
+		fmt.Sprintf("7 5 0:6 /docker/0123456789abcdef %s ro,nosuid,nodev,noexec,relatime shared:7 - cgroup cgroup rw,cpu,cpuacct", cpuDir),
+		"8 5 0:7 /docker/0123456789abcdef /sys/fs/cgroup/memory ro,nosuid,nodev,noexec,relatime shared:8 - cgroup cgroup rw,memory",
+		"9 5 0:8 /docker/0123456789abcdef /sys/fs/cgroup/pids ro,nosuid,nodev,noexec,relatime shared:9 - cgroup cgroup rw,pids",
+		"10 1 8:1 /var/lib/docker/containers/0123456789abcdef/resolv.conf /etc/resolv.conf rw,relatime - ext4 /dev/sda1 rw",
+		"11 1 8:1 /var/lib/docker/containers/0123456789abcdef/hostname /etc/hostname rw,relatime - ext4 /dev/sda1 rw",
+		"12 1 8:1 /var/lib/docker/containers/0123456789abcdef/hosts /etc/hosts rw,relatime - ext4 /dev/sda1 rw",
+		"",
+	}, "\n")
+	cgroup := strings.Join([]string{
+		"5:pids:/docker/0123456789abcdef",
+		"4:memory:/docker/0123456789abcdef",
+		"3:cpu,cpuacct:/docker/0123456789abcdef",
+		"2:cpuset:/docker/0123456789abcdef",
+		"1:name=systemd:/docker/0123456789abcdef",
+		"",
+	}, "\n")
+	files := map[string]string{
+		filepath.Join(procFS, "self", "mountinfo"): mountInfo,
+		filepath.Join(procFS, "self", "cgroup"):    cgroup,
+		filepath.Join(cpuDir, "cpu.cfs_quota_us"):  "300000\n",
+		filepath.Join(cpuDir, "cpu.cfs_period_us"): "100000\n",
+	}
+	for path, content := range files {
+		require.NoError(tb, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return procFS
+}
+
+func TestDetectorProcFS(t *testing.T) {
+	procFS := newTestProcFS(t)
+
+	got, status, err := Detector{ProcFS: procFS}.CPUQuotaToGOMAXPROCS(1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, CPUQuotaUsed, status)
+	assert.Equal(t, 3, got)
+}
+
+func TestDetectorCGroupVersion(t *testing.T) {
+	version, err := Detector{ProcFS: newTestProcFS(t)}.CGroupVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	stubs := newStubs(t)
+	_, err = Detector{ProcFS: t.TempDir()}.CGroupVersion()
+	assert.ErrorIs(t, err, ErrCGroupsNotMounted, "missing proc files should be reported")
+
+	stubs.StubFunc(&_isGVisor, true)
+	version, err = Detector{ProcFS: t.TempDir()}.CGroupVersion()
+	require.NoError(t, err, "gVisor may not expose the proc files")
+	assert.Equal(t, 0, version)
+}
+
+func TestDetectorCPUCGroupPath(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.cfs_quota_us"), []byte("150000\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.cfs_period_us"), []byte("100000\n"), 0o644))
+
+	// The procfs should be ignored altogether.
+	detector := Detector{ProcFS: t.TempDir(), CPUCGroupPath: dir}
+	quota, status, err := detector.CPUQuota()
+	require.NoError(t, err)
+	assert.Equal(t, CPUQuotaUsed, status)
+	assert.Equal(t, 1.5, quota)
+
+	_, _, err = Detector{CPUCGroupPath: filepath.Join(dir, "missing")}.CPUQuotaToGOMAXPROCS(1, nil)
+	require.Error(t, err, "an invalid cgroup path should be an error")
+	assert.Contains(t, err.Error(), "no cpu.cfs_quota_us in")
+	assert.ErrorIs(t, err, ErrCGroupsNotMounted)
+}
+
+func TestDetectorErrors(t *testing.T) {
+	for _, content := range []string{"1.5", ""} {
+		t.Run(fmt.Sprintf("parse %q", content), func(t *testing.T) {
+			procFS := newTestProcFS(t)
+			quotaFile := filepath.Join(filepath.Dir(procFS), "cgroup", "cpu,cpuacct", "cpu.cfs_quota_us")
+			require.NoError(t, os.WriteFile(quotaFile, []byte(content), 0o644))
+
+			_, _, err := Detector{ProcFS: procFS}.CPUQuota()
+			var parseErr *ParseError
+			assert.ErrorAs(t, err, &parseErr)
+		})
+	}
+
+	t.Run("unavailable", func(t *testing.T) {
+		// The cgroup lies outside of the root of its mount point.
+		procFS := newTestProcFS(t)
+		require.NoError(t, os.WriteFile(filepath.Join(procFS, "self", "cgroup"), []byte("3:cpu,cpuacct:/other\n"), 0o644))
+
+		_, _, err := Detector{ProcFS: procFS}.CPUQuota()
+		assert.ErrorIs(t, err, ErrCGroupsUnavailable)
+	})
+}
+
+func TestDetectorCPUQuotaFallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		fallback    queryer
+		fallbackErr error
+		wantQuota   float64
+		wantStatus  CPUQuotaStatus
+		wantLog     []string
+	}{
+		{
+			name:       "fallback defines quota",
+			fallback:   testQueryer{v: 2, version: 1},
+			wantQuota:  2,
+			wantStatus: CPUQuotaUsed,
+			wantLog:    []string{"maxprocs: No CPU quota in cgroups v2, falling back to cgroups v1"},
+		},
+		{
+			name:       "fallback undefined",
+			fallback:   testQueryer{undefined: true, version: 1},
+			wantQuota:  -1,
+			wantStatus: CPUQuotaUndefined,
+		},
+		{
+			name:        "fallback error",
+			fallbackErr: errors.New("failed"),
+			wantQuota:   -1,
+			wantStatus:  CPUQuotaUndefined,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubs := newStubs(t)
+			stubs.StubFunc(&_newQueryer, testQueryer{undefined: true}, nil)
+			stubs.StubFunc(&_newFallbackQueryer, tt.fallback, tt.fallbackErr)
+
+			var logs []string
+			detector := Detector{Logger: func(format string, args ...interface{}) {
+				logs = append(logs, fmt.Sprintf(format, args...))
+			}}
+			quota, status, err := detector.CPUQuota()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantQuota, quota)
+			assert.Equal(t, tt.wantLog, logs)
+		})
+	}
+}
+
+// newTestHybridProcFS builds a procfs for a process on a systemd hybrid
+// system, with the v1 CPU controller and the v2 hierarchy in a temporary
+// directory holding the given cpu.cfs_quota_us and cpu.max.
+func newTestHybridProcFS(tb testing.TB, cfsQuota, cpuMax string) string {
+	root := tb.TempDir()
+	procFS := filepath.Join(root, "proc")
+	cpuDir := filepath.Join(root, "cgroup", "cpu,cpuacct")
+	unifiedDir := filepath.Join(root, "cgroup", "unified")
+	groupDir := filepath.Join(unifiedDir, "docker", "0123456789abcdef")
+	require.NoError(tb, os.MkdirAll(filepath.Join(procFS, "self"), 0o755))
+	require.NoError(tb, os.MkdirAll(cpuDir, 0o755))
+	require.NoError(tb, os.MkdirAll(groupDir, 0o755))
+
+	mountInfo := strings.Join([]string{
+		"1 0 8:1 / / rw,noatime shared:1 - overlay overlay rw,lowerdir=/l,upperdir=/u,workdir=/w",
+		"5 1 0:4 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime - tmpfs tmpfs rw,mode=755",
+		fmt.Sprintf("6 5 0:5 / %s rw,nosuid,nodev,noexec,relatime shared:6 - cgroup2 cgroup2 rw,nsdelegate", unifiedDir),
+		fmt.Sprintf("7 5 0:6 /docker/0123456789abcdef %s ro,nosuid,nodev,noexec,relatime shared:7 - cgroup cgroup rw,cpu,cpuacct", cpuDir),
+		"",
+	}, "\n")
+	cgroup := strings.Join([]string{
+		"3:cpu,cpuacct:/docker/0123456789abcdef",
+		"0::/docker/0123456789abcdef",
+		"",
+	}, "\n")
+	files := map[string]string{
+		filepath.Join(procFS, "self", "mountinfo"): mountInfo,
+		filepath.Join(procFS, "self", "cgroup"):    cgroup,
+		filepath.Join(cpuDir, "cpu.cfs_quota_us"):  cfsQuota,
+		filepath.Join(cpuDir, "cpu.cfs_period_us"): "100000\n",
+		filepath.Join(groupDir, "cpu.max"):         cpuMax,
+	}
+	for path, content := range files {
+		require.NoError(tb, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return procFS
+}
+
+func TestDetectorCPUQuotaFallbackHybrid(t *testing.T) {
+	t.Run("v1 to v2", func(t *testing.T) {
+		procFS := newTestHybridProcFS(t, "-1\n", "200000 100000\n")
+
+		var logs []string
+		detector := Detector{ProcFS: procFS, Logger: func(format string, args ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		}}
+		quota, status, err := detector.CPUQuota()
+		require.NoError(t, err)
+		assert.Equal(t, CPUQuotaUsed, status)
+		assert.Equal(t, 2.0, quota)
+		assert.Equal(t, []string{"maxprocs: No CPU quota in cgroups v1, falling back to cgroups v2"}, logs)
+	})
+
+	t.Run("v2 to v1", func(t *testing.T) {
+		// Pretend the unified hierarchy is the one in use.
+		stubs := newStubs(t)
+		stubs.Stub(&_newCgroups2, cgroups.NewUnifiedCGroups2ForPID)
+		stubs.Stub(&_newFallbackQueryer, newFallbackQueryer)
+		procFS := newTestHybridProcFS(t, "150000\n", "max 100000\n")
+
+		quota, status, err := Detector{ProcFS: procFS}.CPUQuota()
+		require.NoError(t, err)
+		assert.Equal(t, CPUQuotaUsed, status)
+		assert.Equal(t, 1.5, quota)
+	})
+
+	t.Run("neither", func(t *testing.T) {
+		procFS := newTestHybridProcFS(t, "-1\n", "max 100000\n")
+
+		quota, status, err := Detector{ProcFS: procFS}.CPUQuota()
+		require.NoError(t, err)
+		assert.Equal(t, CPUQuotaUndefined, status)
+		assert.Equal(t, -1.0, quota)
+	})
+}
+
+func TestDetectorInvalidPeriod(t *testing.T) {
+	for _, period := range []string{"0", "-1"} {
+		t.Run(period, func(t *testing.T) {
+			procFS := newTestProcFS(t)
+			cpuDir := filepath.Join(filepath.Dir(procFS), "cgroup", "cpu,cpuacct")
+			require.NoError(t, os.WriteFile(filepath.Join(cpuDir, "cpu.cfs_quota_us"), []byte("100000\n"), 0o644))
+			require.NoError(t, os.WriteFile(filepath.Join(cpuDir, "cpu.cfs_period_us"), []byte(period+"\n"), 0o644))
+
+			var logs []string
+			detector := Detector{ProcFS: procFS, Logger: func(format string, args ...interface{}) {
+				logs = append(logs, fmt.Sprintf(format, args...))
+			}}
+			procs, status, err := detector.CPUQuotaToGOMAXPROCS(1, nil)
+			require.NoError(t, err, "an invalid period shouldn't fail the detection")
+			assert.Equal(t, CPUQuotaUndefined, status)
+			assert.Equal(t, -1, procs)
+			require.Len(t, logs, 1)
+			assert.Contains(t, logs[0], "maxprocs: Ignoring CPU quota with invalid period")
+			assert.Contains(t, logs[0], "cpu.cfs_period_us")
+
+			quota, gotPeriod, version, err := detector.CPUQuotaPeriod()
+			require.NoError(t, err)
+			assert.Equal(t, -1, quota)
+			assert.Equal(t, -1, gotPeriod)
+			assert.Equal(t, 1, version)
+		})
+	}
+}
+
+func TestDetectorCPUQuotaPeriod(t *testing.T) {
+	quota, period, version, err := Detector{ProcFS: newTestProcFS(t)}.CPUQuotaPeriod()
+	require.NoError(t, err)
+	assert.Equal(t, 300000, quota)
+	assert.Equal(t, 100000, period)
+	assert.Equal(t, 1, version)
+
+	stubs := newStubs(t)
+	_, _, _, err = Detector{ProcFS: t.TempDir()}.CPUQuotaPeriod()
+	assert.ErrorIs(t, err, ErrCGroupsNotMounted, "missing proc files should be reported")
+
+	stubs.StubFunc(&_isGVisor, true)
+	quota, period, version, err = Detector{ProcFS: t.TempDir()}.CPUQuotaPeriod()
+	require.NoError(t, err, "gVisor may not expose the proc files")
+	assert.Equal(t, -1, quota)
+	assert.Equal(t, -1, period)
+	assert.Equal(t, 0, version)
+}
+
+func TestDetectorReadOnlyProcFS(t *testing.T) {
+	// Hardened containers bind-mount /sys/fs/cgroup read-only, so detection
+	// must never need write access to anything it touches. Root ignores file
+	// modes, see TestCGroupsReadOnlyFS in internal/cgroups for a check that
+	// holds regardless.
+	if os.Geteuid() == 0 {
+		t.Skip("file modes don't restrict root")
+	}
+
+	procFS := newTestProcFS(t)
+	root := filepath.Dir(procFS)
+	chmodTree(t, root, 0o555, 0o444)
+	t.Cleanup(func() { chmodTree(t, root, 0o755, 0o644) })
+
+	got, status, err := Detector{ProcFS: procFS}.CPUQuotaToGOMAXPROCS(1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, CPUQuotaUsed, status)
+	assert.Equal(t, 3, got)
+
+	_, _, err = Detector{ProcFS: procFS}.MemoryLimit()
+	require.NoError(t, err)
+}
+
+func chmodTree(tb testing.TB, root string, dirMode, fileMode fs.FileMode) {
+	// Directories are walked before their contents, so loosen a tree top-down
+	// and tighten it bottom-up to keep every entry reachable.
+	var paths []string
+	require.NoError(tb, filepath.WalkDir(root, func(path string, _ fs.DirEntry, err error) error {
+		paths = append(paths, path)
+		return err
+	}))
+	if dirMode&0o200 == 0 {
+		for i, j := 0, len(paths)-1; i < j; i, j = i+1, j-1 {
+			paths[i], paths[j] = paths[j], paths[i]
+		}
+	}
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		require.NoError(tb, err)
+		mode := fileMode
+		if info.IsDir() {
+			mode = dirMode
+		}
+		require.NoError(tb, os.Chmod(path, mode))
+	}
+}
+
+func TestDetectorAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations aren't representative with the race detector")
+	}
+
+	// Detection runs at startup of every program using this package, so keep
+	// an eye on its garbage. The bound sits just above the 148 allocations
+	// measured against newTestProcFS, including the look for isolated CPUs;
+	// raise it only along with a reason.
+	const maxAllocs = 150
+
+	detector := Detector{ProcFS: newTestProcFS(t)}
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, _, err := detector.CPUQuotaToGOMAXPROCS(1, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Logf("%v allocations per detection", allocs)
+	assert.LessOrEqual(t, allocs, float64(maxAllocs))
+}
+
+func BenchmarkDetector(b *testing.B) {
+	detector := Detector{ProcFS: newTestProcFS(b)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := detector.CPUQuotaToGOMAXPROCS(1, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDetectorCache(b *testing.B) {
+	detector := Detector{ProcFS: newTestProcFS(b), Cache: new(Cache)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := detector.CPUQuotaToGOMAXPROCS(1, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDetectorCache(t *testing.T) {
+	stubs := newStubs(t)
+
+	var located, lastPID int
+	stubs.Stub(&_newQueryer, func(_ fs.FS, _ string, pid int) (queryer, error) {
+		located++
+		lastPID = pid
+		return testQueryer{v: 2}, nil
+	})
+
+	detector := Detector{Cache: new(Cache)}
+	for i := 0; i < 3; i++ {
+		quota, status, err := detector.CPUQuota()
+		require.NoError(t, err)
+		assert.Equal(t, 2.0, quota)
+		assert.Equal(t, CPUQuotaUsed, status)
+	}
+	assert.Equal(t, 1, located, "cgroups should be located once")
+
+	detector.Cache.Refresh()
+	_, _, err := detector.CPUQuota()
+	require.NoError(t, err)
+	assert.Equal(t, 2, located, "Refresh should locate the cgroups anew")
+
+	detector.ProcFS = "/other/proc"
+	_, _, err = detector.CPUQuota()
+	require.NoError(t, err)
+	assert.Equal(t, 3, located, "another procfs shouldn't use the cache")
+
+	detector.PID = 42
+	_, _, err = detector.CPUQuota()
+	require.NoError(t, err)
+	assert.Equal(t, 4, located, "another process shouldn't use the cache")
+	assert.Equal(t, 42, lastPID, "should locate the cgroups of PID")
+
+	detector.MaxReadSize = 1 << 10
+	_, _, err = detector.CPUQuota()
+	require.NoError(t, err)
+	assert.Equal(t, 5, located, "another read cap shouldn't use the cache")
+}
+
+func TestDetectorCacheErrors(t *testing.T) {
+	stubs := newStubs(t)
+
+	giveErr := errors.New("great sadness")
+	stubs.StubFunc(&_newQueryer, nil, giveErr)
+
+	detector := Detector{Cache: new(Cache)}
+	_, _, err := detector.CPUQuota()
+	require.ErrorIs(t, err, giveErr)
+
+	stubs.StubFunc(&_newQueryer, testQueryer{v: 2}, nil)
+	quota, _, err := detector.CPUQuota()
+	require.NoError(t, err, "errors shouldn't be cached")
+	assert.Equal(t, 2.0, quota)
+}
+
+func TestDetectorCacheConcurrent(t *testing.T) {
+	detector := Detector{ProcFS: newTestProcFS(t), Cache: new(Cache)}
+	want, _, err := detector.CPUQuota()
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if j%3 == 0 {
+					detector.Cache.Refresh()
+				}
+				quota, _, err := detector.CPUQuota()
+				assert.NoError(t, err)
+				assert.Equal(t, want, quota)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCPUQuotaToGOMAXPROCSSharesFallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		detector   Detector
+		queryer    testQueryer
+		wantProcs  int
+		wantStatus CPUQuotaStatus
+	}{
+		{
+			name:       "shares only, default",
+			queryer:    testQueryer{undefined: true, shares: 2},
+			wantProcs:  -1,
+			wantStatus: CPUQuotaUndefined,
+		},
+		{
+			name:       "shares only, fallback",
+			detector:   Detector{SharesFallback: true},
+			queryer:    testQueryer{undefined: true, shares: 2},
+			wantProcs:  2,
+			wantStatus: CPUQuotaSharesUsed,
+		},
+		{
+			name:       "shares below min, fallback",
+			detector:   Detector{SharesFallback: true},
+			queryer:    testQueryer{undefined: true, shares: 0.25},
+			wantProcs:  1,
+			wantStatus: CPUQuotaMinUsed,
+		},
+		{
+			name:       "quota and shares, fallback",
+			detector:   Detector{SharesFallback: true},
+			queryer:    testQueryer{v: 4, shares: 2},
+			wantProcs:  4,
+			wantStatus: CPUQuotaUsed,
+		},
+		{
+			name:       "quota and shares, prefer shares",
+			detector:   Detector{PreferShares: true},
+			queryer:    testQueryer{v: 4, shares: 2},
+			wantProcs:  2,
+			wantStatus: CPUQuotaSharesUsed,
+		},
+		{
+			name:       "shares above quota, prefer shares",
+			detector:   Detector{PreferShares: true},
+			queryer:    testQueryer{v: 2, shares: 4},
+			wantProcs:  2,
+			wantStatus: CPUQuotaUsed,
+		},
+		{
+			name:       "quota only, prefer shares",
+			detector:   Detector{PreferShares: true},
+			queryer:    testQueryer{v: 4},
+			wantProcs:  4,
+			wantStatus: CPUQuotaUsed,
+		},
+		{
+			name:       "shares only, prefer shares",
+			detector:   Detector{PreferShares: true},
+			queryer:    testQueryer{undefined: true, shares: 2},
+			wantProcs:  -1,
+			wantStatus: CPUQuotaUndefined,
+		},
+		{
+			name:       "neither, fallback",
+			detector:   Detector{SharesFallback: true},
+			queryer:    testQueryer{undefined: true},
+			wantProcs:  -1,
+			wantStatus: CPUQuotaUndefined,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubs := newStubs(t)
+			stubs.StubFunc(&_newQueryer, tt.queryer, nil)
+
+			got, status, err := tt.detector.CPUQuotaToGOMAXPROCS(1, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantProcs, got)
+		})
+	}
+}
+
+func TestCPUThrottledPeriods(t *testing.T) {
+	t.Run("counter", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, testQueryer{throttled: 12}, nil)
+
+		got, ok, err := CPUThrottledPeriods()
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, uint64(12), got)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		stubs := newStubs(t)
+
+		giveErr := errors.New("great sadness")
+		stubs.StubFunc(&_newQueryer, nil, giveErr)
+
+		_, _, err := CPUThrottledPeriods()
+		assert.ErrorIs(t, err, giveErr)
+	})
+
+	t.Run("missing proc files", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, fs.ErrNotExist)
+
+		_, _, err := CPUThrottledPeriods()
+		assert.ErrorIs(t, err, ErrCGroupsNotMounted)
+	})
+
+	t.Run("gVisor", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, fs.ErrNotExist)
+		stubs.StubFunc(&_isGVisor, true)
+
+		_, ok, err := CPUThrottledPeriods()
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestCPUThrottleStats(t *testing.T) {
+	t.Run("counters", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, testQueryer{throttled: 12}, nil)
+
+		got, ok, err := CPUThrottleStats()
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, CPUStat{NrPeriods: 100, NrThrottled: 12, ThrottledTime: 12 * time.Millisecond}, got)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		stubs := newStubs(t)
+		giveErr := errors.New("failed")
+		stubs.StubFunc(&_newQueryer, nil, giveErr)
+
+		_, _, err := CPUThrottleStats()
+		assert.ErrorIs(t, err, giveErr)
+		assert.ErrorIs(t, err, ErrCGroupsUnavailable)
+	})
+
+	t.Run("missing proc files", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, fs.ErrNotExist)
+
+		_, _, err := CPUThrottleStats()
+		assert.ErrorIs(t, err, ErrCGroupsNotMounted)
+	})
+
+	t.Run("gVisor", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, fs.ErrNotExist)
+		stubs.StubFunc(&_isGVisor, true)
+
+		_, ok, err := CPUThrottleStats()
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestDetectorMemoryLimit(t *testing.T) {
+	t.Run("limit", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, testQueryer{memory: 2 << 30}, nil)
+
+		got, ok, err := Detector{}.MemoryLimit()
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, uint64(2<<30), got)
+	})
+
+	t.Run("missing proc files", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, fs.ErrNotExist)
+
+		_, _, err := Detector{}.MemoryLimit()
+		assert.ErrorIs(t, err, ErrCGroupsNotMounted)
+	})
+
+	t.Run("gVisor", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, fs.ErrNotExist)
+		stubs.StubFunc(&_isGVisor, true)
+
+		_, ok, err := Detector{}.MemoryLimit()
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestDetectorProcessCount(t *testing.T) {
+	t.Run("siblings", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, testQueryer{processes: 3}, nil)
+
+		got, ok, err := Detector{}.ProcessCount()
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 3, got)
+	})
+
+	t.Run("missing proc files", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, fs.ErrNotExist)
+
+		_, _, err := Detector{}.ProcessCount()
+		assert.ErrorIs(t, err, ErrCGroupsNotMounted)
+	})
+
+	t.Run("gVisor", func(t *testing.T) {
+		stubs := newStubs(t)
+		stubs.StubFunc(&_newQueryer, nil, fs.ErrNotExist)
+		stubs.StubFunc(&_isGVisor, true)
+
+		_, ok, err := Detector{}.ProcessCount()
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
 type testQueryer struct {
-	v float64
+	v         float64
+	undefined bool
+	shares    float64
+	throttled uint64
+	memory    uint64
+	processes int
+	cpuset    int
+	version   int
 }
 
 func (tq testQueryer) CPUQuota() (float64, bool, error) {
+	if tq.undefined {
+		return -1, false, nil
+	}
 	return tq.v, true, nil
 }
 
+func (tq testQueryer) CPUQuotaPeriod() (int, int, bool, error) {
+	if tq.undefined {
+		return -1, -1, false, nil
+	}
+	return int(tq.v * 100000), 100000, true, nil
+}
+
+func (tq testQueryer) CPUSharesQuota() (float64, bool, error) {
+	if tq.shares <= 0 {
+		return -1, false, nil
+	}
+	return tq.shares, true, nil
+}
+
+func (tq testQueryer) CPUSet() (int, bool, error) {
+	if tq.cpuset <= 0 {
+		return -1, false, nil
+	}
+	return tq.cpuset, true, nil
+}
+
+func (tq testQueryer) CPUStat() (cgroups.CPUStat, bool, error) {
+	return cgroups.CPUStat{NrPeriods: 100, NrThrottled: tq.throttled, ThrottledTime: time.Duration(tq.throttled) * time.Millisecond}, true, nil
+}
+
+func (tq testQueryer) MemoryLimit() (uint64, bool, error) {
+	return tq.memory, tq.memory > 0, nil
+}
+
+func (tq testQueryer) ProcessCount() (int, bool, error) {
+	return tq.processes, tq.processes > 0, nil
+}
+
+func (tq testQueryer) Version() int {
+	if tq.version == 0 {
+		return 2
+	}
+	return tq.version
+}
+
 func newStubs(t *testing.T) *gostub.Stubs {
 	stubs := gostub.New()
 	t.Cleanup(stubs.Reset)
+	// Keep the host's cgroups out of tests that stub the queryer.
+	stubs.StubFunc(&_newFallbackQueryer, nil, cgroups.ErrNotV2)
+	stubs.StubFunc(&_isGVisor, false)
 	return stubs
 }
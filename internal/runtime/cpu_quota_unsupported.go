@@ -18,14 +18,17 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-//go:build !linux
-// +build !linux
+//go:build !linux && !windows && !darwin
+// +build !linux,!windows,!darwin
 
 package runtime
 
-// CPUQuotaToGOMAXPROCS converts the CPU quota applied to the calling process
-// to a valid GOMAXPROCS value. This is Linux-specific and not supported in the
-// current OS.
-func CPUQuotaToGOMAXPROCS(_ int, _ func(v float64) int) (int, CPUQuotaStatus, error) {
+// CPUQuota returns the CPU quota applied to the calling process in cores.
+// This is only supported on Linux, Windows and macOS, not in the current OS,
+// unless the cgroups are read from FS.
+func (d Detector) CPUQuota() (float64, CPUQuotaStatus, error) {
+	if d.FS != nil {
+		return d.cgroupsCPUQuota()
+	}
 	return -1, CPUQuotaUndefined, nil
 }
@@ -0,0 +1,137 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build windows
+// +build windows
+
+package runtime
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// _jobObjectCPURateControlInformation is the JOBOBJECTINFOCLASS of
+	// JOBOBJECT_CPU_RATE_CONTROL_INFORMATION.
+	_jobObjectCPURateControlInformation = 15
+
+	// Flags of JOBOBJECT_CPU_RATE_CONTROL_INFORMATION.
+	_jobObjectCPURateControlEnable      = 0x1
+	_jobObjectCPURateControlWeightBased = 0x2
+	_jobObjectCPURateControlHardCap     = 0x4
+	_jobObjectCPURateControlMinMaxRate  = 0x10
+
+	// _jobObjectCPURateScale is the number of cycles per scheduling interval
+	// a CPU rate refers to, across all CPUs: a rate of 10000 is all of them.
+	_jobObjectCPURateScale = 10000
+)
+
+var (
+	_kernel32                      = syscall.NewLazyDLL("kernel32.dll")
+	_procIsProcessInJob            = _kernel32.NewProc("IsProcessInJob")
+	_procQueryInformationJobObject = _kernel32.NewProc("QueryInformationJobObject")
+)
+
+// jobObjectCPURateControlInformation mirrors
+// JOBOBJECT_CPU_RATE_CONTROL_INFORMATION, whose second field is a union of
+// CpuRate, Weight, and MinRate and MaxRate as two WORDs.
+type jobObjectCPURateControlInformation struct {
+	ControlFlags uint32
+	Rate         uint32
+}
+
+// CPUQuota returns the CPU quota applied to the calling process in cores,
+// e.g. 1.5 for a quota of one and a half CPUs, as enforced by the CPU rate
+// control of its job object, such as that of a Windows container. The
+// status is CPUQuotaUsed, or CPUQuotaUndefined with a quota of -1 if the
+// process isn't in a job or its job has no hard cap on the CPU rate. With
+// FS, the CPU quota is read from the cgroups there instead, as on Linux.
+func (d Detector) CPUQuota() (float64, CPUQuotaStatus, error) {
+	if d.FS != nil {
+		return d.cgroupsCPUQuota()
+	}
+
+	info, inJob, err := queryJobCPURateControl()
+	if !inJob || err != nil {
+		return -1, CPUQuotaUndefined, err
+	}
+
+	quota, defined := jobCPUQuota(info, runtime.NumCPU())
+	if !defined {
+		return -1, CPUQuotaUndefined, nil
+	}
+	return quota, CPUQuotaUsed, nil
+}
+
+// queryJobCPURateControl returns the CPU rate control information of the job
+// object of the calling process. The boolean is false if the process isn't
+// in a job.
+func queryJobCPURateControl() (jobObjectCPURateControlInformation, bool, error) {
+	var info jobObjectCPURateControlInformation
+
+	var inJob int32
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return info, false, err
+	}
+	if r, _, err := _procIsProcessInJob.Call(uintptr(process), 0, uintptr(unsafe.Pointer(&inJob))); r == 0 {
+		return info, false, err
+	}
+	if inJob == 0 {
+		return info, false, nil
+	}
+
+	// A nil job handle stands for the job of the calling process.
+	if r, _, err := _procQueryInformationJobObject.Call(
+		0,
+		_jobObjectCPURateControlInformation,
+		uintptr(unsafe.Pointer(&info)),
+		unsafe.Sizeof(info),
+		0,
+	); r == 0 {
+		return info, true, err
+	}
+	return info, true, nil
+}
+
+// jobCPUQuota converts the CPU rate control of a job object to a CPU quota in
+// cores on a host with numCPU CPUs. Only hard caps count as quota: weights
+// and soft rates let the job use idle CPUs beyond its share.
+func jobCPUQuota(info jobObjectCPURateControlInformation, numCPU int) (float64, bool) {
+	flags := info.ControlFlags
+	if flags&_jobObjectCPURateControlEnable == 0 {
+		return -1, false
+	}
+
+	var rate uint32
+	switch {
+	case flags&_jobObjectCPURateControlMinMaxRate != 0:
+		// MaxRate is the second WORD of the union.
+		rate = info.Rate >> 16
+	case flags&_jobObjectCPURateControlHardCap != 0:
+		rate = info.Rate
+	}
+	if rate == 0 || rate > _jobObjectCPURateScale {
+		return -1, false
+	}
+	return float64(rate) * float64(numCPU) / _jobObjectCPURateScale, true
+}
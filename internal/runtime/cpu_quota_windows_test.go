@@ -0,0 +1,105 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build windows
+// +build windows
+
+package runtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobCPUQuota(t *testing.T) {
+	tests := []struct {
+		name        string
+		info        jobObjectCPURateControlInformation
+		want        float64
+		wantDefined bool
+	}{
+		{
+			name: "hard cap",
+			info: jobObjectCPURateControlInformation{
+				ControlFlags: _jobObjectCPURateControlEnable | _jobObjectCPURateControlHardCap,
+				Rate:         2500,
+			},
+			want:        2,
+			wantDefined: true,
+		},
+		{
+			name: "max rate",
+			info: jobObjectCPURateControlInformation{
+				ControlFlags: _jobObjectCPURateControlEnable | _jobObjectCPURateControlMinMaxRate,
+				Rate:         5000<<16 | 1000,
+			},
+			want:        4,
+			wantDefined: true,
+		},
+		{
+			name: "soft cap",
+			info: jobObjectCPURateControlInformation{
+				ControlFlags: _jobObjectCPURateControlEnable,
+				Rate:         2500,
+			},
+			want: -1,
+		},
+		{
+			name: "weight",
+			info: jobObjectCPURateControlInformation{
+				ControlFlags: _jobObjectCPURateControlEnable | _jobObjectCPURateControlWeightBased,
+				Rate:         5,
+			},
+			want: -1,
+		},
+		{
+			name: "disabled",
+			info: jobObjectCPURateControlInformation{
+				ControlFlags: _jobObjectCPURateControlHardCap,
+				Rate:         2500,
+			},
+			want: -1,
+		},
+		{
+			name: "out of range",
+			info: jobObjectCPURateControlInformation{
+				ControlFlags: _jobObjectCPURateControlEnable | _jobObjectCPURateControlHardCap,
+				Rate:         10001,
+			},
+			want: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quota, defined := jobCPUQuota(tt.info, 8)
+			assert.Equal(t, tt.want, quota)
+			assert.Equal(t, tt.wantDefined, defined)
+		})
+	}
+}
+
+func TestDetectorCPUQuota(t *testing.T) {
+	// The test process may or may not run in a job, but querying it must
+	// work either way.
+	_, _, err := Detector{}.CPUQuota()
+	assert.NoError(t, err)
+}
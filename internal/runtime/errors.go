@@ -0,0 +1,51 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runtime
+
+import "errors"
+
+var (
+	// ErrCGroupsNotMounted is matched by errors caused by missing cgroup
+	// files, e.g. a cgroup path that doesn't exist. A process without any
+	// cgroups isn't an error, but has no CPU quota.
+	ErrCGroupsNotMounted = errors.New("cgroups not mounted")
+
+	// ErrCGroupsUnavailable is matched by errors caused by cgroup files that
+	// exist but can't be accessed, e.g. for lack of permissions, and by
+	// functions requiring cgroups on systems without them.
+	ErrCGroupsUnavailable = errors.New("cgroups unavailable")
+)
+
+// ParseError reports CPU or memory limits that couldn't be parsed, such as a
+// malformed cgroup file. Unlike missing or inaccessible cgroups, it usually
+// points to a bug in the detection.
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return "invalid limits: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying parsing error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
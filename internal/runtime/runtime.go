@@ -20,7 +20,12 @@
 
 package runtime
 
-import "math"
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"time"
+)
 
 // CPUQuotaStatus presents the status of how CPU quota is used
 type CPUQuotaStatus int
@@ -32,9 +37,163 @@ const (
 	CPUQuotaUsed
 	// CPUQuotaMinUsed is returned when CPU quota is smaller than the min value
 	CPUQuotaMinUsed
+	// CPUQuotaSharesUsed is returned when CPU quota is undefined and the value
+	// was estimated from CPU shares instead
+	CPUQuotaSharesUsed
+	// CPUQuotaCPUSetUsed is returned when the cpuset of the process allows
+	// fewer CPUs than the CPU quota, if any
+	CPUQuotaCPUSetUsed
 )
 
+// String returns a short name for the status, such as "quota".
+func (s CPUQuotaStatus) String() string {
+	switch s {
+	case CPUQuotaUndefined:
+		return "undefined"
+	case CPUQuotaUsed:
+		return "quota"
+	case CPUQuotaMinUsed:
+		return "min"
+	case CPUQuotaSharesUsed:
+		return "shares"
+	case CPUQuotaCPUSetUsed:
+		return "cpuset"
+	default:
+		return fmt.Sprintf("CPUQuotaStatus(%d)", int(s))
+	}
+}
+
+// TotalMemoryStatus presents the status of how the memory limit is used
+type TotalMemoryStatus int
+
+const (
+	// TotalMemoryUndefined is returned when the memory limit is undefined
+	TotalMemoryUndefined TotalMemoryStatus = iota
+	// TotalMemoryUsed is returned when a valid memory limit can be used
+	TotalMemoryUsed
+)
+
+// CPUStat holds the CFS throttling counters of the CPU cgroup of the calling
+// process. The counters only grow, so callers compare two readings.
+type CPUStat struct {
+	// NrPeriods is the number of CFS periods that elapsed with runnable
+	// tasks in the cgroup.
+	NrPeriods uint64
+	// NrThrottled is the number of those periods in which the cgroup was
+	// throttled.
+	NrThrottled uint64
+	// ThrottledTime is the total time the cgroup was throttled for.
+	ThrottledTime time.Duration
+}
+
+// CGroupHybrid is the version of cgroups reported for systems mounting
+// cgroups v1 controllers alongside a cgroups v2 hierarchy.
+const CGroupHybrid = 3
+
+// _defaultProcFS is where procfs is usually mounted.
+const _defaultProcFS = "/proc"
+
+// A Detector detects the CPU quota applied to the calling process. The zero
+// value reads process information from the procfs mounted at /proc.
+type Detector struct {
+	// ProcFS is the mount point of the procfs to read the process'
+	// `mountinfo` and `cgroup` files from. Defaults to /proc.
+	ProcFS string
+
+	// FS, if set, is the filesystem to read procfs and cgroup files from
+	// instead of the operating system's, with absolute paths such as ProcFS
+	// or CPUCGroupPath taken relative to its root. The cgroups are then read
+	// from FS on any OS, which lets tests stand in for a Linux host.
+	FS fs.FS
+
+	// MaxReadSize, if positive, caps how many bytes are read from each
+	// procfs or cgroup file, in place of the default of 4MiB; reading past
+	// the cap fails.
+	MaxReadSize int64
+
+	// PID, if set, is the process whose cgroups are read instead of the
+	// calling process', e.g. the parent of a process started in a
+	// container's init. Its `mountinfo` and `cgroup` files are read from
+	// `<ProcFS>/<PID>` rather than `<ProcFS>/self`.
+	PID int
+
+	// CPUCGroupPath, if set, is the directory of the CPU cgroup to read the
+	// cgroups v1 CPU quota from, bypassing procfs.
+	CPUCGroupPath string
+
+	// SharesFallback estimates the CPU quota from CPU shares (cgroups v1)
+	// or CPU weight (cgroups v2) when no CPU quota is defined.
+	SharesFallback bool
+
+	// PreferShares estimates the CPU quota from CPU shares or CPU weight
+	// even when a CPU quota is defined, which then only caps the estimate.
+	PreferShares bool
+
+	// Logger, if set, receives messages about the detection, such as falling
+	// back from one version of cgroups to the other.
+	Logger func(format string, args ...interface{})
+
+	// Cache, if set, keeps the cgroups located by the first detection for
+	// later ones. It's ignored with CPUCGroupPath or FS.
+	Cache *Cache
+}
+
+func (d Detector) log(format string, args ...interface{}) {
+	if d.Logger != nil {
+		d.Logger(format, args...)
+	}
+}
+
+func (d Detector) procFS() string {
+	if d.ProcFS == "" {
+		return _defaultProcFS
+	}
+	return d.ProcFS
+}
+
+// CPUQuotaToGOMAXPROCS converts the CPU quota applied to the calling process
+// to a valid GOMAXPROCS value. The quota is converted from float to int using round.
+// If round == nil, DefaultRoundFunc is used. CPU quotas are supported on
+// Linux, Windows and macOS; elsewhere, the status is always CPUQuotaUndefined.
+func CPUQuotaToGOMAXPROCS(minValue int, round func(v float64) int) (int, CPUQuotaStatus, error) {
+	return Detector{}.CPUQuotaToGOMAXPROCS(minValue, round)
+}
+
+// CPUQuotaToGOMAXPROCS is like the package-level CPUQuotaToGOMAXPROCS, but
+// uses the Detector's settings to find the CPU quota.
+func (d Detector) CPUQuotaToGOMAXPROCS(minValue int, round func(v float64) int) (int, CPUQuotaStatus, error) {
+	quota, status, err := d.CPUQuota()
+	if status == CPUQuotaUndefined || err != nil {
+		return -1, CPUQuotaUndefined, err
+	}
+
+	maxProcs, quotaStatus := QuotaToGOMAXPROCS(quota, minValue, round)
+	if quotaStatus == CPUQuotaMinUsed {
+		return maxProcs, quotaStatus, nil
+	}
+	return maxProcs, status, nil
+}
+
 // DefaultRoundFunc is the default function to convert CPU quota from float to int. It rounds the value down (floor).
 func DefaultRoundFunc(v float64) int {
 	return int(math.Floor(v))
 }
+
+// QuotaToGOMAXPROCS converts a CPU quota to a valid GOMAXPROCS value of at
+// least minValue. The quota is converted from float to int using round. If
+// round == nil, DefaultRoundFunc is used. A minValue below 1 counts as 1,
+// since runtime.GOMAXPROCS(0) only queries the current value.
+func QuotaToGOMAXPROCS(quota float64, minValue int, round func(v float64) int) (int, CPUQuotaStatus) {
+	if round == nil {
+		round = DefaultRoundFunc
+	}
+	if minValue < 1 {
+		minValue = 1
+	}
+
+	maxProcs := round(quota)
+	if maxProcs < minValue {
+		return minValue, CPUQuotaMinUsed
+	}
+	return maxProcs, CPUQuotaUsed
+}
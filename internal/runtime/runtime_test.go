@@ -0,0 +1,66 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runtime
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaToGOMAXPROCS(t *testing.T) {
+	ceil := func(v float64) int { return int(math.Ceil(v)) }
+
+	tests := []struct {
+		name       string
+		quota      float64
+		min        int
+		round      func(float64) int
+		wantProcs  int
+		wantStatus CPUQuotaStatus
+	}{
+		{name: "floor by default", quota: 2.7, min: 1, wantProcs: 2, wantStatus: CPUQuotaUsed},
+		{name: "ceil", quota: 2.2, min: 1, round: ceil, wantProcs: 3, wantStatus: CPUQuotaUsed},
+		{name: "below min", quota: 0.5, min: 1, wantProcs: 1, wantStatus: CPUQuotaMinUsed},
+		{name: "rounded above min", quota: 0.5, min: 1, round: ceil, wantProcs: 1, wantStatus: CPUQuotaUsed},
+		{name: "rounded below min", quota: 0.5, min: 2, round: ceil, wantProcs: 2, wantStatus: CPUQuotaMinUsed},
+		{name: "no min", quota: 0.5, wantProcs: 1, wantStatus: CPUQuotaMinUsed},
+		{name: "negative min", quota: 0.5, min: -2, wantProcs: 1, wantStatus: CPUQuotaMinUsed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procs, status := QuotaToGOMAXPROCS(tt.quota, tt.min, tt.round)
+			assert.Equal(t, tt.wantProcs, procs)
+			assert.Equal(t, tt.wantStatus, status)
+		})
+	}
+}
+
+func TestCPUQuotaStatusString(t *testing.T) {
+	assert.Equal(t, "undefined", CPUQuotaUndefined.String())
+	assert.Equal(t, "quota", CPUQuotaUsed.String())
+	assert.Equal(t, "min", CPUQuotaMinUsed.String())
+	assert.Equal(t, "shares", CPUQuotaSharesUsed.String())
+	assert.Equal(t, "cpuset", CPUQuotaCPUSetUsed.String())
+	assert.Equal(t, "CPUQuotaStatus(42)", CPUQuotaStatus(42).String())
+}
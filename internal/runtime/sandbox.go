@@ -0,0 +1,42 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runtime
+
+import (
+	"path"
+	"strings"
+
+	cg "go.uber.org/automaxprocs/internal/cgroups"
+)
+
+// _gVisorProcVersion is the build stamp gVisor (runsc) reports for its
+// emulated kernel in /proc/version, regardless of the host kernel.
+const _gVisorProcVersion = "#1 SMP Sun Jan 10 15:06:54 PST 2016"
+
+// procIsGVisor reports whether `<ProcFS>/version` bears the build stamp of
+// gVisor, reading it from FS if set.
+func (d Detector) procIsGVisor() bool {
+	version, err := cg.ReadFile(d.fsys(), path.Join(d.procFS(), "version"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(version), _gVisorProcVersion)
+}
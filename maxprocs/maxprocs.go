@@ -24,13 +24,82 @@
 package maxprocs // import "go.uber.org/automaxprocs/maxprocs"
 
 import (
+	"context"
+	"fmt"
+	"io/fs"
+	"math"
 	"os"
+	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
 
+	"go.uber.org/automaxprocs/detect"
 	iruntime "go.uber.org/automaxprocs/internal/runtime"
 )
 
-const _maxProcsKey = "GOMAXPROCS"
+const (
+	_maxProcsKey = "GOMAXPROCS"
+	_memLimitKey = "GOMEMLIMIT"
+)
+
+// Names of the gauges reported to the function supplied with GaugeFunc.
+const (
+	_gaugeQuotaCores    = "automaxprocs_quota_cores"
+	_gaugeGOMAXPROCS    = "automaxprocs_gomaxprocs"
+	_gaugeMemLimitBytes = "automaxprocs_mem_limit_bytes"
+)
+
+// _maxGOMAXPROCS is the default upper bound on the GOMAXPROCS value Set will
+// apply, as a safety net against absurd values from a misconfigured quota.
+const _maxGOMAXPROCS = 1024
+
+// _defaultMemReserve is the percentage of the memory limit SetMemoryLimit
+// keeps in reserve unless overridden with MemoryLimitReserve, so that the
+// garbage collector has room to react before the process is OOM-killed.
+const _defaultMemReserve = 10
+
+// _minMemLimit is the lowest GOMEMLIMIT the reserve may bring the memory
+// limit down to, as a smaller heap would leave the program thrashing in the
+// garbage collector.
+const _minMemLimit = 16 << 20
+
+// _defaultMHzPerCore is the clock rate SchedulerEnvFallback converts CPU
+// allocations in MHz to cores with, unless overridden with
+// SchedulerMHzPerCore.
+const _defaultMHzPerCore = 2000
+
+// _schedulerEnvVars lists the environment variables through which schedulers
+// hint at the CPU allocation of a task, see SchedulerEnvFallback.
+var _schedulerEnvVars = []struct {
+	name string
+	mhz  bool
+}{
+	{name: "NOMAD_CPU_LIMIT", mhz: true},
+	{name: "MESOS_CPU"},
+}
+
+// _bytesPerGiB is the size of the unit of memory MaxProcsPerMemGB uses.
+const _bytesPerGiB = 1 << 30
+
+// Errors returned by Set and the other functions of the package can be told
+// apart with errors.Is and errors.As, e.g. to alert only on parse failures.
+// The GOMAXPROCS environment variable and a missing CPU quota aren't errors.
+var (
+	// ErrCGroupsNotMounted is matched by errors caused by missing cgroup
+	// files, such as an invalid CPUCGroupPath.
+	ErrCGroupsNotMounted = detect.ErrCGroupsNotMounted
+
+	// ErrCGroupsUnavailable is matched by errors caused by cgroup files that
+	// exist but can't be read, e.g. for lack of permissions.
+	ErrCGroupsUnavailable = detect.ErrCGroupsUnavailable
+)
+
+// ParseError reports CPU or memory limits that couldn't be parsed, e.g. a
+// malformed cgroup file.
+type ParseError = detect.ParseError
 
 func currentMaxProcs() int {
 	return runtime.GOMAXPROCS(0)
@@ -38,14 +107,492 @@ func currentMaxProcs() int {
 
 type config struct {
 	printf         func(string, ...interface{})
-	procs          func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error)
+	kvLogger       KeyValueLogger
+	slog           leveledLogger
+	warning        func(msg string)
+	procs          func(int, func(v float64) int) (int, detect.Status, error)
+	quotaPeriod    func() (quota, period, version int, err error)
+	cpuQuota       func() (float64, detect.Status, error)
+	quotaProvider  func() (float64, detect.Status, error)
+	detector       detect.Detector
+	isGVisor       func() bool
+	numCPU         func() int
 	minGOMAXPROCS  int
+	maxGOMAXPROCS  int
 	roundQuotaFunc func(v float64) int
+	gauge          func(name string, value float64)
+	logAllocation  bool
+	burstBlend     float64
+	cpuReserve     float64
+	memLimit       func() (uint64, bool, error)
+	procsPerMemGB  float64
+	physMem        func() (uint64, bool)
+	memReserve     float64
+	uncontained    bool
+	dryRun         bool
+	decisionHook   func(Decision)
+	divideQuota    bool
+	processCount   func() (int, bool, error)
+	onlineCPUs     bool
+	envOverride    string
+	schedulerEnv   bool
+	mhzPerCore     float64
+	err            error
+
+	// held, if set, collects the messages to log once runContext is done
+	// with the config.
+	held *[]func()
+	// gaugesHeld, if set, defers reporting gauges until SetAll is done with
+	// both limits.
+	gaugesHeld bool
+
+	// quota is the CPU quota detected by procs, or -1 if it's unknown.
+	quota float64
+	// status is the status reported by procs, if called.
+	status detect.Status
+	// wouldSet is the GOMAXPROCS value Set would have applied but for
+	// DryRun, or 0.
+	wouldSet int
+	// siblings is the number of processes sharing the CPU quota if it's
+	// divided among them with DivideBySiblings, or 0.
+	siblings int
+	// goMemLimit is the memory limit set by setMemoryLimit, or -1.
+	goMemLimit int64
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		numCPU:         runtime.NumCPU,
+		physMem:        iruntime.PhysicalMemory,
+		roundQuotaFunc: iruntime.DefaultRoundFunc,
+		minGOMAXPROCS:  1,
+		maxGOMAXPROCS:  _maxGOMAXPROCS,
+		memReserve:     _defaultMemReserve,
+		mhzPerCore:     _defaultMHzPerCore,
+		quota:          -1,
+		goMemLimit:     -1,
+	}
+	for _, o := range opts {
+		o.apply(cfg)
+	}
+	if cfg.memLimit == nil {
+		cfg.memLimit = cfg.detector.MemoryLimit
+	}
+	if cfg.cpuQuota == nil {
+		cfg.cpuQuota = cfg.detector.CPUQuota
+	}
+	if cfg.isGVisor == nil {
+		cfg.isGVisor = cfg.detector.IsGVisor
+	}
+	if cfg.quotaPeriod == nil {
+		cfg.quotaPeriod = iruntime.Detector{
+			ProcFS:        cfg.detector.ProcFS,
+			FS:            cfg.detector.FS,
+			MaxReadSize:   cfg.detector.MaxReadSize,
+			PID:           cfg.detector.PID,
+			CPUCGroupPath: cfg.detector.CPUCGroupPath,
+		}.CPUQuotaPeriod
+	}
+	if cfg.processCount == nil {
+		cfg.processCount = iruntime.Detector{
+			ProcFS:        cfg.detector.ProcFS,
+			FS:            cfg.detector.FS,
+			MaxReadSize:   cfg.detector.MaxReadSize,
+			PID:           cfg.detector.PID,
+			CPUCGroupPath: cfg.detector.CPUCGroupPath,
+		}.ProcessCount
+	}
+	return cfg
+}
+
+// runContext runs f, which reads procfs or cgroups, until ctx is done. Since
+// blocking file reads can't be interrupted, f runs in the background against
+// a copy of the config whose messages are held back. Once f returns, the copy
+// replaces the config and its messages are logged; if ctx is done first, f is
+// abandoned along with the copy and ctx's error is returned.
+func (c *config) runContext(ctx context.Context, f func(c *config) error) error {
+	if ctx.Done() == nil {
+		return f(c)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var held []func()
+	rc := *c
+	rc.held = &held
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f(&rc)
+	}()
+
+	select {
+	case err := <-done:
+		rc.held = c.held
+		*c = rc
+		for _, h := range held {
+			h()
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// capMaxProcs limits maxProcs to the maximum allowed GOMAXPROCS.
+func (c *config) capMaxProcs(maxProcs int) int {
+	if maxProcs > c.maxGOMAXPROCS {
+		c.warn("maxprocs: Capping GOMAXPROCS=%v to maximum allowed GOMAXPROCS=%v", maxProcs, c.maxGOMAXPROCS)
+		return c.maxGOMAXPROCS
+	}
+	return maxProcs
+}
+
+// capMemProcs caps maxProcs according to MaxProcsPerMemGB, if set, without
+// going below the minimum.
+func (c *config) capMemProcs(maxProcs int) (int, error) {
+	if c.procsPerMemGB <= 0 {
+		return maxProcs, nil
+	}
+
+	limit, ok, err := c.memLimit()
+	if err != nil || !ok {
+		return maxProcs, err
+	}
+
+	memProcs := int(c.procsPerMemGB * float64(limit) / _bytesPerGiB)
+	if memProcs < c.minGOMAXPROCS {
+		memProcs = c.minGOMAXPROCS
+	}
+	if maxProcs > memProcs {
+		c.log("maxprocs: Capping GOMAXPROCS=%v to %v for memory limit of %v bytes", maxProcs, memProcs, limit)
+		return memProcs, nil
+	}
+	return maxProcs, nil
+}
+
+// detectProcs derives GOMAXPROCS from the CPU quota of the QuotaProvider,
+// if it defines one, and otherwise through the procs override or, by
+// default, the detector, which logs through c so that
+// notes such as falling back to another version of cgroups are held back
+// with the rest of the output of SetContext.
+func (c *config) detectProcs(minValue int, round func(v float64) int) (int, detect.Status, error) {
+	if c.quotaProvider != nil {
+		quota, status, err := c.quotaProvider()
+		if err != nil {
+			return -1, detect.Undefined, fmt.Errorf("maxprocs: quota provider failed: %w", err)
+		}
+		if status != detect.Undefined {
+			maxProcs, quotaStatus := detect.QuotaToGOMAXPROCS(quota, minValue, round)
+			if quotaStatus == detect.MinUsed {
+				return maxProcs, quotaStatus, nil
+			}
+			return maxProcs, status, nil
+		}
+	}
+	if c.procs != nil {
+		return c.procs(minValue, round)
+	}
+	d := c.detector
+	d.Logger = func(format string, args ...interface{}) {
+		c.logKV(levelWarn, nil, format, args...)
+	}
+	return d.GOMAXPROCS(minValue, round)
+}
+
+// resolve derives the GOMAXPROCS value from the CPU quota, applying all
+// options. If there is no CPU quota, it returns -1 and detect.Undefined.
+func (c *config) resolve() (int, detect.Status, error) {
+	if err := c.countSiblings(); err != nil {
+		return -1, detect.Undefined, err
+	}
+
+	maxProcs, status, err := c.detectProcs(c.minGOMAXPROCS, c.round)
+	if err != nil || status == detect.Undefined {
+		return -1, detect.Undefined, err
+	}
+
+	maxProcs, err = c.capMemProcs(c.capMaxProcs(maxProcs))
+	if err != nil {
+		return -1, detect.Undefined, err
+	}
+	return maxProcs, status, nil
+}
+
+// countSiblings counts the processes of the cgroup the CPU quota is divided
+// among if requested with DivideBySiblings.
+func (c *config) countSiblings() error {
+	c.siblings = 0
+	if !c.divideQuota {
+		return nil
+	}
+
+	n, defined, err := c.processCount()
+	if err != nil {
+		return err
+	}
+	if defined && n > 1 {
+		c.siblings = n
+	}
+	return nil
+}
+
+// round converts the CPU quota to an int with roundQuotaFunc, after dividing
+// it among sibling processes with DivideBySiblings, blending it with the
+// number of CPUs if requested with BurstBlend and setting aside the
+// CPUReservePercent. It remembers the undivided quota so that it can be
+// reported after Set.
+func (c *config) round(v float64) int {
+	c.quota = v
+	if c.siblings > 1 {
+		c.warn("maxprocs: Dividing CPU quota of %v among %v processes in the cgroup", v, c.siblings)
+		v /= float64(c.siblings)
+	}
+	if c.burstBlend > 0 {
+		numCPU := float64(c.numCPU())
+		v = math.Min(v+c.burstBlend*(numCPU-v), numCPU)
+	}
+	v -= v * c.cpuReserve / 100
+	return c.roundQuotaFunc(v)
+}
+
+// allocation describes the detected CPU quota relative to the CPUs of the
+// host if requested with LogAllocation. Otherwise, it returns "".
+func (c *config) allocation() string {
+	if !c.logAllocation || c.quota < 0 {
+		return ""
+	}
+	numCPU := c.numCPU()
+	return fmt.Sprintf(", quota %v of %v host cores (%.0f%%)", c.quota, numCPU, 100*c.quota/float64(numCPU))
+}
+
+// reportGauges reports the detected CPU quota, if any, the current
+// GOMAXPROCS and the memory limit applied, if any, to the gauge function.
+func (c *config) reportGauges() {
+	if c.gauge == nil || c.gaugesHeld {
+		return
+	}
+	if c.quota >= 0 {
+		c.gauge(_gaugeQuotaCores, c.quota)
+	}
+	c.gauge(_gaugeGOMAXPROCS, float64(currentMaxProcs()))
+	if c.goMemLimit >= 0 {
+		c.gauge(_gaugeMemLimitBytes, float64(c.goMemLimit))
+	}
+}
+
+// envMaxProcs returns the GOMAXPROCS environment variable if it's set to a
+// value the Go runtime honors, i.e. a positive integer. Other values are
+// ignored by the runtime, and thus here as well.
+func (c *config) envMaxProcs() (string, bool) {
+	max, exists := os.LookupEnv(_maxProcsKey)
+	if !exists {
+		return "", false
+	}
+	if n, err := strconv.Atoi(max); err != nil || n <= 0 {
+		c.warn("maxprocs: Ignoring invalid GOMAXPROCS=%q in environment", max)
+		return "", false
+	}
+	return max, true
+}
+
+// envOverrideProcs returns the GOMAXPROCS value for the CPU count in the
+// EnvOverride environment variable, if one is configured and set to a
+// positive number, along with the raw value. Other values are logged and
+// ignored.
+func (c *config) envOverrideProcs() (int, string, bool) {
+	if c.envOverride == "" {
+		return 0, "", false
+	}
+	value, exists := os.LookupEnv(c.envOverride)
+	if !exists {
+		return 0, "", false
+	}
+	cpus, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(cpus > 0) || math.IsInf(cpus, 1) {
+		c.warn("maxprocs: Ignoring invalid %s=%q in environment", c.envOverride, value)
+		return 0, "", false
+	}
+
+	maxProcs := c.roundQuotaFunc(cpus)
+	if maxProcs < c.minGOMAXPROCS {
+		maxProcs = c.minGOMAXPROCS
+	}
+	return c.capMaxProcs(maxProcs), value, true
+}
+
+// reserveMem returns the memory limit less the MemoryLimitReserve, without
+// going below _minMemLimit unless limit already is.
+func (c *config) reserveMem(limit uint64) uint64 {
+	reserved := limit - uint64(float64(limit)*c.memReserve/100)
+	if reserved >= _minMemLimit || reserved == limit {
+		return reserved
+	}
+
+	floor := uint64(_minMemLimit)
+	if limit < floor {
+		floor = limit
+	}
+	c.warn("maxprocs: Clamping GOMEMLIMIT=%v to minimum of %v bytes after %v%% reserve", reserved, floor, c.memReserve)
+	return floor
+}
+
+// schedulerEnvProcs returns the GOMAXPROCS value for the CPU allocation a
+// scheduler advertises in the environment if requested with
+// SchedulerEnvFallback, along with the variable it was taken from and its
+// value. It never exceeds the number of CPUs. Invalid values are logged and
+// skipped.
+func (c *config) schedulerEnvProcs() (procs int, name, value string, ok bool) {
+	if !c.schedulerEnv {
+		return 0, "", "", false
+	}
+
+	for _, v := range _schedulerEnvVars {
+		value, exists := os.LookupEnv(v.name)
+		if !exists {
+			continue
+		}
+		cpus, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(cpus > 0) || math.IsInf(cpus, 1) {
+			c.warn("maxprocs: Ignoring invalid %s=%q in environment", v.name, value)
+			continue
+		}
+		if v.mhz {
+			cpus /= c.mhzPerCore
+		}
+
+		procs := c.roundQuotaFunc(cpus)
+		if procs < c.minGOMAXPROCS {
+			procs = c.minGOMAXPROCS
+		}
+		if numCPU := c.numCPU(); procs > numCPU {
+			procs = numCPU
+		}
+		return c.capMaxProcs(procs), v.name, value, true
+	}
+	return 0, "", "", false
+}
+
+// undefinedQuotaProcs returns the GOMAXPROCS value Set applies when there is
+// no CPU quota: the allocation of a scheduler with SchedulerEnvFallback,
+// along with the variable it was taken from and its value, or else the
+// number of online CPUs with UseOnlineCPUs. It reports false if Set leaves
+// GOMAXPROCS alone instead.
+func (c *config) undefinedQuotaProcs() (procs int, source Source, name, value string, ok bool) {
+	if procs, name, value, ok := c.schedulerEnvProcs(); ok {
+		return procs, SourceEnv, name, value, true
+	}
+	if !c.onlineCPUs {
+		return 0, SourceNumCPU, "", "", false
+	}
+	online := c.capMaxProcs(c.numCPU())
+	if online < 1 || online == currentMaxProcs() {
+		return 0, SourceNumCPU, "", "", false
+	}
+	return online, SourceNumCPU, "", "", true
+}
+
+// reportDecision reports the outcome of Set to the DecisionHook, if any.
+func (c *config) reportDecision(prev int, source Source, err error) {
+	if c.decisionHook == nil {
+		return
+	}
+	c.decisionHook(c.decision(prev, source, err))
 }
 
-func (c *config) log(fmt string, args ...interface{}) {
-	if c.printf != nil {
-		c.printf(fmt, args...)
+// decision describes the outcome of Set, given the GOMAXPROCS value before
+// it.
+func (c *config) decision(prev int, source Source, err error) Decision {
+	return Decision{
+		Source:      source,
+		Quota:       c.quota,
+		Status:      c.status,
+		GOMAXPROCS:  currentMaxProcs(),
+		Previous:    prev,
+		DryRun:      c.wouldSet > 0,
+		WouldSet:    c.wouldSet,
+		BelowOneCPU: c.belowOneCPU(),
+		Err:         err,
+	}
+}
+
+// belowOneCPU tells whether the detected CPU quota is less than one CPU, so
+// that even GOMAXPROCS=1 overcommits it.
+func (c *config) belowOneCPU() bool {
+	return c.quota >= 0 && c.quota < 1
+}
+
+// logLevel is the severity of a log message, which only the Slog logger
+// records.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+)
+
+// A leveledLogger receives log messages along with their level, see Slog.
+type leveledLogger interface {
+	logAt(level logLevel, msg string, keyvals ...interface{})
+}
+
+func (c *config) log(format string, args ...interface{}) {
+	c.logKV(levelInfo, nil, format, args...)
+}
+
+// logKV logs a message to the Slog or StructuredLogger logger along with
+// keyvals, or to the printf Logger without them. Only the Slog logger
+// receives debug messages. While runContext holds the config, the message is
+// held back instead.
+func (c *config) logKV(level logLevel, keyvals []interface{}, format string, args ...interface{}) {
+	if c.held != nil {
+		*c.held = append(*c.held, func() { c.logKV(level, keyvals, format, args...) })
+		return
+	}
+
+	switch {
+	case c.slog != nil:
+		c.slog.logAt(level, fmt.Sprintf(format, args...), keyvals...)
+	case level == levelDebug:
+	case c.kvLogger != nil:
+		c.kvLogger.Log(fmt.Sprintf(format, args...), keyvals...)
+	case c.printf != nil:
+		c.printf(format, args...)
+	}
+}
+
+// warn reports a non-fatal problem with the detection to the warning
+// handler, or logs it if there is none.
+func (c *config) warn(format string, args ...interface{}) {
+	c.warnKV(nil, format, args...)
+}
+
+// warnKV is warn with keyvals for the structured loggers.
+func (c *config) warnKV(keyvals []interface{}, format string, args ...interface{}) {
+	if c.warning == nil {
+		c.logKV(levelWarn, keyvals, format, args...)
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if c.held != nil {
+		*c.held = append(*c.held, func() { c.warning(msg) })
+		return
+	}
+	c.warning(msg)
+}
+
+// decisionFields returns the details of a decision of Set to leave or set
+// GOMAXPROCS to procs as keyvals for the StructuredLogger.
+func (c *config) decisionFields(procs int, source Source) []interface{} {
+	return []interface{}{
+		"gomaxprocs", procs,
+		"previous", currentMaxProcs(),
+		"quota", c.quota,
+		"status", c.status.String(),
+		"source", source.String(),
 	}
 }
 
@@ -62,13 +609,399 @@ func Logger(printf func(string, ...interface{})) Option {
 	})
 }
 
-// Min sets the minimum GOMAXPROCS value that will be used.
-// Any value below 1 is ignored.
+// A KeyValueLogger receives log messages along with structured details as
+// alternating keys and values, in the style of zap's SugaredLogger or
+// go-kit's log.Logger.
+type KeyValueLogger interface {
+	Log(msg string, keyvals ...interface{})
+}
+
+// StructuredLogger sends log output to l. Messages about the decision made
+// by Set carry its details as the keys "gomaxprocs", "previous", "quota",
+// "status" and "source", so they can be logged as fields rather than parsed
+// back out of the message. It takes precedence over Logger.
+func StructuredLogger(l KeyValueLogger) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.kvLogger = l
+	})
+}
+
+// SchedulerEnvFallback makes Set fall back to the CPU allocation schedulers
+// advertise in the environment if there is no CPU quota: NOMAD_CPU_LIMIT,
+// in MHz, as set by HashiCorp Nomad, or MESOS_CPU, in cores. MHz are
+// converted to cores at 2000 MHz per core unless changed with
+// SchedulerMHzPerCore. The allocation only ever lowers GOMAXPROCS below the
+// number of CPUs, and honors Min and Max.
+func SchedulerEnvFallback() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.schedulerEnv = true
+	})
+}
+
+// SchedulerMHzPerCore sets the clock rate SchedulerEnvFallback converts CPU
+// allocations in MHz to cores with, which is the MHz per core Nomad
+// fingerprinted on the host. Clock rates that aren't positive and finite
+// are rejected like an invalid Min.
+func SchedulerMHzPerCore(mhz float64) Option {
+	return optionFunc(func(cfg *config) {
+		if !(mhz > 0) || math.IsInf(mhz, 1) {
+			cfg.err = fmt.Errorf("maxprocs: invalid clock rate %v MHz per core, must be positive and finite", mhz)
+			return
+		}
+		cfg.mhzPerCore = mhz
+	})
+}
+
+// QuotaProvider makes Set read the CPU quota in cores from provider in place
+// of detecting it from cgroups, e.g. on platforms exposing CPU limits
+// through an API of their own. If provider reports detect.Undefined, Set
+// falls back to detection; if it fails, so does Set. The quota is converted
+// to GOMAXPROCS like a detected one, honoring options such as Min and
+// RoundQuotaFunc. Detect and Watch use provider too.
+func QuotaProvider(provider func() (float64, detect.Status, error)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.quotaProvider = provider
+	})
+}
+
+// EnvOverride honors the environment variable name as a CPU count, e.g.
+// "2" or "1.5", overriding the CPU quota. Like GOMAXPROCS, which still takes
+// precedence, a valid value skips detection entirely; it's rounded like a
+// CPU quota and subject to Min and Max. Invalid values are logged and
+// ignored.
+func EnvOverride(name string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.envOverride = name
+	})
+}
+
+// AssumeUncontained skips detection entirely and leaves GOMAXPROCS at the
+// Go runtime's default, the number of CPUs. It's meant for bare-metal
+// deployments known to have no cgroup limits, where it saves reading /proc
+// and rules out misdetection on unusual cgroup setups. The GOMAXPROCS
+// environment variable is still honored.
+func AssumeUncontained() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.uncontained = true
+	})
+}
+
+// DryRun makes Set detect the CPU quota and log the GOMAXPROCS value it
+// would apply, without changing GOMAXPROCS, e.g. to observe automaxprocs
+// across a fleet before rolling it out. The source and status are reported
+// as without DryRun, and the would-be value as WouldSet of the Decision and
+// Result. The returned undo function is then a no-op. Watch likewise only
+// logs the changes it would make.
+func DryRun() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.dryRun = true
+	})
+}
+
+// UseOnlineCPUs counts only the CPUs the kernel has online, as listed in
+// `/sys/devices/system/cpu/online`, rather than runtime.NumCPU, which may
+// include offlined CPUs. Without a CPU quota, Set then sets GOMAXPROCS to the
+// number of online CPUs; the count also replaces the number of host cores
+// for BurstBlend and LogAllocation. If the list is unavailable, e.g. on other
+// systems than Linux, runtime.NumCPU is used.
+func UseOnlineCPUs() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.onlineCPUs = true
+		cfg.numCPU = onlineCPUs
+	})
+}
+
+// onlineCPUs returns the number of online CPUs, or runtime.NumCPU if it's
+// unknown.
+func onlineCPUs() int {
+	if n, ok, err := iruntime.OnlineCPUs(); err == nil && ok {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
+// DivideBySiblings divides the CPU quota evenly among the processes in the
+// cgroup, as listed in its `cgroup.procs` file, for setups where several
+// Go processes share one quota, e.g. a supervisor and its workers in the same
+// container. Each then gets its share of the quota rather than all of it,
+// and a warning reports the division. Since init processes and shells also
+// show up in `cgroup.procs`, this is off by default. It's Linux-specific and
+// has no effect elsewhere.
+func DivideBySiblings() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.divideQuota = true
+	})
+}
+
+// WarningHandler sends warnings about the detection, such as GOMAXPROCS
+// being estimated from CPU shares or capped at the maximum, to the supplied
+// function instead of the logger. This lets applications route them to an
+// alerting path while keeping the log quiet. By default, warnings are logged
+// like any other message.
+func WarningHandler(f func(msg string)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.warning = f
+	})
+}
+
+// Min sets the minimum GOMAXPROCS value that will be used. It defaults to 1.
+// Values below 1 are rejected: Set and the other functions returning an error
+// fail with it, while those that don't ignore the value.
 func Min(n int) Option {
 	return optionFunc(func(cfg *config) {
-		if n >= 1 {
-			cfg.minGOMAXPROCS = n
+		if n < 1 {
+			cfg.err = fmt.Errorf("maxprocs: invalid minimum GOMAXPROCS %d, must be at least 1", n)
+			return
+		}
+		cfg.minGOMAXPROCS = n
+	})
+}
+
+// Max sets the maximum GOMAXPROCS value that will be used, regardless of the
+// CPU quota or the minimum set by Min. It defaults to 1024. Values below 1
+// are rejected like those of Min.
+func Max(n int) Option {
+	return optionFunc(func(cfg *config) {
+		if n < 1 {
+			cfg.err = fmt.Errorf("maxprocs: invalid maximum GOMAXPROCS %d, must be at least 1", n)
+			return
+		}
+		cfg.maxGOMAXPROCS = n
+	})
+}
+
+// GOMAXPROCSBounds keeps GOMAXPROCS within [minValue, maxValue] whatever the
+// CPU quota, e.g. to behave predictably across heterogeneous nodes. It's
+// Min and Max combined: a value raised to minValue reports
+// CPUQuotaMinUsed, and a value lowered to maxValue is logged. Bounds below 1
+// or with minValue above maxValue are rejected: Set and the other functions
+// returning an error fail with them, while those that don't ignore them.
+func GOMAXPROCSBounds(minValue, maxValue int) Option {
+	return optionFunc(func(cfg *config) {
+		if minValue < 1 || maxValue < minValue {
+			cfg.err = fmt.Errorf("maxprocs: invalid GOMAXPROCS bounds [%d, %d], must satisfy 1 <= min <= max", minValue, maxValue)
+			return
+		}
+		cfg.minGOMAXPROCS = minValue
+		cfg.maxGOMAXPROCS = maxValue
+	})
+}
+
+// ProcFS reads the process information used to find the CPU quota, such as
+// `/proc/self/cgroup` and `/proc/self/mountinfo`, from the procfs mounted at
+// path instead of `/proc`. This is useful for chrooted processes and for
+// inspecting captured snapshots of procfs. Set fails if path doesn't exist.
+func ProcFS(path string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.detector.ProcFS = path
+	})
+}
+
+// CGroupFS reads procfs and cgroup files from fsys instead of the operating
+// system's, see detect.Detector.FS. Absolute paths, such as the one given to
+// ProcFS, are taken relative to the root of fsys. This lets tests of code
+// calling Set exercise the whole detection against a testdata tree or an
+// fstest.MapFS standing in for a Linux host, on any OS.
+func CGroupFS(fsys fs.FS) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.detector.FS = fsys
+	})
+}
+
+// MaxReadSize caps how many bytes are read from each cgroup or procfs file,
+// see detect.Detector.MaxReadSize. The cap defaults to 4MiB; sizes below 1
+// are rejected like an invalid Min.
+func MaxReadSize(n int64) Option {
+	return optionFunc(func(cfg *config) {
+		if n < 1 {
+			cfg.err = fmt.Errorf("maxprocs: invalid maximum read size %d, must be at least 1", n)
+			return
+		}
+		cfg.detector.MaxReadSize = n
+	})
+}
+
+// CGroupPID reads the cgroups of the process with the given PID, from
+// `/proc/<pid>/cgroup` and `/proc/<pid>/mountinfo`, instead of those of the
+// calling process. This lets a helper process, such as one exec'd by a
+// container's main process, size GOMAXPROCS after its parent, e.g.
+// CGroupPID(os.Getppid()). Set fails if the process' procfs entry can't be
+// read. A pid of 0 keeps reading the calling process' cgroups.
+func CGroupPID(pid int) Option {
+	return optionFunc(func(cfg *config) {
+		if pid < 0 {
+			cfg.err = fmt.Errorf("maxprocs: invalid cgroup PID %d", pid)
+			return
+		}
+		cfg.detector.PID = pid
+	})
+}
+
+// CPUCGroupPath reads the CPU quota from the cgroups v1 CPU controller
+// directory at path, e.g. /sys/fs/cgroup/cpu,cpuacct/docker/0123456789abcdef,
+// instead of finding it through procfs. This is an escape hatch for nested
+// containers whose CPU controller is mounted where it can't be found, when
+// the orchestration layer knows the right path. Set fails if path doesn't
+// hold `cpu.cfs_quota_us` and `cpu.cfs_period_us`.
+func CPUCGroupPath(path string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.detector.CPUCGroupPath = path
+	})
+}
+
+// SharesFallback estimates GOMAXPROCS from the CPU shares (cgroups v1) or CPU
+// weight (cgroups v2) of the process when no CPU quota is configured, with
+// 1024 shares counting as one CPU. Container runtimes derive shares from CPU
+// requests, so this sizes GOMAXPROCS to the requested CPUs of, for example, a
+// Kubernetes pod without CPU limits.
+//
+// By default, Set leaves GOMAXPROCS alone when there is no CPU quota, even
+// if shares are configured.
+func SharesFallback() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.detector.SharesFallback = true
+	})
+}
+
+// PreferRequests sizes GOMAXPROCS to the CPU shares (cgroups v1) or CPU
+// weight (cgroups v2) of the process, with 1024 shares counting as one CPU,
+// even when a CPU quota is configured. In Kubernetes, the quota follows the
+// CPU limit while shares follow the CPU request, so this sizes GOMAXPROCS to
+// the requested CPUs to avoid throttling when bursting above them. The quota
+// still caps GOMAXPROCS.
+//
+// By default, Set sizes GOMAXPROCS to the CPU quota when there is one.
+func PreferRequests() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.detector.PreferShares = true
+	})
+}
+
+// MaxProcsPerMemGB caps GOMAXPROCS at n per GiB of the memory limit, for
+// memory-bound services where too many concurrently allocating goroutines
+// could exhaust a small limit. The cap doesn't apply if there is no memory
+// limit, and never lowers GOMAXPROCS below the minimum. Values that aren't
+// positive and finite are rejected like an invalid Min.
+func MaxProcsPerMemGB(n float64) Option {
+	return optionFunc(func(cfg *config) {
+		if !(n > 0) || math.IsInf(n, 1) {
+			cfg.err = fmt.Errorf("maxprocs: invalid GOMAXPROCS per GiB of memory %v, must be positive and finite", n)
+			return
 		}
+		cfg.procsPerMemGB = n
+	})
+}
+
+// MemoryLimitReserve makes SetMemoryLimit keep percent of the memory limit
+// in reserve for memory the Go runtime doesn't manage, such as cgo
+// allocations; e.g. a reserve of 10 sets GOMEMLIMIT to 90% of the memory
+// limit. The reserve defaults to 10%; a reserve of 0 disables it. It never
+// brings GOMEMLIMIT below 16MiB, or below the memory limit if that's lower.
+// Percentages outside of [0, 100) are rejected like an invalid Min.
+func MemoryLimitReserve(percent float64) Option {
+	return optionFunc(func(cfg *config) {
+		if !(percent >= 0 && percent < 100) {
+			cfg.err = fmt.Errorf("maxprocs: invalid memory limit reserve %v%%, must be at least 0 and below 100", percent)
+			return
+		}
+		cfg.memReserve = percent
+	})
+}
+
+// BurstBlend sets GOMAXPROCS between the CPU quota and the number of CPUs
+// of the host, for workloads that benefit from bursting above their quota.
+// GOMAXPROCS is set to the rounded value of
+//
+//	quota + factor*(NumCPU-quota)
+//
+// capped at NumCPU, so a factor of 0 uses the quota as is and a factor of 1
+// uses all CPUs. Factors outside of [0, 1] are rejected like an invalid
+// Min.
+func BurstBlend(factor float64) Option {
+	return optionFunc(func(cfg *config) {
+		if !(factor >= 0 && factor <= 1) {
+			cfg.err = fmt.Errorf("maxprocs: invalid burst blend factor %v, must be between 0 and 1", factor)
+			return
+		}
+		cfg.burstBlend = factor
+	})
+}
+
+// CPUReservePercent leaves percent of the CPU quota as headroom for the
+// garbage collector and other threads of the Go runtime, which helps avoid
+// CFS throttling in latency-sensitive services; e.g. a reserve of 10 derives
+// GOMAXPROCS from 90% of the quota. The reserve applies before rounding and
+// before the minimum set by Min, so the minimum is used if the reserve drops
+// GOMAXPROCS below it. Percentages outside of [0, 99] are rejected like an
+// invalid Min.
+func CPUReservePercent(percent float64) Option {
+	return optionFunc(func(cfg *config) {
+		if percent < 0 || percent > 99 {
+			cfg.err = fmt.Errorf("maxprocs: invalid CPU reserve %v%%, must be between 0 and 99", percent)
+			return
+		}
+		cfg.cpuReserve = percent
+	})
+}
+
+// A Decision describes the outcome of a call to Set, as reported to the
+// DecisionHook.
+type Decision struct {
+	// Source is where GOMAXPROCS was taken from.
+	Source Source
+	// Quota is the CPU quota in cores, or -1 if it wasn't read or there is
+	// none.
+	Quota float64
+	// Status describes how GOMAXPROCS was derived from the CPU quota. It's
+	// detect.Undefined unless Source is SourceCGroup.
+	Status detect.Status
+	// GOMAXPROCS is the value of GOMAXPROCS after Set.
+	GOMAXPROCS int
+	// Previous is the value of GOMAXPROCS before Set.
+	Previous int
+	// DryRun is true if Set left GOMAXPROCS alone because of DryRun, even
+	// though it would have changed it to WouldSet.
+	DryRun bool
+	// WouldSet is the GOMAXPROCS value Set would have applied without
+	// DryRun. It's 0 unless DryRun is true.
+	WouldSet int
+	// BelowOneCPU is true if the CPU quota is less than one CPU, e.g. a
+	// Kubernetes limit of 500m. GOMAXPROCS can't go below 1, so the process
+	// overcommits its quota, which often points to a misconfigured limit.
+	BelowOneCPU bool
+	// Err is the error returned by Set, if any.
+	Err error
+}
+
+// DecisionHook calls f with the details of the decision made by Set, e.g. to
+// export them as metrics. f is called exactly once per call to Set, even if
+// GOMAXPROCS is left alone or Set fails.
+func DecisionHook(f func(Decision)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.decisionHook = f
+	})
+}
+
+// LogAllocation makes Set report the detected CPU quota along with the number
+// of CPUs of the host and the fraction of them allocated to the process,
+// e.g. "quota 2 of 64 host cores (3%)".
+func LogAllocation() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.logAllocation = true
+	})
+}
+
+// GaugeFunc reports metrics about the decision made by Set to the supplied
+// function, which makes it easy to integrate with any metrics library. After
+// each call to Set, SetMemoryLimit or SetAll, the function is called once per
+// gauge:
+//
+//	automaxprocs_quota_cores      the detected CPU quota, if any
+//	automaxprocs_gomaxprocs       the resulting GOMAXPROCS value
+//	automaxprocs_mem_limit_bytes  the memory limit SetMemoryLimit applied, if any
+func GaugeFunc(f func(name string, value float64)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.gauge = f
 	})
 }
 
@@ -79,61 +1012,788 @@ func RoundQuotaFunc(rf func(v float64) int) Option {
 	})
 }
 
+// RoundUpAnyFraction rounds any fractional CPU quota up to the next whole
+// number: a quota of 1.01 results in a GOMAXPROCS of 2, while a quota of
+// exactly 3 stays at 3. It's shorthand for RoundQuotaFunc with math.Ceil.
+func RoundUpAnyFraction() Option {
+	return RoundQuotaFunc(func(v float64) int {
+		return int(math.Ceil(v))
+	})
+}
+
 type optionFunc func(*config)
 
 func (of optionFunc) apply(cfg *config) { of(cfg) }
 
 // Set GOMAXPROCS to match the Linux container CPU quota (if any), returning
-// any error encountered and an undo function.
+// any error encountered and an undo function. On Windows, the hard cap on the
+// CPU rate of the process' job object, as set for Windows containers, counts
+// as CPU quota. On macOS, which has no CPU quotas, developers may opt into
+// simulating one with the AUTOMAXPROCS_CPU environment variable, e.g.
+// AUTOMAXPROCS_CPU=1.5.
 //
-// Set is a no-op on non-Linux systems and in Linux environments without a
-// configured CPU quota.
+// Set is a no-op on other systems and in environments without a configured
+// CPU quota.
+//
+// The undo function restores the previous GOMAXPROCS only if GOMAXPROCS
+// still holds the value Set chose, so it doesn't clobber a later override,
+// and does nothing once it has been called.
 func Set(opts ...Option) (func(), error) {
-	cfg := &config{
-		procs:          iruntime.CPUQuotaToGOMAXPROCS,
-		roundQuotaFunc: iruntime.DefaultRoundFunc,
-		minGOMAXPROCS:  1,
+	return SetContext(context.Background(), opts...)
+}
+
+// SetContext is like Set, but gives up on reading procfs and cgroups once ctx
+// is done, e.g. on a misbehaving overlay filesystem where reads hang. It then
+// returns the context's error and leaves GOMAXPROCS unchanged. Reads that
+// can't be interrupted are abandoned in the background, without logging or
+// changing GOMAXPROCS once they complete.
+func SetContext(ctx context.Context, opts ...Option) (func(), error) {
+	undo, _, err := set(ctx, newConfig(opts))
+	return undo, err
+}
+
+// A Source identifies where SetFromEnvOrCGroup took GOMAXPROCS from.
+type Source int
+
+const (
+	// SourceNumCPU means that neither the GOMAXPROCS environment variable
+	// nor a CPU quota applies, so GOMAXPROCS was left at the Go runtime's
+	// default, the number of CPUs, or set to the number of online CPUs with
+	// UseOnlineCPUs.
+	SourceNumCPU Source = iota
+	// SourceEnv means that GOMAXPROCS was taken from the GOMAXPROCS
+	// environment variable, the variable named by EnvOverride, or the
+	// allocation of a scheduler with SchedulerEnvFallback.
+	SourceEnv
+	// SourceCGroup means that GOMAXPROCS was derived from the CPU quota or
+	// the cpuset, or from CPU shares with SharesFallback.
+	SourceCGroup
+)
+
+// String returns a short name for the source, such as "env".
+func (s Source) String() string {
+	switch s {
+	case SourceNumCPU:
+		return "numcpu"
+	case SourceEnv:
+		return "env"
+	case SourceCGroup:
+		return "cgroup"
+	default:
+		return fmt.Sprintf("Source(%d)", int(s))
 	}
-	for _, o := range opts {
-		o.apply(cfg)
+}
+
+// SetFromEnvOrCGroup sets GOMAXPROCS like Set and also reports where the
+// value came from. The precedence is:
+//
+//  1. the GOMAXPROCS environment variable, if set;
+//  2. the CPU quota of the process' cgroup, if any;
+//  3. the number of CPUs, which the Go runtime uses by default.
+func SetFromEnvOrCGroup(opts ...Option) (func(), Source, error) {
+	return set(context.Background(), newConfig(opts))
+}
+
+// A Result describes what SetResult did to GOMAXPROCS.
+type Result struct {
+	// GOMAXPROCS is the value of GOMAXPROCS after SetResult.
+	GOMAXPROCS int
+	// Previous is the value of GOMAXPROCS before SetResult.
+	Previous int
+	// DryRun is true if SetResult left GOMAXPROCS alone because of DryRun,
+	// even though it would have changed it to WouldSet.
+	DryRun bool
+	// WouldSet is the GOMAXPROCS value SetResult would have applied
+	// without DryRun. It's 0 unless DryRun is true.
+	WouldSet int
+	// Status describes how GOMAXPROCS was derived from the CPU quota. It's
+	// detect.Undefined unless Source is SourceCGroup.
+	Status detect.Status
+	// Source is where GOMAXPROCS was taken from.
+	Source Source
+	// Quota is the CPU quota in cores, or -1 if it wasn't read or there is
+	// none.
+	Quota float64
+
+	undo func()
+}
+
+// Undo resets GOMAXPROCS to its value before SetResult, like the function
+// returned by Set. It's safe to call on the zero Result.
+func (r Result) Undo() {
+	if r.undo != nil {
+		r.undo()
 	}
+}
+
+// SetResult is like Set, but reports the GOMAXPROCS value it chose along
+// with how it got there, sparing callers from reading GOMAXPROCS back and
+// guessing why it has that value. The Result is filled in even if SetResult
+// fails, with GOMAXPROCS left alone.
+func SetResult(opts ...Option) (Result, error) {
+	cfg := newConfig(opts)
+	prev := currentMaxProcs()
+	undo, source, err := set(context.Background(), cfg)
+	d := cfg.decision(prev, source, err)
+	return Result{
+		GOMAXPROCS: d.GOMAXPROCS,
+		Previous:   d.Previous,
+		DryRun:     d.DryRun,
+		WouldSet:   d.WouldSet,
+		Status:     d.Status,
+		Source:     d.Source,
+		Quota:      d.Quota,
+		undo:       undo,
+	}, err
+}
 
+func set(ctx context.Context, cfg *config) (func(), Source, error) {
+	prev := currentMaxProcs()
+	undo, source, err := setProcs(ctx, cfg)
+	cfg.reportDecision(prev, source, err)
+	return undo, source, err
+}
+
+func setProcs(ctx context.Context, cfg *config) (func(), Source, error) {
 	undoNoop := func() {
 		cfg.log("maxprocs: No GOMAXPROCS change to reset")
 	}
 
+	if cfg.err != nil {
+		return undoNoop, SourceNumCPU, cfg.err
+	}
+
+	if procFS := cfg.detector.ProcFS; procFS != "" && !cfg.uncontained {
+		err := cfg.runContext(ctx, func(*config) error {
+			if _, err := iruntime.Stat(cfg.detector.FS, procFS); err != nil {
+				return fmt.Errorf("maxprocs: invalid procfs path: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return undoNoop, SourceNumCPU, err
+		}
+	}
+
+	if pid := cfg.detector.PID; pid != 0 && !cfg.uncontained && cfg.detector.CPUCGroupPath == "" {
+		err := cfg.runContext(ctx, func(*config) error {
+			procFS := cfg.detector.ProcFS
+			if procFS == "" {
+				procFS = "/proc"
+			}
+			if _, err := iruntime.Stat(cfg.detector.FS, filepath.Join(procFS, strconv.Itoa(pid))); err != nil {
+				return fmt.Errorf("maxprocs: can't read cgroups of PID %d: %w", pid, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return undoNoop, SourceNumCPU, err
+		}
+	}
+
+	defer cfg.reportGauges()
+
 	// Honor the GOMAXPROCS environment variable if present. Otherwise, amend
 	// `runtime.GOMAXPROCS()` with the current process' CPU quota if the OS is
 	// Linux, and guarantee a minimum value of 1. The minimum guaranteed value
 	// can be overridden using `maxprocs.Min()`.
-	if max, exists := os.LookupEnv(_maxProcsKey); exists {
-		cfg.log("maxprocs: Honoring GOMAXPROCS=%q as set in environment", max)
-		return undoNoop, nil
+	if max, exists := cfg.envMaxProcs(); exists {
+		cfg.logKV(levelInfo, cfg.decisionFields(currentMaxProcs(), SourceEnv), "maxprocs: Honoring GOMAXPROCS=%q as set in environment", max)
+		return undoNoop, SourceEnv, nil
 	}
 
-	maxProcs, status, err := cfg.procs(cfg.minGOMAXPROCS, cfg.roundQuotaFunc)
-	if err != nil {
-		return undoNoop, err
+	var (
+		maxProcs int
+		status   detect.Status
+		source   = SourceCGroup
+		envName  string
+		envValue string
+	)
+	if procs, value, ok := cfg.envOverrideProcs(); ok {
+		maxProcs, source, envName, envValue = procs, SourceEnv, cfg.envOverride, value
 	}
 
-	if status == iruntime.CPUQuotaUndefined {
-		cfg.log("maxprocs: Leaving GOMAXPROCS=%v: CPU quota undefined", currentMaxProcs())
-		return undoNoop, nil
+	if cfg.uncontained && source != SourceEnv {
+		cfg.logKV(levelInfo, cfg.decisionFields(currentMaxProcs(), SourceNumCPU), "maxprocs: Leaving GOMAXPROCS=%v: assuming no container limits", currentMaxProcs())
+		return undoNoop, SourceNumCPU, nil
+	}
+
+	if source != SourceEnv {
+		err := cfg.runContext(ctx, func(c *config) error {
+			if c.isGVisor() {
+				c.warn("maxprocs: Running under gVisor, CPU quota detection may be limited")
+			}
+
+			var err error
+			maxProcs, status, err = c.resolve()
+			return err
+		})
+		if err != nil {
+			return undoNoop, SourceNumCPU, err
+		}
+		cfg.status = status
+		if cfg.quota >= 0 {
+			cfg.logKV(levelDebug, []interface{}{"quota", cfg.quota}, "maxprocs: Detected CPU quota of %v cores", cfg.quota)
+		}
+	}
+
+	if status == detect.Undefined && source != SourceEnv {
+		procs, src, name, value, ok := cfg.undefinedQuotaProcs()
+		if !ok {
+			cfg.logKV(levelInfo, cfg.decisionFields(currentMaxProcs(), SourceNumCPU), "maxprocs: Leaving GOMAXPROCS=%v: CPU quota undefined", currentMaxProcs())
+			return undoNoop, SourceNumCPU, nil
+		}
+		maxProcs, source, envName, envValue = procs, src, name, value
 	}
 
 	prev := currentMaxProcs()
+	var once sync.Once
 	undo := func() {
-		cfg.log("maxprocs: Resetting GOMAXPROCS to %v", prev)
-		runtime.GOMAXPROCS(prev)
+		once.Do(func() {
+			if current := currentMaxProcs(); current != maxProcs {
+				cfg.log("maxprocs: Leaving GOMAXPROCS=%v: changed since it was set to %v", current, maxProcs)
+				return
+			}
+			cfg.log("maxprocs: Resetting GOMAXPROCS to %v", prev)
+			runtime.GOMAXPROCS(prev)
+		})
 	}
 
-	switch status {
-	case iruntime.CPUQuotaMinUsed:
-		cfg.log("maxprocs: Updating GOMAXPROCS=%v: using minimum allowed GOMAXPROCS", maxProcs)
-	case iruntime.CPUQuotaUsed:
-		cfg.log("maxprocs: Updating GOMAXPROCS=%v: determined from CPU quota", maxProcs)
+	fields := cfg.decisionFields(maxProcs, source)
+	action := "Updating"
+	if cfg.dryRun {
+		action = "Dry run, would set"
+	}
+	switch {
+	case source == SourceEnv && envName == cfg.envOverride:
+		cfg.logKV(levelInfo, fields, "maxprocs: %s GOMAXPROCS=%v: honoring %s=%q as set in environment", action, maxProcs, envName, envValue)
+	case source == SourceEnv:
+		cfg.logKV(levelInfo, fields, "maxprocs: %s GOMAXPROCS=%v: CPU quota undefined, using scheduler allocation %s=%q", action, maxProcs, envName, envValue)
+	case status == detect.Undefined:
+		cfg.logKV(levelInfo, fields, "maxprocs: %s GOMAXPROCS=%v: CPU quota undefined, using online CPUs", action, maxProcs)
+	case status == detect.MinUsed:
+		cfg.logKV(levelInfo, fields, "maxprocs: %s GOMAXPROCS=%v: using minimum allowed GOMAXPROCS%s", action, maxProcs, cfg.allocation())
+	case status == detect.Quota:
+		cfg.logKV(levelInfo, fields, "maxprocs: %s GOMAXPROCS=%v: determined from CPU quota%s", action, maxProcs, cfg.allocation())
+	case status == detect.Shares:
+		cfg.warnKV(fields, "maxprocs: %s GOMAXPROCS=%v: estimated from CPU shares%s", action, maxProcs, cfg.allocation())
+	case status == detect.CPUSet:
+		cfg.logKV(levelInfo, fields, "maxprocs: %s GOMAXPROCS=%v: limited by cpuset%s", action, maxProcs, cfg.allocation())
+	}
+	if source == SourceCGroup && cfg.belowOneCPU() {
+		cfg.warnKV(fields, "maxprocs: CPU quota of %v cores is below one CPU, GOMAXPROCS=%v overcommits it; check the CPU limit", cfg.quota, maxProcs)
+	}
+
+	if cfg.dryRun {
+		cfg.wouldSet = maxProcs
+		return undoNoop, source, nil
 	}
 
 	runtime.GOMAXPROCS(maxProcs)
-	return undo, nil
+	return undo, source, nil
+}
+
+// MemoryLimitStatus describes how SetMemoryLimit resolved the memory limit.
+type MemoryLimitStatus = iruntime.TotalMemoryStatus
+
+const (
+	// TotalMemoryUndefined means that no memory limit applies to the process,
+	// or that GOMEMLIMIT is set in the environment, so SetMemoryLimit left
+	// the memory limit of the Go runtime untouched.
+	TotalMemoryUndefined = iruntime.TotalMemoryUndefined
+	// TotalMemoryUsed means that SetMemoryLimit set the memory limit of the Go
+	// runtime from the memory limit of the process.
+	TotalMemoryUsed = iruntime.TotalMemoryUsed
+)
+
+// SetMemoryLimit sets the soft memory limit of the Go runtime (see
+// debug.SetMemoryLimit) to match the Linux container memory limit, read from
+// memory.max on cgroups v2 or memory.limit_in_bytes on cgroups v1, less the
+// MemoryLimitReserve, 10% by default. Limits above the physical memory of the
+// host are clamped to it. Like Set, it honors the GOMEMLIMIT environment
+// variable and returns a function to reset the memory limit to its previous
+// value.
+//
+// SetMemoryLimit honors the Logger, ProcFS, AssumeUncontained,
+// MemoryLimitReserve and GaugeFunc options.
+func SetMemoryLimit(opts ...Option) (func(), MemoryLimitStatus, error) {
+	return setMemoryLimit(newConfig(opts))
+}
+
+func setMemoryLimit(cfg *config) (func(), MemoryLimitStatus, error) {
+	undoNoop := func() {
+		cfg.log("maxprocs: No GOMEMLIMIT change to reset")
+	}
+
+	if cfg.err != nil {
+		return undoNoop, TotalMemoryUndefined, cfg.err
+	}
+
+	defer cfg.reportGauges()
+
+	if limit, exists := os.LookupEnv(_memLimitKey); exists {
+		cfg.log("maxprocs: Honoring GOMEMLIMIT=%q as set in environment", limit)
+		return undoNoop, TotalMemoryUndefined, nil
+	}
+
+	if cfg.uncontained {
+		cfg.log("maxprocs: Leaving GOMEMLIMIT unchanged: assuming no container limits")
+		return undoNoop, TotalMemoryUndefined, nil
+	}
+
+	limit, ok, err := cfg.memLimit()
+	if err != nil {
+		return undoNoop, TotalMemoryUndefined, err
+	}
+	if !ok {
+		cfg.log("maxprocs: Leaving GOMEMLIMIT unchanged: memory limit undefined")
+		return undoNoop, TotalMemoryUndefined, nil
+	}
+
+	if physMem, ok := cfg.physMem(); ok && limit > physMem {
+		cfg.log("maxprocs: Clamping memory limit of %v bytes to physical memory of %v bytes", limit, physMem)
+		limit = physMem
+	}
+	limit = cfg.reserveMem(limit)
+	if limit > math.MaxInt64 {
+		limit = math.MaxInt64
+	}
+
+	cfg.log("maxprocs: Updating GOMEMLIMIT=%v: determined from memory limit", limit)
+	prev := debug.SetMemoryLimit(int64(limit))
+	cfg.goMemLimit = int64(limit)
+	undo := func() {
+		cfg.log("maxprocs: Resetting GOMEMLIMIT to %v", prev)
+		debug.SetMemoryLimit(prev)
+	}
+	return undo, TotalMemoryUsed, nil
+}
+
+// Limits describes what SetAll applied.
+type Limits struct {
+	// Source is where GOMAXPROCS was taken from, like SetFromEnvOrCGroup
+	// reports it.
+	Source Source
+
+	// CPU is how GOMAXPROCS was derived from the CPU quota, or
+	// detect.Undefined unless Source is SourceCGroup.
+	CPU detect.Status
+
+	// Memory is TotalMemoryUsed if GOMEMLIMIT was set from the memory limit.
+	Memory MemoryLimitStatus
+}
+
+// SetAll sets both GOMAXPROCS and the soft memory limit of the Go runtime to
+// match the Linux container limits, like Set followed by SetMemoryLimit, and
+// reports the outcome of each. A limit that's undefined is left alone
+// without affecting the other. If either fails, neither is changed. The
+// returned function resets the memory limit and then GOMAXPROCS.
+func SetAll(opts ...Option) (func(), Limits, error) {
+	cfg := newConfig(opts)
+	undoNoop := func() {
+		cfg.log("maxprocs: No GOMAXPROCS or GOMEMLIMIT change to reset")
+	}
+
+	// Report the gauges once, after both limits are applied.
+	cfg.gaugesHeld = true
+	defer func() {
+		cfg.gaugesHeld = false
+		cfg.reportGauges()
+	}()
+
+	undoProcs, source, err := set(context.Background(), cfg)
+	if err != nil {
+		return undoNoop, Limits{}, err
+	}
+	limits := Limits{Source: source}
+	if source == SourceCGroup {
+		limits.CPU = cfg.status
+	}
+
+	undoMem, memStatus, err := setMemoryLimit(cfg)
+	if err != nil {
+		undoProcs()
+		return undoNoop, Limits{}, err
+	}
+	limits.Memory = memStatus
+
+	undo := func() {
+		undoMem()
+		undoProcs()
+	}
+	return undo, limits, nil
+}
+
+// Detect returns the GOMAXPROCS value Set would apply for the CPU quota,
+// without changing GOMAXPROCS, e.g. to size worker pools. It honors the same
+// options as Set but ignores the GOMAXPROCS environment variable. If there is
+// no CPU quota, it returns -1 and detect.Undefined.
+func Detect(opts ...Option) (int, detect.Status, error) {
+	cfg := newConfig(opts)
+	if cfg.err != nil {
+		return -1, detect.Undefined, cfg.err
+	}
+	return cfg.resolve()
+}
+
+// IsAligned reports whether GOMAXPROCS still has the value Set would apply,
+// e.g. for readiness checks or to alert when another library changes
+// GOMAXPROCS after Set. It honors the same options as Set, without logging.
+// GOMAXPROCS is aligned whenever Set would leave it alone: if the GOMAXPROCS
+// environment variable is set, with AssumeUncontained, or if there is no CPU
+// quota and neither SchedulerEnvFallback nor UseOnlineCPUs supplies a value.
+func IsAligned(opts ...Option) (bool, error) {
+	cfg := newConfig(opts)
+	if cfg.err != nil {
+		return false, cfg.err
+	}
+	cfg.printf, cfg.kvLogger, cfg.slog, cfg.warning = nil, nil, nil, nil
+
+	if _, exists := cfg.envMaxProcs(); exists {
+		return true, nil
+	}
+	if procs, _, ok := cfg.envOverrideProcs(); ok {
+		return procs == currentMaxProcs(), nil
+	}
+	if cfg.uncontained {
+		return true, nil
+	}
+
+	maxProcs, status, err := cfg.resolve()
+	if err != nil {
+		return false, err
+	}
+	if status == detect.Undefined {
+		procs, _, _, _, ok := cfg.undefinedQuotaProcs()
+		return !ok || procs == currentMaxProcs(), nil
+	}
+	return maxProcs == currentMaxProcs(), nil
+}
+
+// AsyncResult is the outcome of a SetAsync call.
+type AsyncResult struct {
+	// Undo resets GOMAXPROCS to its value before SetAsync changed it.
+	Undo func()
+	// Err is the error, if any, returned by Set.
+	Err error
+}
+
+// SetAsync runs Set in a new goroutine and returns immediately, so that
+// startup doesn't block on reading the CPU quota. The returned channel
+// delivers the result once Set has finished; callers may wait on it or
+// ignore it.
+//
+// Since GOMAXPROCS is updated in the background, it may change shortly after
+// SetAsync returns.
+func SetAsync(opts ...Option) <-chan AsyncResult {
+	result := make(chan AsyncResult, 1)
+	go func() {
+		undo, err := Set(opts...)
+		result <- AsyncResult{Undo: undo, Err: err}
+	}()
+	return result
+}
+
+// SetUntil is like Set, but instead of returning the function resetting
+// GOMAXPROCS, it resets GOMAXPROCS in the background once ctx is done. This
+// ties the change to the lifetime of the application's context.
+func SetUntil(ctx context.Context, opts ...Option) error {
+	undo, err := Set(opts...)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		undo()
+	}()
+	return nil
+}
+
+// Watch re-reads the CPU quota every interval and updates GOMAXPROCS
+// whenever the value Set would derive from it changes, e.g. after a vertical
+// pod autoscaler resized the container. To avoid thrashing while a quota is
+// being changed, a new value only applies once it has been read twice in a
+// row, i.e. observed for a full interval. If a CPU quota Watch has read is
+// removed, GOMAXPROCS goes back to the value Set uses without a quota: the
+// SchedulerEnvFallback or UseOnlineCPUs value if requested, or else the
+// number of CPUs. While no quota has been read, GOMAXPROCS is left alone.
+//
+// Watch honors the same options as Set and blocks until ctx is done, so it's
+// meant to run in its own goroutine after Set. Like Set, it doesn't change
+// GOMAXPROCS if the GOMAXPROCS environment variable is set or with
+// AssumeUncontained, in which case it returns right away.
+func Watch(ctx context.Context, interval time.Duration, opts ...Option) error {
+	if interval <= 0 {
+		return fmt.Errorf("maxprocs: invalid watch interval %v", interval)
+	}
+
+	cfg := newConfig(opts)
+	if cfg.err != nil {
+		return cfg.err
+	}
+	if max, exists := cfg.envMaxProcs(); exists {
+		cfg.log("maxprocs: Honoring GOMAXPROCS=%q as set in environment", max)
+		return nil
+	}
+	if procs, value, ok := cfg.envOverrideProcs(); ok {
+		cfg.log("maxprocs: Honoring %s=%q as set in environment, GOMAXPROCS=%v", cfg.envOverride, value, procs)
+		return nil
+	}
+	if cfg.uncontained {
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	watch(ctx, cfg, ticker.C)
+	return nil
+}
+
+// watch updates GOMAXPROCS on each tick until ctx is done, see Watch.
+func watch(ctx context.Context, cfg *config, ticks <-chan time.Time) {
+	// Only transitions are worth logging, not the capping repeated on
+	// every tick.
+	quiet := *cfg
+	quiet.printf = nil
+	quiet.kvLogger = nil
+	quiet.slog = nil
+	quiet.warning = nil
+	// Mounts rarely change, so locate the cgroups once rather than on every
+	// tick.
+	quiet.detector.Cache = new(detect.Cache)
+
+	pending, dryRunProcs := 0, 0
+	quotaRead := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+		}
+
+		maxProcs, status, err := quiet.resolve()
+		if err != nil {
+			cfg.warn("maxprocs: Failed to re-read CPU quota: %v", err)
+			continue
+		}
+
+		if status == detect.Undefined {
+			if !quotaRead {
+				pending = 0
+				continue
+			}
+			// The limit was removed; go back to the value Set uses without
+			// one rather than staying at the old quota.
+			procs, _, _, _, ok := quiet.undefinedQuotaProcs()
+			if !ok {
+				procs = quiet.capMaxProcs(quiet.numCPU())
+			}
+			maxProcs = procs
+			quiet.quota = -1
+		} else {
+			quotaRead = true
+		}
+
+		if maxProcs == currentMaxProcs() {
+			pending = 0
+			continue
+		}
+		if maxProcs != pending {
+			pending = maxProcs
+			continue
+		}
+
+		if cfg.dryRun {
+			if maxProcs != dryRunProcs {
+				if status == detect.Undefined {
+					cfg.log("maxprocs: Dry run, leaving GOMAXPROCS=%v: CPU quota removed, would set %v", currentMaxProcs(), maxProcs)
+				} else {
+					cfg.log("maxprocs: Dry run, leaving GOMAXPROCS=%v: CPU quota changed to %v", currentMaxProcs(), maxProcs)
+				}
+				dryRunProcs = maxProcs
+			}
+			continue
+		}
+
+		if status == detect.Undefined {
+			cfg.log("maxprocs: Updating GOMAXPROCS=%v: CPU quota removed, was %v", maxProcs, currentMaxProcs())
+		} else {
+			cfg.log("maxprocs: Updating GOMAXPROCS=%v: CPU quota changed, was %v%s", maxProcs, currentMaxProcs(), quiet.allocation())
+		}
+		runtime.GOMAXPROCS(maxProcs)
+		quiet.reportGauges()
+		pending = 0
+	}
+}
+
+// ValidateCGroupPath checks that path is a cgroup directory, such as
+// /sys/fs/cgroup/cpu,cpuacct/docker/0123456789abcdef, holding a readable CPU
+// quota in the layout of either cgroups v1 or v2. It returns a descriptive
+// error otherwise, which lets tools check a cgroup path before relying on it.
+// Cgroups are only supported on Linux; elsewhere, ValidateCGroupPath always
+// fails.
+func ValidateCGroupPath(path string) error {
+	if err := iruntime.ValidateCPUQuotaDir(path); err != nil {
+		return fmt.Errorf("maxprocs: invalid cgroup path: %w", err)
+	}
+	return nil
+}
+
+// TargetProcs recommends a GOMAXPROCS value for reaching the target CPU
+// utilization, given the current one, e.g. for autoscaling integrations. It
+// scales the current GOMAXPROCS by currentUtil/targetUtil, rounds the result
+// with the rounding options and clamps it to the CPU quota, if any, and the
+// Min and Max options. It doesn't change GOMAXPROCS.
+//
+// If targetUtil isn't positive, TargetProcs returns the current GOMAXPROCS.
+// Since TargetProcs returns no error, it ignores invalid options, such as
+// Min(0), and keeps their defaults instead.
+func TargetProcs(currentUtil, targetUtil float64, opts ...Option) int {
+	cfg := newConfig(opts)
+	procs := currentMaxProcs()
+	if targetUtil <= 0 {
+		return procs
+	}
+
+	target := cfg.roundQuotaFunc(float64(procs) * currentUtil / targetUtil)
+	// TargetProcs may run on every scaling decision; keep the detection
+	// notes Set logs out of it.
+	cfg.printf, cfg.kvLogger, cfg.slog = nil, nil, nil
+	if quotaProcs, status, err := cfg.detectProcs(cfg.minGOMAXPROCS, cfg.roundQuotaFunc); err == nil && status != detect.Undefined && target > quotaProcs {
+		target = quotaProcs
+	}
+	if target < cfg.minGOMAXPROCS {
+		target = cfg.minGOMAXPROCS
+	}
+	return cfg.capMaxProcs(target)
+}
+
+// QuotaInfo describes the CPU quota applied to the calling process, as
+// returned by Query.
+type QuotaInfo struct {
+	// CGroupVersion is the version of cgroups the quota was read from: 1 for
+	// cpu.cfs_quota_us and cpu.cfs_period_us, 2 for cpu.max, or 0 if the
+	// process isn't in a cgroup.
+	CGroupVersion int
+
+	// Quota is the CPU time the cgroup may use per Period. Quota, Period,
+	// CPUs and GOMAXPROCS are zero if Status is detect.Undefined.
+	Quota  time.Duration
+	Period time.Duration
+
+	// CPUs is the quota in cores, Quota/Period.
+	CPUs float64
+
+	// GOMAXPROCS is the value Set would derive from CPUs.
+	GOMAXPROCS int
+
+	// Status is detect.Quota or detect.MinUsed depending on whether
+	// GOMAXPROCS is the rounded quota or the minimum, or detect.Undefined
+	// if there is no quota.
+	Status detect.Status
+}
+
+// Query reads the CPU quota applied to the calling process and the
+// GOMAXPROCS value Set would derive from it, for diagnostics such as
+// "detected 2.5 CPUs, rounded down to 2". It honors the ProcFS, Min and
+// rounding options, but neither falls back to CPU shares nor changes
+// GOMAXPROCS.
+func Query(opts ...Option) (QuotaInfo, error) {
+	cfg := newConfig(opts)
+	if cfg.err != nil {
+		return QuotaInfo{}, cfg.err
+	}
+	quota, period, version, err := cfg.quotaPeriod()
+	if err != nil {
+		return QuotaInfo{}, err
+	}
+
+	info := QuotaInfo{CGroupVersion: version}
+	if quota < 0 || period <= 0 {
+		return info, nil
+	}
+	info.Quota = time.Duration(quota) * time.Microsecond
+	info.Period = time.Duration(period) * time.Microsecond
+	info.CPUs = float64(quota) / float64(period)
+	info.GOMAXPROCS, info.Status = detect.QuotaToGOMAXPROCS(info.CPUs, cfg.minGOMAXPROCS, cfg.roundQuotaFunc)
+	return info, nil
+}
+
+// CPUQuota returns the CPU quota applied to the calling process in cores,
+// without rounding, e.g. 3.5 for a quota of three and a half CPUs, for
+// schedulers that handle fractional CPUs. The status is detect.Quota, or
+// detect.Shares with SharesFallback, or detect.CPUSet if the cpuset allows
+// fewer CPUs, or detect.Undefined with a quota of 0 if there is none. It
+// honors the ProcFS, CPUCGroupPath and SharesFallback options and doesn't
+// change GOMAXPROCS.
+func CPUQuota(opts ...Option) (float64, detect.Status, error) {
+	cfg := newConfig(opts)
+	if cfg.err != nil {
+		return 0, detect.Undefined, cfg.err
+	}
+
+	quota, status, err := cfg.cpuQuota()
+	if status == detect.Undefined || err != nil {
+		return 0, detect.Undefined, err
+	}
+	return quota, status, nil
+}
+
+// CGroupPath returns the cgroup the calling process belongs to, as listed in
+// /proc/self/cgroup, e.g. "/kubepods/burstable/pod1234/0123456789abcdef". On
+// cgroups v1, it's the cgroup of the CPU controller; on cgroups v2, that of
+// the unified hierarchy. It returns "" if the process isn't in a cgroup, e.g.
+// on non-Linux systems.
+func CGroupPath() (string, error) {
+	return iruntime.CGroupPath()
+}
+
+// CGroupPaths returns the cgroups the calling process belongs to, as listed
+// in /proc/self/cgroup, keyed by controller, e.g. "cpu" and "memory" on
+// cgroups v1. On cgroups v2, the unified hierarchy is the only entry, under
+// the empty key "". Like CGroupPath, it doesn't change GOMAXPROCS and is
+// meant for bug reports. It returns no paths on non-Linux systems.
+func CGroupPaths() (map[string]string, error) {
+	return iruntime.CGroupPaths()
+}
+
+// CGroupHybrid is the version CGroupVersion reports for systems mounting
+// cgroups v1 controllers alongside a cgroups v2 hierarchy, as systemd does in
+// its hybrid mode. The CPU quota is read from the v1 controllers then.
+const CGroupHybrid = iruntime.CGroupHybrid
+
+// CGroupVersion returns the version of cgroups the CPU quota is read from,
+// as told apart by the cgroup mounts of the calling process: 1, 2 or
+// CGroupHybrid, or 0 if the process doesn't use cgroups. It's meant for
+// diagnostics. Cgroups are only supported on Linux; elsewhere, CGroupVersion
+// always fails with an error matching ErrCGroupsUnavailable.
+func CGroupVersion() (int, error) {
+	return iruntime.Detector{}.CGroupVersion()
+}
+
+// DetectRuntime returns a best guess of the container runtime running the
+// calling process, based on recognizable parts of its cgroup path (see
+// CGroupPath): "docker", "containerd", "cri-o", "kubernetes", "systemd",
+// "lxc", "nomad" or "mesos". It returns "unknown" if the path matches none
+// of them, e.g. outside of containers or on non-Linux systems.
+func DetectRuntime() (string, error) {
+	path, err := CGroupPath()
+	if err != nil {
+		return "", err
+	}
+	return iruntime.ContainerRuntime(path), nil
+}
+
+// FromMillicores returns the GOMAXPROCS value Set would use for a CPU limit
+// of m millicores, the unit Kubernetes uses for CPU limits (e.g. 1500 for
+// "1500m"). This lets tools that read limits from the Kubernetes API rather
+// than from cgroups size GOMAXPROCS the same way.
+//
+// FromMillicores honors the Min, Max and rounding options. It neither reads
+// the CPU quota nor changes GOMAXPROCS. Since it returns no error, it ignores
+// invalid options, such as Min(0), and keeps their defaults instead.
+func FromMillicores(m int, opts ...Option) int {
+	cfg := newConfig(opts)
+	maxProcs, _ := detect.QuotaToGOMAXPROCS(float64(m)/1000, cfg.minGOMAXPROCS, cfg.roundQuotaFunc)
+	return cfg.capMaxProcs(maxProcs)
 }
@@ -22,14 +22,21 @@ package maxprocs
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"strconv"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"go.uber.org/automaxprocs/detect"
 	iruntime "go.uber.org/automaxprocs/internal/runtime"
 
 	"github.com/stretchr/testify/assert"
@@ -56,6 +63,12 @@ func testLogger() (*bytes.Buffer, Option) {
 	return buf, Logger(printf)
 }
 
+func stubMemLimit(limit uint64, ok bool, err error) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.memLimit = func() (uint64, bool, error) { return limit, ok, err }
+	})
+}
+
 func stubProcs(f func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error)) Option {
 	return optionFunc(func(cfg *config) {
 		cfg.procs = f
@@ -134,31 +147,205 @@ func TestSet(t *testing.T) {
 		assert.Contains(t, buf.String(), "quota undefined", "unexpected log output")
 	})
 
-	t.Run("QuotaTooSmall", func(t *testing.T) {
+	t.Run("GVisor", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		gVisorOpt := optionFunc(func(cfg *config) {
+			cfg.isGVisor = func() bool { return true }
+		})
+		prev := currentMaxProcs()
+		undo, err := Set(logOpt, quotaOpt, gVisorOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		assert.Contains(t, buf.String(), "Running under gVisor", "unexpected log output")
+		assert.Contains(t, buf.String(), "quota undefined", "unexpected log output")
+	})
+
+	t.Run("LogAllocation", func(t *testing.T) {
 		buf, logOpt := testLogger()
 		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
-			return min, iruntime.CPUQuotaMinUsed, nil
+			return round(2), iruntime.CPUQuotaUsed, nil
 		})
-		undo, err := Set(logOpt, quotaOpt, Min(5))
+		hostOpt := optionFunc(func(cfg *config) {
+			cfg.numCPU = func() int { return 64 }
+		})
+		undo, err := Set(logOpt, quotaOpt, hostOpt, LogAllocation())
 		defer undo()
 		require.NoError(t, err, "Set failed")
-		assert.Equal(t, 5, currentMaxProcs(), "should use min allowed GOMAXPROCS")
-		assert.Contains(t, buf.String(), "using minimum allowed", "unexpected log output")
+		assert.Equal(t, 2, currentMaxProcs(), "unexpected GOMAXPROCS")
+		assert.Equal(t, "maxprocs: Updating GOMAXPROCS=2: determined from CPU quota, quota 2 of 64 host cores (3%)", buf.String(), "unexpected log output")
+	})
+
+	t.Run("LogAllocationQuotaUndefined", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		undo, err := Set(logOpt, quotaOpt, LogAllocation())
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.NotContains(t, buf.String(), "host cores", "unexpected log output")
+	})
+
+	t.Run("AssumeUncontained", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			t.Error("shouldn't detect the CPU quota")
+			return 42, iruntime.CPUQuotaUsed, nil
+		})
+		gVisorOpt := optionFunc(func(cfg *config) {
+			cfg.isGVisor = func() bool {
+				t.Error("shouldn't read /proc")
+				return false
+			}
+		})
+		prev := currentMaxProcs()
+		undo, source, err := SetFromEnvOrCGroup(logOpt, quotaOpt, gVisorOpt, ProcFS(filepath.Join(t.TempDir(), "missing")), AssumeUncontained())
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, SourceNumCPU, source)
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		assert.Contains(t, buf.String(), "assuming no container limits", "unexpected log output")
+	})
+
+	t.Run("ProcFSMissing", func(t *testing.T) {
+		prev := currentMaxProcs()
+		undo, err := Set(ProcFS(filepath.Join(t.TempDir(), "missing")))
+		defer undo()
+		require.Error(t, err, "Set should have failed")
+		assert.Contains(t, err.Error(), "invalid procfs path")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+
+	t.Run("CGroupPIDMissing", func(t *testing.T) {
+		prev := currentMaxProcs()
+		procFS := t.TempDir()
+		undo, err := Set(ProcFS(procFS), CGroupPID(42))
+		defer undo()
+		require.Error(t, err, "Set should have failed")
+		assert.Contains(t, err.Error(), "can't read cgroups of PID 42")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+
+	t.Run("CGroupPIDInvalid", func(t *testing.T) {
+		prev := currentMaxProcs()
+		undo, err := Set(CGroupPID(-1))
+		defer undo()
+		require.Error(t, err, "Set should have failed")
+		assert.Contains(t, err.Error(), "invalid cgroup PID -1")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+
+	t.Run("CGroupPID", func(t *testing.T) {
+		var cfg config
+		CGroupPID(42).apply(&cfg)
+		assert.Equal(t, 42, cfg.detector.PID)
+		assert.NoError(t, cfg.err)
+	})
+
+	t.Run("ProcFS", func(t *testing.T) {
+		var cfg config
+		ProcFS("/host/proc").apply(&cfg)
+		assert.Equal(t, "/host/proc", cfg.detector.ProcFS)
+	})
+
+	t.Run("CGroupFS", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"host/proc/self/mountinfo":  {Data: []byte("29 22 0:26 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:4 - cgroup2 cgroup2 rw,nsdelegate\n")},
+			"host/proc/self/cgroup":     {Data: []byte("0::/app\n")},
+			"sys/fs/cgroup/app/cpu.max": {Data: []byte("300000 100000\n")},
+		}
+		prev := currentMaxProcs()
+		undo, err := Set(CGroupFS(fsys), ProcFS("/host/proc"))
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 3, currentMaxProcs(), "should read the CPU quota from the filesystem")
+		undo()
+		assert.Equal(t, prev, currentMaxProcs(), "should reset GOMAXPROCS")
+
+		undo, err = Set(CGroupFS(fsys), ProcFS("/proc"))
+		defer undo()
+		require.Error(t, err, "Set should have failed")
+		assert.Contains(t, err.Error(), "invalid procfs path")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+
+	t.Run("GVisorProcFS", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		fsys := fstest.MapFS{
+			"host/proc/version": {Data: []byte("Linux version 4.4.0 #1 SMP Sun Jan 10 15:06:54 PST 2016\n")},
+		}
+		prev := currentMaxProcs()
+		undo, err := Set(logOpt, CGroupFS(fsys), ProcFS("/host/proc"))
+		defer undo()
+		require.NoError(t, err, "missing cgroup files are expected under gVisor")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		assert.Contains(t, buf.String(), "Running under gVisor", "should read the version from ProcFS")
+	})
+
+	t.Run("MaxReadSize", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"proc/self/mountinfo":       {Data: []byte("29 22 0:26 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:4 - cgroup2 cgroup2 rw,nsdelegate\n")},
+			"proc/self/cgroup":          {Data: []byte("0::/app\n")},
+			"sys/fs/cgroup/app/cpu.max": {Data: []byte("300000 100000\n")},
+		}
+		quota, _, err := CPUQuota(CGroupFS(fsys), MaxReadSize(1<<10))
+		require.NoError(t, err, "CPUQuota failed")
+		assert.Equal(t, 3.0, quota)
+
+		_, _, err = CPUQuota(CGroupFS(fsys), MaxReadSize(16))
+		assert.ErrorIs(t, err, ErrCGroupsUnavailable, "should cap reads of mountinfo")
+
+		_, _, err = CPUQuota(CGroupFS(fsys), MaxReadSize(0))
+		require.Error(t, err, "CPUQuota should have failed")
+		assert.Contains(t, err.Error(), "invalid maximum read size")
 	})
 
-	t.Run("Min unused", func(t *testing.T) {
+	t.Run("QuotaTooSmall", func(t *testing.T) {
 		buf, logOpt := testLogger()
 		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
 			return min, iruntime.CPUQuotaMinUsed, nil
 		})
-		// Min(-1) should be ignored.
-		undo, err := Set(logOpt, quotaOpt, Min(5), Min(-1))
+		undo, err := Set(logOpt, quotaOpt, Min(5))
 		defer undo()
 		require.NoError(t, err, "Set failed")
 		assert.Equal(t, 5, currentMaxProcs(), "should use min allowed GOMAXPROCS")
 		assert.Contains(t, buf.String(), "using minimum allowed", "unexpected log output")
 	})
 
+	t.Run("Min invalid", func(t *testing.T) {
+		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return min, iruntime.CPUQuotaMinUsed, nil
+		})
+		for _, n := range []int{0, -1} {
+			prev := currentMaxProcs()
+			undo, err := Set(quotaOpt, Min(5), Min(n))
+			defer undo()
+			require.Error(t, err, "Set should have failed for Min(%d)", n)
+			assert.Contains(t, err.Error(), "invalid minimum GOMAXPROCS", "unexpected error")
+			assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		}
+	})
+
+	t.Run("Min with rounding", func(t *testing.T) {
+		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			procs, status := iruntime.QuotaToGOMAXPROCS(1.5, min, round)
+			return procs, status, nil
+		})
+		procs, status, err := Detect(quotaOpt, Min(2))
+		require.NoError(t, err, "Detect failed")
+		assert.Equal(t, 2, procs, "should use min allowed GOMAXPROCS")
+		assert.Equal(t, iruntime.CPUQuotaMinUsed, status)
+
+		procs, status, err = Detect(quotaOpt, Min(2), RoundUpAnyFraction())
+		require.NoError(t, err, "Detect failed")
+		assert.Equal(t, 2, procs, "should use rounded quota")
+		assert.Equal(t, iruntime.CPUQuotaUsed, status)
+	})
+
 	t.Run("QuotaUsed", func(t *testing.T) {
 		opt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
 			assert.Equal(t, 1, min, "Default minimum value should be 1")
@@ -170,6 +357,107 @@ func TestSet(t *testing.T) {
 		assert.Equal(t, 42, currentMaxProcs(), "should change GOMAXPROCS to match quota")
 	})
 
+	t.Run("QuotaTooLarge", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 10000, iruntime.CPUQuotaUsed, nil
+		})
+		undo, err := Set(logOpt, quotaOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, _maxGOMAXPROCS, currentMaxProcs(), "should cap GOMAXPROCS at the default maximum")
+		assert.Contains(t, buf.String(), "Capping GOMAXPROCS=10000", "unexpected log output")
+	})
+
+	t.Run("Max", func(t *testing.T) {
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 10000, iruntime.CPUQuotaUsed, nil
+		})
+		undo, err := Set(quotaOpt, Max(4))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 4, currentMaxProcs(), "should cap GOMAXPROCS at the configured maximum")
+	})
+
+	t.Run("Max invalid", func(t *testing.T) {
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 10000, iruntime.CPUQuotaUsed, nil
+		})
+		for _, n := range []int{0, -1} {
+			prev := currentMaxProcs()
+			undo, err := Set(quotaOpt, Max(4), Max(n))
+			defer undo()
+			require.Error(t, err, "Set should have failed for Max(%d)", n)
+			assert.Contains(t, err.Error(), "invalid maximum GOMAXPROCS", "unexpected error")
+			assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		}
+	})
+
+	t.Run("MaxBelowMin", func(t *testing.T) {
+		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return min, iruntime.CPUQuotaMinUsed, nil
+		})
+		undo, err := Set(quotaOpt, Min(8), Max(3))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 3, currentMaxProcs(), "maximum should take precedence over the minimum")
+	})
+
+	t.Run("SharesUsed", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 3, iruntime.CPUQuotaSharesUsed, nil
+		})
+		undo, err := Set(logOpt, quotaOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 3, currentMaxProcs(), "should change GOMAXPROCS to match shares")
+		assert.Contains(t, buf.String(), "estimated from CPU shares", "unexpected log output")
+	})
+
+	t.Run("CPUSetUsed", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 4, iruntime.CPUQuotaCPUSetUsed, nil
+		})
+		undo, err := Set(logOpt, quotaOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 4, currentMaxProcs(), "should change GOMAXPROCS to match the cpuset")
+		assert.Contains(t, buf.String(), "limited by cpuset", "unexpected log output")
+	})
+
+	t.Run("SharesFallback", func(t *testing.T) {
+		var cfg config
+		assert.False(t, cfg.detector.SharesFallback, "shares fallback should be off by default")
+		SharesFallback().apply(&cfg)
+		assert.True(t, cfg.detector.SharesFallback, "shares fallback should be enabled")
+	})
+
+	t.Run("PreferRequests", func(t *testing.T) {
+		var cfg config
+		assert.False(t, cfg.detector.PreferShares, "limits should be preferred by default")
+		PreferRequests().apply(&cfg)
+		assert.True(t, cfg.detector.PreferShares, "requests should be preferred")
+	})
+
+	t.Run("UndoRestoresManualValue", func(t *testing.T) {
+		// Undo must restore whatever GOMAXPROCS was in effect before Set,
+		// not the default of runtime.NumCPU().
+		orig := runtime.GOMAXPROCS(3)
+		defer runtime.GOMAXPROCS(orig)
+
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 42, iruntime.CPUQuotaUsed, nil
+		})
+		undo, err := Set(opt)
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 42, currentMaxProcs(), "should change GOMAXPROCS to match quota")
+
+		undo()
+		assert.Equal(t, 3, currentMaxProcs(), "should restore the manually set GOMAXPROCS")
+	})
+
 	t.Run("RoundQuotaSetToCeil", func(t *testing.T) {
 		opt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
 			assert.Equal(t, round(2.4), 3, "round should be math.Ceil")
@@ -193,6 +481,1873 @@ func TestSet(t *testing.T) {
 	})
 }
 
+func TestSetUndo(t *testing.T) {
+	quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return 42, iruntime.CPUQuotaUsed, nil
+	})
+
+	t.Run("called twice", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		prev := currentMaxProcs()
+		undo, err := Set(logOpt, quotaOpt)
+		require.NoError(t, err, "Set failed")
+		require.Equal(t, 42, currentMaxProcs())
+
+		undo()
+		assert.Equal(t, prev, currentMaxProcs(), "should restore GOMAXPROCS")
+
+		// Another change made after the first undo must survive the second.
+		runtime.GOMAXPROCS(42)
+		defer runtime.GOMAXPROCS(prev)
+		buf.Reset()
+		undo()
+		assert.Equal(t, 42, currentMaxProcs(), "second undo should be a no-op")
+		assert.Empty(t, buf.String(), "second undo shouldn't log")
+	})
+
+	t.Run("manual override in between", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		prev := currentMaxProcs()
+		undo, err := Set(logOpt, quotaOpt)
+		require.NoError(t, err, "Set failed")
+		defer runtime.GOMAXPROCS(prev)
+
+		runtime.GOMAXPROCS(7)
+		buf.Reset()
+		undo()
+		assert.Equal(t, 7, currentMaxProcs(), "undo shouldn't clobber the override")
+		assert.Equal(t, "maxprocs: Leaving GOMAXPROCS=7: changed since it was set to 42", buf.String())
+	})
+}
+
+func TestBurstBlend(t *testing.T) {
+	tests := []struct {
+		factor float64
+		want   int
+	}{
+		{factor: 0, want: 2},
+		{factor: 0.5, want: 5},
+		{factor: 1, want: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.factor), func(t *testing.T) {
+			quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+				return round(2), iruntime.CPUQuotaUsed, nil
+			})
+			hostOpt := optionFunc(func(cfg *config) {
+				cfg.numCPU = func() int { return 8 }
+			})
+			undo, err := Set(quotaOpt, hostOpt, BurstBlend(tt.factor))
+			defer undo()
+			require.NoError(t, err, "Set failed")
+			assert.Equal(t, tt.want, currentMaxProcs(), "unexpected GOMAXPROCS")
+		})
+	}
+
+	t.Run("QuotaAboveNumCPU", func(t *testing.T) {
+		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return round(12), iruntime.CPUQuotaUsed, nil
+		})
+		hostOpt := optionFunc(func(cfg *config) {
+			cfg.numCPU = func() int { return 8 }
+		})
+		undo, err := Set(quotaOpt, hostOpt, BurstBlend(0.5))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 8, currentMaxProcs(), "should cap GOMAXPROCS at NumCPU")
+	})
+
+	for _, factor := range []float64{-1, 1.5, math.NaN()} {
+		t.Run(fmt.Sprintf("Invalid%v", factor), func(t *testing.T) {
+			prev := currentMaxProcs()
+			undo, err := Set(BurstBlend(factor))
+			defer undo()
+			require.Error(t, err, "Set should have failed")
+			assert.Contains(t, err.Error(), "invalid burst blend factor", "unexpected error")
+			assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		})
+	}
+}
+
+func TestCPUReservePercent(t *testing.T) {
+	quotaOpt := func(quota float64) Option {
+		return stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			procs, status := iruntime.QuotaToGOMAXPROCS(quota, min, round)
+			return procs, status, nil
+		})
+	}
+
+	tests := []struct {
+		name       string
+		quota      float64
+		percent    float64
+		want       int
+		wantStatus iruntime.CPUQuotaStatus
+	}{
+		{name: "NoReserve", quota: 10, percent: 0, want: 10, wantStatus: iruntime.CPUQuotaUsed},
+		{name: "Reserve", quota: 10, percent: 10, want: 9, wantStatus: iruntime.CPUQuotaUsed},
+		{name: "RoundedDown", quota: 4, percent: 10, want: 3, wantStatus: iruntime.CPUQuotaUsed},
+		{name: "BelowMin", quota: 1.05, percent: 10, want: 1, wantStatus: iruntime.CPUQuotaMinUsed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procs, status, err := Detect(quotaOpt(tt.quota), CPUReservePercent(tt.percent))
+			require.NoError(t, err, "Detect failed")
+			assert.Equal(t, tt.want, procs, "unexpected GOMAXPROCS")
+			assert.Equal(t, tt.wantStatus, status, "unexpected status")
+		})
+	}
+
+	for _, percent := range []float64{-1, 99.5, 100} {
+		t.Run(fmt.Sprintf("Invalid%v", percent), func(t *testing.T) {
+			prev := currentMaxProcs()
+			undo, err := Set(quotaOpt(10), CPUReservePercent(percent))
+			defer undo()
+			require.Error(t, err, "Set should have failed")
+			assert.Contains(t, err.Error(), "invalid CPU reserve", "unexpected error")
+			assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		})
+	}
+}
+
+func TestGOMAXPROCSBounds(t *testing.T) {
+	quotaOpt := func(quota float64) Option {
+		return stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			procs, status := iruntime.QuotaToGOMAXPROCS(quota, min, round)
+			return procs, status, nil
+		})
+	}
+
+	tests := []struct {
+		name       string
+		quota      float64
+		want       int
+		wantStatus iruntime.CPUQuotaStatus
+		wantLog    string
+	}{
+		{name: "BelowMin", quota: 1, want: 2, wantStatus: iruntime.CPUQuotaMinUsed},
+		{name: "Within", quota: 8, want: 8, wantStatus: iruntime.CPUQuotaUsed},
+		{name: "AboveMax", quota: 32, want: 16, wantStatus: iruntime.CPUQuotaUsed, wantLog: "maxprocs: Capping GOMAXPROCS=32 to maximum allowed GOMAXPROCS=16"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, logOpt := testLogger()
+			procs, status, err := Detect(logOpt, quotaOpt(tt.quota), GOMAXPROCSBounds(2, 16))
+			require.NoError(t, err, "Detect failed")
+			assert.Equal(t, tt.want, procs, "unexpected GOMAXPROCS")
+			assert.Equal(t, tt.wantStatus, status, "unexpected status")
+			assert.Equal(t, tt.wantLog, buf.String(), "unexpected log output")
+		})
+	}
+
+	for _, bounds := range [][2]int{{0, 4}, {3, 2}, {-1, -1}} {
+		t.Run(fmt.Sprintf("Invalid%v", bounds), func(t *testing.T) {
+			prev := currentMaxProcs()
+			undo, err := Set(quotaOpt(8), GOMAXPROCSBounds(bounds[0], bounds[1]))
+			defer undo()
+			require.Error(t, err, "Set should have failed")
+			assert.Contains(t, err.Error(), "invalid GOMAXPROCS bounds", "unexpected error")
+			assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		})
+	}
+}
+
+func TestEnvOverride(t *testing.T) {
+	const key = "TEST_AUTOMAXPROCS_CPU_LIMIT"
+	quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		procs, status := iruntime.QuotaToGOMAXPROCS(4, min, round)
+		return procs, status, nil
+	})
+
+	tests := []struct {
+		desc    string
+		value   string
+		opts    []Option
+		want    int
+		source  Source
+		wantLog string
+	}{
+		{
+			desc:    "integer",
+			value:   "3",
+			want:    3,
+			source:  SourceEnv,
+			wantLog: `maxprocs: Updating GOMAXPROCS=3: honoring TEST_AUTOMAXPROCS_CPU_LIMIT="3" as set in environment`,
+		},
+		{
+			desc:   "fraction",
+			value:  "2.5",
+			want:   2,
+			source: SourceEnv,
+		},
+		{
+			desc:   "fraction rounded up",
+			value:  "2.5",
+			opts:   []Option{RoundUpAnyFraction()},
+			want:   3,
+			source: SourceEnv,
+		},
+		{
+			desc:   "below min",
+			value:  "0.5",
+			opts:   []Option{Min(2)},
+			want:   2,
+			source: SourceEnv,
+		},
+		{
+			desc:   "above max",
+			value:  "8",
+			opts:   []Option{Max(5)},
+			want:   5,
+			source: SourceEnv,
+		},
+		{
+			desc:    "invalid",
+			value:   "lots",
+			want:    4,
+			source:  SourceCGroup,
+			wantLog: `maxprocs: Ignoring invalid TEST_AUTOMAXPROCS_CPU_LIMIT="lots" in environment`,
+		},
+		{
+			desc:   "zero",
+			value:  "0",
+			want:   4,
+			source: SourceCGroup,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Setenv(key, tt.value)
+			buf, logOpt := testLogger()
+			opts := append([]Option{logOpt, quotaOpt, EnvOverride(key)}, tt.opts...)
+			undo, source, err := SetFromEnvOrCGroup(opts...)
+			require.NoError(t, err, "SetFromEnvOrCGroup failed")
+			defer undo()
+
+			assert.Equal(t, tt.want, currentMaxProcs(), "unexpected GOMAXPROCS")
+			assert.Equal(t, tt.source, source, "unexpected source")
+			assert.Contains(t, buf.String(), tt.wantLog)
+		})
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		undo, source, err := SetFromEnvOrCGroup(quotaOpt, EnvOverride(key))
+		require.NoError(t, err, "SetFromEnvOrCGroup failed")
+		defer undo()
+
+		assert.Equal(t, 4, currentMaxProcs())
+		assert.Equal(t, SourceCGroup, source)
+	})
+
+	t.Run("GOMAXPROCS takes precedence", func(t *testing.T) {
+		prev := currentMaxProcs()
+		t.Setenv(_maxProcsKey, "42")
+		t.Setenv(key, "3")
+		undo, source, err := SetFromEnvOrCGroup(quotaOpt, EnvOverride(key))
+		require.NoError(t, err, "SetFromEnvOrCGroup failed")
+		defer undo()
+
+		assert.Equal(t, prev, currentMaxProcs())
+		assert.Equal(t, SourceEnv, source)
+	})
+}
+
+func TestSchedulerEnvFallback(t *testing.T) {
+	undefinedOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return -1, iruntime.CPUQuotaUndefined, nil
+	})
+	numCPUOpt := optionFunc(func(cfg *config) {
+		cfg.numCPU = func() int { return 16 }
+	})
+
+	tests := []struct {
+		desc    string
+		env     map[string]string
+		opts    []Option
+		want    int
+		wantLog string
+	}{
+		{
+			desc:    "nomad",
+			env:     map[string]string{"NOMAD_CPU_LIMIT": "6000"},
+			want:    3,
+			wantLog: `maxprocs: Updating GOMAXPROCS=3: CPU quota undefined, using scheduler allocation NOMAD_CPU_LIMIT="6000"`,
+		},
+		{
+			desc: "nomad custom clock rate",
+			env:  map[string]string{"NOMAD_CPU_LIMIT": "6000"},
+			opts: []Option{SchedulerMHzPerCore(1500)},
+			want: 4,
+		},
+		{
+			desc:    "mesos",
+			env:     map[string]string{"MESOS_CPU": "2.5"},
+			want:    2,
+			wantLog: `using scheduler allocation MESOS_CPU="2.5"`,
+		},
+		{
+			desc: "nomad first",
+			env:  map[string]string{"NOMAD_CPU_LIMIT": "2000", "MESOS_CPU": "5"},
+			want: 1,
+		},
+		{
+			desc:    "invalid nomad",
+			env:     map[string]string{"NOMAD_CPU_LIMIT": "fast", "MESOS_CPU": "5"},
+			want:    5,
+			wantLog: `maxprocs: Ignoring invalid NOMAD_CPU_LIMIT="fast" in environment`,
+		},
+		{
+			desc: "capped to CPUs",
+			env:  map[string]string{"MESOS_CPU": "64"},
+			want: 16,
+		},
+		{
+			desc: "min",
+			env:  map[string]string{"MESOS_CPU": "0.5"},
+			opts: []Option{Min(2)},
+			want: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			buf, logOpt := testLogger()
+			opts := append([]Option{logOpt, undefinedOpt, numCPUOpt, SchedulerEnvFallback()}, tt.opts...)
+			undo, source, err := SetFromEnvOrCGroup(opts...)
+			defer undo()
+			require.NoError(t, err, "SetFromEnvOrCGroup failed")
+			assert.Equal(t, tt.want, currentMaxProcs(), "unexpected GOMAXPROCS")
+			assert.Equal(t, SourceEnv, source)
+			assert.Contains(t, buf.String(), tt.wantLog)
+		})
+	}
+
+	t.Run("opt-in", func(t *testing.T) {
+		t.Setenv("MESOS_CPU", "2")
+		prev := currentMaxProcs()
+		undo, source, err := SetFromEnvOrCGroup(undefinedOpt, numCPUOpt)
+		defer undo()
+		require.NoError(t, err, "SetFromEnvOrCGroup failed")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't use the scheduler allocation")
+		assert.Equal(t, SourceNumCPU, source)
+	})
+
+	for _, mhz := range []float64{0, -1000, math.NaN(), math.Inf(1)} {
+		t.Run(fmt.Sprintf("invalid clock rate %v", mhz), func(t *testing.T) {
+			t.Setenv("NOMAD_CPU_LIMIT", "6000")
+			prev := currentMaxProcs()
+			undo, _, err := SetFromEnvOrCGroup(undefinedOpt, numCPUOpt, SchedulerEnvFallback(), SchedulerMHzPerCore(mhz))
+			defer undo()
+			require.Error(t, err, "SetFromEnvOrCGroup should have failed")
+			assert.Contains(t, err.Error(), "invalid clock rate", "unexpected error")
+			assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		})
+	}
+
+	t.Run("quota defined", func(t *testing.T) {
+		t.Setenv("MESOS_CPU", "2")
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 5, iruntime.CPUQuotaUsed, nil
+		})
+		undo, source, err := SetFromEnvOrCGroup(quotaOpt, numCPUOpt, SchedulerEnvFallback())
+		defer undo()
+		require.NoError(t, err, "SetFromEnvOrCGroup failed")
+		assert.Equal(t, 5, currentMaxProcs(), "should prefer the CPU quota")
+		assert.Equal(t, SourceCGroup, source)
+	})
+}
+
+func TestUseOnlineCPUs(t *testing.T) {
+	undefinedOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return -1, iruntime.CPUQuotaUndefined, nil
+	})
+	onlineOpt := func(n int) Option {
+		return optionFunc(func(cfg *config) {
+			cfg.numCPU = func() int { return n }
+		})
+	}
+
+	t.Run("QuotaUndefined", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		prev := currentMaxProcs()
+		want := prev + 1
+		undo, source, err := SetFromEnvOrCGroup(logOpt, undefinedOpt, UseOnlineCPUs(), onlineOpt(want))
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, SourceNumCPU, source)
+		assert.Equal(t, want, currentMaxProcs(), "should use the online CPUs")
+		assert.Equal(t, fmt.Sprintf("maxprocs: Updating GOMAXPROCS=%v: CPU quota undefined, using online CPUs", want), buf.String())
+
+		undo()
+		assert.Equal(t, prev, currentMaxProcs(), "didn't undo GOMAXPROCS changes")
+	})
+
+	t.Run("SameCount", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		prev := currentMaxProcs()
+		undo, err := Set(logOpt, undefinedOpt, UseOnlineCPUs(), onlineOpt(prev))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		assert.Contains(t, buf.String(), "quota undefined", "unexpected log output")
+	})
+
+	t.Run("OptIn", func(t *testing.T) {
+		prev := currentMaxProcs()
+		undo, err := Set(undefinedOpt, onlineOpt(prev+1))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS by default")
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		assert.Positive(t, onlineCPUs(), "online CPUs should fall back to NumCPU")
+	})
+}
+
+func TestDivideBySiblings(t *testing.T) {
+	quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return round(8), iruntime.CPUQuotaUsed, nil
+	})
+	stubProcessCount := func(n int, ok bool, err error) Option {
+		return optionFunc(func(cfg *config) {
+			cfg.processCount = func() (int, bool, error) { return n, ok, err }
+		})
+	}
+
+	t.Run("Siblings", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		undo, err := Set(quotaOpt, stubProcessCount(4, true, nil), DivideBySiblings(), logOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 2, currentMaxProcs(), "should divide the quota among processes")
+		assert.Contains(t, buf.String(), "Dividing CPU quota of 8 among 4 processes", "unexpected log output")
+	})
+
+	t.Run("SingleProcess", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		undo, err := Set(quotaOpt, stubProcessCount(1, true, nil), DivideBySiblings(), logOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 8, currentMaxProcs(), "unexpected GOMAXPROCS")
+		assert.NotContains(t, buf.String(), "Dividing", "unexpected log output")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		undo, err := Set(quotaOpt, stubProcessCount(4, true, nil))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 8, currentMaxProcs(), "shouldn't divide by default")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		prev := currentMaxProcs()
+		undo, err := Set(quotaOpt, stubProcessCount(0, false, errors.New("failed")), DivideBySiblings())
+		defer undo()
+		require.Error(t, err, "Set should fail")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't change GOMAXPROCS")
+	})
+}
+
+func TestMaxProcsPerMemGB(t *testing.T) {
+	quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return 8, iruntime.CPUQuotaUsed, nil
+	})
+	t.Run("Capped", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		undo, err := Set(logOpt, quotaOpt, stubMemLimit(2<<30, true, nil), MaxProcsPerMemGB(2))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 4, currentMaxProcs(), "should cap GOMAXPROCS by memory limit")
+		assert.Contains(t, buf.String(), "Capping GOMAXPROCS=8 to 4 for memory limit of 2147483648 bytes", "unexpected log output")
+	})
+
+	t.Run("BelowMin", func(t *testing.T) {
+		undo, err := Set(quotaOpt, stubMemLimit(256<<20, true, nil), MaxProcsPerMemGB(2), Min(2))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 2, currentMaxProcs(), "shouldn't cap GOMAXPROCS below the minimum")
+	})
+
+	t.Run("NoLimit", func(t *testing.T) {
+		undo, err := Set(quotaOpt, stubMemLimit(0, false, nil), MaxProcsPerMemGB(2))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 8, currentMaxProcs(), "should use the CPU quota")
+	})
+
+	t.Run("Unused", func(t *testing.T) {
+		undo, err := Set(quotaOpt, stubMemLimit(0, false, errors.New("failed")))
+		defer undo()
+		require.NoError(t, err, "shouldn't read the memory limit")
+		assert.Equal(t, 8, currentMaxProcs(), "should use the CPU quota")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		prev := currentMaxProcs()
+		undo, err := Set(quotaOpt, stubMemLimit(0, false, errors.New("failed")), MaxProcsPerMemGB(2))
+		defer undo()
+		require.Error(t, err, "Set should have failed")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+
+	for _, n := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		t.Run(fmt.Sprintf("Invalid%v", n), func(t *testing.T) {
+			prev := currentMaxProcs()
+			undo, err := Set(quotaOpt, stubMemLimit(2<<30, true, nil), MaxProcsPerMemGB(n))
+			defer undo()
+			require.Error(t, err, "Set should have failed")
+			assert.Contains(t, err.Error(), "invalid GOMAXPROCS per GiB", "unexpected error")
+			assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		})
+	}
+}
+
+func TestSetMemoryLimit(t *testing.T) {
+	currentMemLimit := func() int64 { return debug.SetMemoryLimit(-1) }
+	physMemOpt := optionFunc(func(cfg *config) {
+		cfg.physMem = func() (uint64, bool) { return 8 << 30, true }
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		undo, status, err := SetMemoryLimit(logOpt, physMemOpt, stubMemLimit(2<<30, true, nil))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.Equal(t, TotalMemoryUsed, status)
+		assert.Equal(t, int64(1932735284), currentMemLimit(), "should keep 10% in reserve by default")
+		assert.Contains(t, buf.String(), "Updating GOMEMLIMIT=1932735284: determined from memory limit", "unexpected log output")
+	})
+
+	t.Run("NoReserve", func(t *testing.T) {
+		undo, _, err := SetMemoryLimit(physMemOpt, stubMemLimit(2<<30, true, nil), MemoryLimitReserve(0))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.Equal(t, int64(2<<30), currentMemLimit())
+	})
+
+	t.Run("Reserve", func(t *testing.T) {
+		undo, status, err := SetMemoryLimit(physMemOpt, stubMemLimit(2<<30, true, nil), MemoryLimitReserve(25))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.Equal(t, TotalMemoryUsed, status)
+		assert.Equal(t, int64(3<<29), currentMemLimit(), "should keep 25% in reserve")
+	})
+
+	for _, percent := range []float64{-1, 100, math.NaN()} {
+		t.Run(fmt.Sprintf("InvalidReserve%v", percent), func(t *testing.T) {
+			prev := currentMemLimit()
+			undo, status, err := SetMemoryLimit(physMemOpt, stubMemLimit(2<<30, true, nil), MemoryLimitReserve(percent))
+			defer undo()
+			require.Error(t, err, "SetMemoryLimit should have failed")
+			assert.Contains(t, err.Error(), "invalid memory limit reserve", "unexpected error")
+			assert.Equal(t, TotalMemoryUndefined, status)
+			assert.Equal(t, prev, currentMemLimit(), "shouldn't alter GOMEMLIMIT")
+		})
+	}
+
+	t.Run("MinimumLimit", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		undo, _, err := SetMemoryLimit(logOpt, physMemOpt, stubMemLimit(17<<20, true, nil), MemoryLimitReserve(50))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.Equal(t, int64(16<<20), currentMemLimit(), "should keep GOMEMLIMIT at 16MiB")
+		assert.Contains(t, buf.String(), "Clamping GOMEMLIMIT=8912896 to minimum of 16777216 bytes after 50% reserve", "unexpected log output")
+	})
+
+	t.Run("SmallLimit", func(t *testing.T) {
+		undo, _, err := SetMemoryLimit(physMemOpt, stubMemLimit(8<<20, true, nil))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.Equal(t, int64(8<<20), currentMemLimit(), "shouldn't reserve below 16MiB")
+	})
+
+	t.Run("ClampedToPhysicalMemory", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		undo, status, err := SetMemoryLimit(logOpt, physMemOpt, stubMemLimit(16<<30, true, nil), MemoryLimitReserve(0))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.Equal(t, TotalMemoryUsed, status)
+		assert.Equal(t, int64(8<<30), currentMemLimit())
+		assert.Contains(t, buf.String(), "Clamping memory limit of 17179869184 bytes to physical memory of 8589934592 bytes", "unexpected log output")
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		prev := currentMemLimit()
+		buf, logOpt := testLogger()
+		undo, status, err := SetMemoryLimit(logOpt, physMemOpt, stubMemLimit(0, false, nil))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.Equal(t, TotalMemoryUndefined, status)
+		assert.Equal(t, prev, currentMemLimit(), "shouldn't alter GOMEMLIMIT")
+		assert.Contains(t, buf.String(), "Leaving GOMEMLIMIT unchanged: memory limit undefined", "unexpected log output")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		prev := currentMemLimit()
+		undo, status, err := SetMemoryLimit(stubMemLimit(0, false, errors.New("failed")))
+		defer undo()
+		require.Error(t, err, "SetMemoryLimit should have failed")
+		assert.Equal(t, TotalMemoryUndefined, status)
+		assert.Equal(t, prev, currentMemLimit(), "shouldn't alter GOMEMLIMIT")
+	})
+
+	t.Run("EnvVariable", func(t *testing.T) {
+		t.Setenv(_memLimitKey, "1GiB")
+		prev := currentMemLimit()
+		buf, logOpt := testLogger()
+		undo, status, err := SetMemoryLimit(logOpt, stubMemLimit(2<<30, true, nil))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.Equal(t, TotalMemoryUndefined, status)
+		assert.Equal(t, prev, currentMemLimit(), "shouldn't alter GOMEMLIMIT")
+		assert.Contains(t, buf.String(), `Honoring GOMEMLIMIT="1GiB" as set in environment`, "unexpected log output")
+	})
+
+	t.Run("Undo", func(t *testing.T) {
+		prev := currentMemLimit()
+		undo, _, err := SetMemoryLimit(physMemOpt, stubMemLimit(2<<30, true, nil))
+		require.NoError(t, err, "SetMemoryLimit failed")
+		undo()
+		assert.Equal(t, prev, currentMemLimit(), "should reset GOMEMLIMIT")
+	})
+}
+
+func TestSetAll(t *testing.T) {
+	currentMemLimit := func() int64 { return debug.SetMemoryLimit(-1) }
+	physMemOpt := optionFunc(func(cfg *config) {
+		cfg.physMem = func() (uint64, bool) { return 8 << 30, true }
+	})
+	quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return 42, iruntime.CPUQuotaUsed, nil
+	})
+
+	t.Run("Both", func(t *testing.T) {
+		prevProcs, prevMem := currentMaxProcs(), currentMemLimit()
+		undo, limits, err := SetAll(quotaOpt, physMemOpt, stubMemLimit(2<<30, true, nil), MemoryLimitReserve(0))
+		require.NoError(t, err, "SetAll failed")
+		assert.Equal(t, Limits{Source: SourceCGroup, CPU: detect.Quota, Memory: TotalMemoryUsed}, limits)
+		assert.Equal(t, 42, currentMaxProcs(), "should change GOMAXPROCS to match quota")
+		assert.Equal(t, int64(2<<30), currentMemLimit(), "should change GOMEMLIMIT to match limit")
+
+		undo()
+		assert.Equal(t, prevProcs, currentMaxProcs(), "should reset GOMAXPROCS")
+		assert.Equal(t, prevMem, currentMemLimit(), "should reset GOMEMLIMIT")
+	})
+
+	t.Run("MemoryUndefined", func(t *testing.T) {
+		prevMem := currentMemLimit()
+		undo, limits, err := SetAll(quotaOpt, physMemOpt, stubMemLimit(0, false, nil))
+		defer undo()
+		require.NoError(t, err, "SetAll failed")
+		assert.Equal(t, Limits{Source: SourceCGroup, CPU: detect.Quota, Memory: TotalMemoryUndefined}, limits)
+		assert.Equal(t, 42, currentMaxProcs(), "should change GOMAXPROCS to match quota")
+		assert.Equal(t, prevMem, currentMemLimit(), "shouldn't change GOMEMLIMIT")
+	})
+
+	t.Run("CPUUndefined", func(t *testing.T) {
+		prevProcs := currentMaxProcs()
+		undefinedOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		undo, limits, err := SetAll(undefinedOpt, physMemOpt, stubMemLimit(2<<30, true, nil), MemoryLimitReserve(0))
+		defer undo()
+		require.NoError(t, err, "SetAll failed")
+		assert.Equal(t, Limits{Source: SourceNumCPU, CPU: detect.Undefined, Memory: TotalMemoryUsed}, limits)
+		assert.Equal(t, prevProcs, currentMaxProcs(), "shouldn't change GOMAXPROCS")
+		assert.Equal(t, int64(2<<30), currentMemLimit(), "should change GOMEMLIMIT to match limit")
+	})
+
+	t.Run("MemoryError", func(t *testing.T) {
+		prevProcs, prevMem := currentMaxProcs(), currentMemLimit()
+		undo, _, err := SetAll(quotaOpt, physMemOpt, stubMemLimit(0, false, errors.New("failed")))
+		defer undo()
+		require.Error(t, err, "SetAll should have failed")
+		assert.Equal(t, prevProcs, currentMaxProcs(), "should reset GOMAXPROCS")
+		assert.Equal(t, prevMem, currentMemLimit(), "shouldn't change GOMEMLIMIT")
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return 42, iruntime.CPUQuotaUsed, nil
+	})
+
+	t.Run("Set", func(t *testing.T) {
+		prev := currentMaxProcs()
+		buf, logOpt := testLogger()
+		undo, source, err := SetFromEnvOrCGroup(logOpt, quotaOpt, DryRun())
+		require.NoError(t, err, "SetFromEnvOrCGroup failed")
+		assert.Equal(t, SourceCGroup, source, "should report where the would-be value comes from")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		assert.Equal(t, "maxprocs: Dry run, would set GOMAXPROCS=42: determined from CPU quota", buf.String(), "unexpected log output")
+
+		runtime.GOMAXPROCS(prev + 1)
+		defer runtime.GOMAXPROCS(prev)
+		undo()
+		assert.Equal(t, prev+1, currentMaxProcs(), "undo should be a no-op")
+	})
+
+	t.Run("Result", func(t *testing.T) {
+		var decisions []Decision
+		hookOpt := DecisionHook(func(d Decision) {
+			decisions = append(decisions, d)
+		})
+		prev := currentMaxProcs()
+		result, err := SetResult(quotaOpt, hookOpt, DryRun())
+		require.NoError(t, err, "SetResult failed")
+		defer result.Undo()
+		assert.Equal(t, prev, result.GOMAXPROCS, "shouldn't alter GOMAXPROCS")
+		assert.Equal(t, prev, result.Previous)
+		assert.True(t, result.DryRun, "result should flag the dry run")
+		assert.Equal(t, 42, result.WouldSet)
+		assert.Equal(t, detect.Quota, result.Status)
+		assert.Equal(t, SourceCGroup, result.Source)
+		require.Len(t, decisions, 1)
+		assert.True(t, decisions[0].DryRun, "decision should flag the dry run")
+		assert.Equal(t, 42, decisions[0].WouldSet)
+		assert.Equal(t, SourceCGroup, decisions[0].Source)
+	})
+
+	t.Run("NoChange", func(t *testing.T) {
+		result, err := SetResult(DryRun(), stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		}))
+		require.NoError(t, err, "SetResult failed")
+		assert.False(t, result.DryRun, "nothing was held back")
+		assert.Zero(t, result.WouldSet)
+	})
+}
+
+func TestDecisionHook(t *testing.T) {
+	var decisions []Decision
+	hookOpt := DecisionHook(func(d Decision) {
+		decisions = append(decisions, d)
+	})
+
+	t.Run("CGroup", func(t *testing.T) {
+		decisions = nil
+		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			procs, status := iruntime.QuotaToGOMAXPROCS(2.5, min, round)
+			return procs, status, nil
+		})
+		prev := currentMaxProcs()
+		undo, err := Set(hookOpt, quotaOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, []Decision{{
+			Source:     SourceCGroup,
+			Quota:      2.5,
+			Status:     iruntime.CPUQuotaUsed,
+			GOMAXPROCS: 2,
+			Previous:   prev,
+		}}, decisions)
+	})
+
+	t.Run("EnvVariable", func(t *testing.T) {
+		decisions = nil
+		withMax(t, 42, func() {
+			prev := currentMaxProcs()
+			undo, err := Set(hookOpt)
+			defer undo()
+			require.NoError(t, err, "Set failed")
+			assert.Equal(t, []Decision{{
+				Source:     SourceEnv,
+				Quota:      -1,
+				Status:     iruntime.CPUQuotaUndefined,
+				GOMAXPROCS: prev,
+				Previous:   prev,
+			}}, decisions)
+		})
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		decisions = nil
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 0, iruntime.CPUQuotaUndefined, errors.New("failed")
+		})
+		undo, err := Set(hookOpt, opt)
+		defer undo()
+		require.Error(t, err, "Set should have failed")
+		require.Len(t, decisions, 1, "should report failures")
+		assert.Equal(t, SourceNumCPU, decisions[0].Source)
+		assert.Equal(t, err, decisions[0].Err)
+	})
+
+	t.Run("InvalidOption", func(t *testing.T) {
+		decisions = nil
+		undo, err := Set(hookOpt, Min(0))
+		defer undo()
+		require.Error(t, err, "Set should have failed")
+		require.Len(t, decisions, 1, "should report failures")
+		assert.Equal(t, err, decisions[0].Err)
+	})
+}
+
+func TestWarningHandler(t *testing.T) {
+	var warnings []string
+	warnOpt := WarningHandler(func(msg string) {
+		warnings = append(warnings, msg)
+	})
+
+	t.Run("Warnings", func(t *testing.T) {
+		warnings = nil
+		buf, logOpt := testLogger()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 8, iruntime.CPUQuotaSharesUsed, nil
+		})
+		gVisorOpt := optionFunc(func(cfg *config) {
+			cfg.isGVisor = func() bool { return true }
+		})
+		undo, err := Set(logOpt, warnOpt, quotaOpt, gVisorOpt, Max(4))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, []string{
+			"maxprocs: Running under gVisor, CPU quota detection may be limited",
+			"maxprocs: Capping GOMAXPROCS=8 to maximum allowed GOMAXPROCS=4",
+			"maxprocs: Updating GOMAXPROCS=4: estimated from CPU shares",
+		}, warnings)
+		assert.Empty(t, buf.String(), "warnings shouldn't be logged")
+	})
+
+	t.Run("Info", func(t *testing.T) {
+		warnings = nil
+		buf, logOpt := testLogger()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 2, iruntime.CPUQuotaUsed, nil
+		})
+		undo, err := Set(logOpt, warnOpt, quotaOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Empty(t, warnings, "info shouldn't be a warning")
+		assert.Equal(t, "maxprocs: Updating GOMAXPROCS=2: determined from CPU quota", buf.String())
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 8, iruntime.CPUQuotaUsed, nil
+		})
+		undo, err := Set(logOpt, quotaOpt, Max(4))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Contains(t, buf.String(), "Capping GOMAXPROCS=8 to maximum allowed GOMAXPROCS=4", "warnings should be logged by default")
+	})
+}
+
+func TestBelowOneCPU(t *testing.T) {
+	var warnings []string
+	warnOpt := WarningHandler(func(msg string) {
+		warnings = append(warnings, msg)
+	})
+	var decisions []Decision
+	hookOpt := DecisionHook(func(d Decision) {
+		decisions = append(decisions, d)
+	})
+	quotaOpt := func(quota float64) Option {
+		return stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			procs, status := iruntime.QuotaToGOMAXPROCS(quota, min, round)
+			return procs, status, nil
+		})
+	}
+
+	t.Run("SubCPU", func(t *testing.T) {
+		warnings, decisions = nil, nil
+		undo, err := Set(warnOpt, hookOpt, quotaOpt(0.5))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 1, currentMaxProcs())
+		assert.Equal(t, []string{
+			"maxprocs: CPU quota of 0.5 cores is below one CPU, GOMAXPROCS=1 overcommits it; check the CPU limit",
+		}, warnings)
+		require.Len(t, decisions, 1)
+		assert.True(t, decisions[0].BelowOneCPU, "decision should flag a sub-CPU quota")
+	})
+
+	t.Run("MinClamp", func(t *testing.T) {
+		warnings, decisions = nil, nil
+		undo, err := Set(warnOpt, hookOpt, quotaOpt(1.5), Min(2))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 2, currentMaxProcs())
+		assert.Empty(t, warnings, "min clamp of a quota of a CPU or more shouldn't warn")
+		require.Len(t, decisions, 1)
+		assert.Equal(t, iruntime.CPUQuotaMinUsed, decisions[0].Status)
+		assert.False(t, decisions[0].BelowOneCPU)
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		warnings, decisions = nil, nil
+		undo, err := Set(warnOpt, hookOpt, stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		}))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Empty(t, warnings)
+		require.Len(t, decisions, 1)
+		assert.False(t, decisions[0].BelowOneCPU)
+	})
+}
+
+func TestTargetProcs(t *testing.T) {
+	prev := runtime.GOMAXPROCS(4)
+	defer runtime.GOMAXPROCS(prev)
+
+	quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return 6, iruntime.CPUQuotaUsed, nil
+	})
+	undefinedOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return -1, iruntime.CPUQuotaUndefined, nil
+	})
+
+	tests := []struct {
+		name        string
+		currentUtil float64
+		targetUtil  float64
+		opts        []Option
+		want        int
+	}{
+		{name: "up", currentUtil: 0.75, targetUtil: 0.5, opts: []Option{quotaOpt}, want: 6},
+		{name: "up capped at quota", currentUtil: 1, targetUtil: 0.5, opts: []Option{quotaOpt}, want: 6},
+		{name: "up without quota", currentUtil: 1, targetUtil: 0.5, opts: []Option{undefinedOpt}, want: 8},
+		{name: "up capped at max", currentUtil: 1, targetUtil: 0.5, opts: []Option{undefinedOpt, Max(7)}, want: 7},
+		{name: "down", currentUtil: 0.25, targetUtil: 0.5, opts: []Option{quotaOpt}, want: 2},
+		{name: "down capped at min", currentUtil: 0.1, targetUtil: 0.5, opts: []Option{quotaOpt}, want: 1},
+		{name: "down capped at custom min", currentUtil: 0.25, targetUtil: 0.5, opts: []Option{quotaOpt, Min(3)}, want: 3},
+		{name: "no target", currentUtil: 0.5, targetUtil: 0, opts: []Option{quotaOpt}, want: 4},
+		{name: "invalid min ignored", currentUtil: 0.1, targetUtil: 0.5, opts: []Option{quotaOpt, Min(0)}, want: 1},
+		{name: "invalid max ignored", currentUtil: 1, targetUtil: 0.5, opts: []Option{undefinedOpt, Max(0)}, want: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, TargetProcs(tt.currentUtil, tt.targetUtil, tt.opts...))
+			assert.Equal(t, 4, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		})
+	}
+}
+
+func TestSetFromEnvOrCGroup(t *testing.T) {
+	quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return 42, iruntime.CPUQuotaUsed, nil
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		withMax(t, 7, func() {
+			prev := currentMaxProcs()
+			undo, source, err := SetFromEnvOrCGroup(quotaOpt)
+			defer undo()
+			require.NoError(t, err, "SetFromEnvOrCGroup failed")
+			assert.Equal(t, SourceEnv, source)
+			assert.Equal(t, prev, currentMaxProcs(), "should leave GOMAXPROCS to the environment")
+		})
+	})
+
+	t.Run("CGroup", func(t *testing.T) {
+		undo, source, err := SetFromEnvOrCGroup(quotaOpt)
+		defer undo()
+		require.NoError(t, err, "SetFromEnvOrCGroup failed")
+		assert.Equal(t, SourceCGroup, source)
+		assert.Equal(t, 42, currentMaxProcs(), "should change GOMAXPROCS to match quota")
+	})
+
+	for _, env := range []string{"", "abc", "0", "-1"} {
+		t.Run(fmt.Sprintf("InvalidEnv%q", env), func(t *testing.T) {
+			t.Setenv(_maxProcsKey, env)
+			buf, logOpt := testLogger()
+			undo, source, err := SetFromEnvOrCGroup(logOpt, quotaOpt)
+			defer undo()
+			require.NoError(t, err, "SetFromEnvOrCGroup failed")
+			assert.Equal(t, SourceCGroup, source, "should ignore GOMAXPROCS")
+			assert.Equal(t, 42, currentMaxProcs(), "should change GOMAXPROCS to match quota")
+			assert.Contains(t, buf.String(), fmt.Sprintf("Ignoring invalid GOMAXPROCS=%q in environment", env), "unexpected log output")
+		})
+	}
+
+	t.Run("NumCPU", func(t *testing.T) {
+		undefinedOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		prev := currentMaxProcs()
+		undo, source, err := SetFromEnvOrCGroup(undefinedOpt)
+		defer undo()
+		require.NoError(t, err, "SetFromEnvOrCGroup failed")
+		assert.Equal(t, SourceNumCPU, source)
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+}
+
+func TestSetResult(t *testing.T) {
+	t.Run("Quota", func(t *testing.T) {
+		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			procs, status := iruntime.QuotaToGOMAXPROCS(3.5, min, round)
+			return procs, status, nil
+		})
+		prev := currentMaxProcs()
+		result, err := SetResult(quotaOpt)
+		require.NoError(t, err, "SetResult failed")
+		assert.Equal(t, 3, currentMaxProcs(), "should change GOMAXPROCS to match quota")
+		assert.Equal(t, 3, result.GOMAXPROCS)
+		assert.Equal(t, prev, result.Previous)
+		assert.Equal(t, detect.Quota, result.Status)
+		assert.Equal(t, SourceCGroup, result.Source)
+		assert.Equal(t, 3.5, result.Quota)
+
+		result.Undo()
+		assert.Equal(t, prev, currentMaxProcs(), "Undo should reset GOMAXPROCS")
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		withMax(t, 7, func() {
+			prev := currentMaxProcs()
+			result, err := SetResult()
+			defer result.Undo()
+			require.NoError(t, err, "SetResult failed")
+			assert.Equal(t, prev, result.GOMAXPROCS, "should leave GOMAXPROCS to the environment")
+			assert.Equal(t, prev, result.Previous)
+			assert.Equal(t, detect.Undefined, result.Status)
+			assert.Equal(t, SourceEnv, result.Source)
+			assert.Equal(t, -1.0, result.Quota)
+		})
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		prev := currentMaxProcs()
+		errOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 0, iruntime.CPUQuotaUndefined, errors.New("failed")
+		})
+		result, err := SetResult(errOpt)
+		defer result.Undo()
+		require.Error(t, err, "SetResult should have failed")
+		assert.Equal(t, prev, result.GOMAXPROCS, "shouldn't alter GOMAXPROCS")
+		assert.Equal(t, SourceNumCPU, result.Source)
+	})
+
+	t.Run("ZeroValue", func(t *testing.T) {
+		assert.NotPanics(t, Result{}.Undo)
+	})
+}
+
+func TestQuotaProvider(t *testing.T) {
+	detected := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return 6, iruntime.CPUQuotaUsed, nil
+	})
+	provider := func(quota float64, status detect.Status, err error) Option {
+		return QuotaProvider(func() (float64, detect.Status, error) {
+			return quota, status, err
+		})
+	}
+
+	t.Run("Quota", func(t *testing.T) {
+		result, err := SetResult(detected, provider(2.5, detect.Quota, nil), RoundUpAnyFraction())
+		defer result.Undo()
+		require.NoError(t, err, "SetResult failed")
+		assert.Equal(t, 3, currentMaxProcs(), "should use the provided quota")
+		assert.Equal(t, detect.Quota, result.Status)
+		assert.Equal(t, 2.5, result.Quota)
+		assert.Equal(t, SourceCGroup, result.Source)
+	})
+
+	t.Run("Min", func(t *testing.T) {
+		undo, err := Set(detected, provider(0.5, detect.Quota, nil), Min(2))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 2, currentMaxProcs(), "should apply the minimum")
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		undo, err := Set(detected, provider(-1, detect.Undefined, nil))
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, 6, currentMaxProcs(), "should fall back to detection")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		prev := currentMaxProcs()
+		giveErr := errors.New("great sadness")
+		undo, err := Set(detected, provider(4, detect.Quota, giveErr))
+		defer undo()
+		assert.ErrorIs(t, err, giveErr)
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+
+	t.Run("Detect", func(t *testing.T) {
+		procs, status, err := Detect(detected, provider(4, detect.Shares, nil))
+		require.NoError(t, err)
+		assert.Equal(t, 4, procs)
+		assert.Equal(t, detect.Shares, status, "should keep the provided status")
+	})
+}
+
+func TestCPUCGroupPath(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cgroups are only supported on Linux")
+	}
+
+	prev := currentMaxProcs()
+	undo, err := Set(CPUCGroupPath(filepath.Join(t.TempDir(), "missing")))
+	defer undo()
+	require.Error(t, err, "Set should have failed")
+	assert.Contains(t, err.Error(), "no cpu.cfs_quota_us in", "unexpected error")
+	assert.ErrorIs(t, err, ErrCGroupsNotMounted)
+	assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+}
+
+func TestSetErrors(t *testing.T) {
+	parseOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return -1, iruntime.CPUQuotaUndefined, &ParseError{Err: errors.New("invalid cpu.max")}
+	})
+
+	t.Run("ParseError", func(t *testing.T) {
+		undo, err := Set(parseOpt)
+		defer undo()
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.NotErrorIs(t, err, ErrCGroupsNotMounted)
+		assert.NotErrorIs(t, err, ErrCGroupsUnavailable)
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		withMax(t, 3, func() {
+			undo, err := Set(parseOpt)
+			defer undo()
+			assert.NoError(t, err, "the environment should take precedence")
+		})
+	})
+}
+
+func TestValidateCGroupPath(t *testing.T) {
+	err := ValidateCGroupPath(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid cgroup path")
+}
+
+func TestDetect(t *testing.T) {
+	quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		procs, status := iruntime.QuotaToGOMAXPROCS(2.5, min, round)
+		return procs, status, nil
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		prev := currentMaxProcs()
+		procs, status, err := Detect(quotaOpt)
+		require.NoError(t, err)
+		assert.Equal(t, 2, procs)
+		assert.Equal(t, iruntime.CPUQuotaUsed, status)
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		procs, status, err := Detect(quotaOpt, RoundUpAnyFraction(), Min(4))
+		require.NoError(t, err)
+		assert.Equal(t, 4, procs)
+		assert.Equal(t, iruntime.CPUQuotaMinUsed, status)
+
+		procs, _, err = Detect(quotaOpt, RoundUpAnyFraction(), Max(2))
+		require.NoError(t, err)
+		assert.Equal(t, 2, procs)
+	})
+
+	t.Run("EnvVariable", func(t *testing.T) {
+		t.Setenv(_maxProcsKey, "42")
+		procs, _, err := Detect(quotaOpt)
+		require.NoError(t, err)
+		assert.Equal(t, 2, procs, "should ignore GOMAXPROCS")
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		procs, status, err := Detect(opt)
+		require.NoError(t, err)
+		assert.Equal(t, -1, procs)
+		assert.Equal(t, iruntime.CPUQuotaUndefined, status)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 0, iruntime.CPUQuotaUndefined, errors.New("failed")
+		})
+		_, _, err := Detect(opt)
+		require.Error(t, err)
+	})
+}
+
+func TestIsAligned(t *testing.T) {
+	quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return 3, iruntime.CPUQuotaUsed, nil
+	})
+
+	t.Run("Aligned", func(t *testing.T) {
+		undo, err := Set(quotaOpt)
+		require.NoError(t, err, "Set failed")
+		defer undo()
+
+		aligned, err := IsAligned(quotaOpt)
+		require.NoError(t, err)
+		assert.True(t, aligned)
+	})
+
+	t.Run("Diverged", func(t *testing.T) {
+		undo, err := Set(quotaOpt)
+		require.NoError(t, err, "Set failed")
+		defer undo()
+
+		prev := runtime.GOMAXPROCS(7)
+		defer runtime.GOMAXPROCS(prev)
+
+		buf, logOpt := testLogger()
+		aligned, err := IsAligned(logOpt, quotaOpt)
+		require.NoError(t, err)
+		assert.False(t, aligned, "GOMAXPROCS was changed after Set")
+		assert.Empty(t, buf.String(), "shouldn't log")
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		withMax(t, 7, func() {
+			aligned, err := IsAligned(quotaOpt)
+			require.NoError(t, err)
+			assert.True(t, aligned, "GOMAXPROCS from the environment is intentional")
+		})
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		undefinedOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		aligned, err := IsAligned(undefinedOpt)
+		require.NoError(t, err)
+		assert.True(t, aligned)
+	})
+
+	t.Run("SchedulerEnvFallback", func(t *testing.T) {
+		t.Setenv("MESOS_CPU", "3")
+		undefinedOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		numCPUOpt := optionFunc(func(cfg *config) {
+			cfg.numCPU = func() int { return 8 }
+		})
+		opts := []Option{undefinedOpt, numCPUOpt, SchedulerEnvFallback()}
+
+		undo, err := Set(opts...)
+		require.NoError(t, err, "Set failed")
+		defer undo()
+		aligned, err := IsAligned(opts...)
+		require.NoError(t, err)
+		assert.True(t, aligned)
+
+		prev := runtime.GOMAXPROCS(7)
+		defer runtime.GOMAXPROCS(prev)
+		aligned, err = IsAligned(opts...)
+		require.NoError(t, err)
+		assert.False(t, aligned, "GOMAXPROCS drifted from the scheduler allocation")
+	})
+
+	t.Run("UseOnlineCPUs", func(t *testing.T) {
+		undefinedOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		onlineOpt := optionFunc(func(cfg *config) {
+			cfg.numCPU = func() int { return 5 }
+		})
+		opts := []Option{undefinedOpt, onlineOpt, UseOnlineCPUs()}
+
+		undo, err := Set(opts...)
+		require.NoError(t, err, "Set failed")
+		defer undo()
+		aligned, err := IsAligned(opts...)
+		require.NoError(t, err)
+		assert.True(t, aligned)
+
+		prev := runtime.GOMAXPROCS(7)
+		defer runtime.GOMAXPROCS(prev)
+		aligned, err = IsAligned(opts...)
+		require.NoError(t, err)
+		assert.False(t, aligned, "GOMAXPROCS drifted from the online CPUs")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		errOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 0, iruntime.CPUQuotaUndefined, errors.New("failed")
+		})
+		aligned, err := IsAligned(errOpt)
+		require.Error(t, err)
+		assert.False(t, aligned)
+	})
+}
+
+func TestCPUQuota(t *testing.T) {
+	stubCPUQuota := func(quota float64, status iruntime.CPUQuotaStatus, err error) Option {
+		return optionFunc(func(cfg *config) {
+			cfg.cpuQuota = func() (float64, iruntime.CPUQuotaStatus, error) { return quota, status, err }
+		})
+	}
+
+	t.Run("Quota", func(t *testing.T) {
+		quota, status, err := CPUQuota(stubCPUQuota(3.5, iruntime.CPUQuotaUsed, nil))
+		require.NoError(t, err)
+		assert.Equal(t, 3.5, quota, "shouldn't round the quota")
+		assert.Equal(t, iruntime.CPUQuotaUsed, status)
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		quota, status, err := CPUQuota(stubCPUQuota(-1, iruntime.CPUQuotaUndefined, nil))
+		require.NoError(t, err)
+		assert.Zero(t, quota)
+		assert.Equal(t, iruntime.CPUQuotaUndefined, status)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		quota, status, err := CPUQuota(stubCPUQuota(-1, iruntime.CPUQuotaUndefined, errors.New("failed")))
+		require.Error(t, err)
+		assert.Zero(t, quota)
+		assert.Equal(t, iruntime.CPUQuotaUndefined, status)
+	})
+
+	t.Run("MissingProcFS", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("procfs is only read on Linux")
+		}
+		quota, status, err := CPUQuota(ProcFS(t.TempDir()))
+		assert.ErrorIs(t, err, ErrCGroupsNotMounted)
+		assert.Zero(t, quota)
+		assert.Equal(t, iruntime.CPUQuotaUndefined, status)
+	})
+}
+
+func TestQuery(t *testing.T) {
+	stubQuotaPeriod := func(quota, period, version int, err error) Option {
+		return optionFunc(func(cfg *config) {
+			cfg.quotaPeriod = func() (int, int, int, error) { return quota, period, version, err }
+		})
+	}
+
+	t.Run("V1", func(t *testing.T) {
+		info, err := Query(stubQuotaPeriod(250000, 100000, 1, nil))
+		require.NoError(t, err)
+		assert.Equal(t, QuotaInfo{
+			CGroupVersion: 1,
+			Quota:         250 * time.Millisecond,
+			Period:        100 * time.Millisecond,
+			CPUs:          2.5,
+			GOMAXPROCS:    2,
+			Status:        iruntime.CPUQuotaUsed,
+		}, info)
+	})
+
+	t.Run("V2RoundedUp", func(t *testing.T) {
+		info, err := Query(stubQuotaPeriod(150000, 100000, 2, nil), RoundUpAnyFraction())
+		require.NoError(t, err)
+		assert.Equal(t, 2, info.CGroupVersion)
+		assert.Equal(t, 1.5, info.CPUs)
+		assert.Equal(t, 2, info.GOMAXPROCS)
+		assert.Equal(t, iruntime.CPUQuotaUsed, info.Status)
+	})
+
+	t.Run("MinUsed", func(t *testing.T) {
+		info, err := Query(stubQuotaPeriod(50000, 100000, 2, nil), Min(2))
+		require.NoError(t, err)
+		assert.Equal(t, 2, info.GOMAXPROCS)
+		assert.Equal(t, iruntime.CPUQuotaMinUsed, info.Status)
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		info, err := Query(stubQuotaPeriod(-1, -1, 2, nil))
+		require.NoError(t, err)
+		assert.Equal(t, QuotaInfo{CGroupVersion: 2, Status: iruntime.CPUQuotaUndefined}, info)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		_, err := Query(stubQuotaPeriod(-1, -1, 0, errors.New("failed")))
+		require.Error(t, err)
+	})
+
+	t.Run("MissingProcFS", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("procfs is only read on Linux")
+		}
+		info, err := Query(ProcFS(t.TempDir()))
+		assert.ErrorIs(t, err, ErrCGroupsNotMounted)
+		assert.Equal(t, QuotaInfo{}, info)
+	})
+}
+
+func TestCGroupVersion(t *testing.T) {
+	version, err := CGroupVersion()
+	if runtime.GOOS != "linux" {
+		assert.ErrorIs(t, err, ErrCGroupsUnavailable)
+		return
+	}
+	require.NoError(t, err)
+	assert.Contains(t, []int{0, 1, 2, CGroupHybrid}, version)
+}
+
+func TestDetectRuntime(t *testing.T) {
+	name, err := DetectRuntime()
+	require.NoError(t, err)
+	assert.Contains(t, []string{
+		"docker", "containerd", "cri-o", "kubernetes", "systemd",
+		"lxc", "nomad", "mesos", "unknown",
+	}, name)
+}
+
+func TestSourceString(t *testing.T) {
+	assert.Equal(t, "numcpu", SourceNumCPU.String())
+	assert.Equal(t, "env", SourceEnv.String())
+	assert.Equal(t, "cgroup", SourceCGroup.String())
+	assert.Equal(t, "Source(42)", Source(42).String())
+}
+
+type kvEntry struct {
+	msg     string
+	keyvals []interface{}
+}
+
+type testKVLogger struct {
+	entries []kvEntry
+}
+
+func (l *testKVLogger) Log(msg string, keyvals ...interface{}) {
+	l.entries = append(l.entries, kvEntry{msg: msg, keyvals: keyvals})
+}
+
+func TestStructuredLogger(t *testing.T) {
+	quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		procs, status := iruntime.QuotaToGOMAXPROCS(4, min, round)
+		return procs, status, nil
+	})
+
+	t.Run("decision fields", func(t *testing.T) {
+		var logger testKVLogger
+		prev := currentMaxProcs()
+		undo, err := Set(quotaOpt, StructuredLogger(&logger))
+		require.NoError(t, err, "Set failed")
+		defer undo()
+
+		require.Len(t, logger.entries, 1)
+		assert.Equal(t, "maxprocs: Updating GOMAXPROCS=4: determined from CPU quota", logger.entries[0].msg)
+		assert.Equal(t, []interface{}{
+			"gomaxprocs", 4,
+			"previous", prev,
+			"quota", 4.0,
+			"status", "quota",
+			"source", "cgroup",
+		}, logger.entries[0].keyvals)
+	})
+
+	t.Run("precedence over printf", func(t *testing.T) {
+		var logger testKVLogger
+		buf, logOpt := testLogger()
+		undo, err := Set(logOpt, quotaOpt, StructuredLogger(&logger))
+		require.NoError(t, err, "Set failed")
+		defer undo()
+
+		assert.Empty(t, buf.String(), "printf logger shouldn't be used")
+		assert.Len(t, logger.entries, 1)
+	})
+
+	t.Run("held back with context", func(t *testing.T) {
+		var logger testKVLogger
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		undefinedOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		gVisorOpt := optionFunc(func(cfg *config) {
+			cfg.isGVisor = func() bool { return true }
+		})
+		undo, err := SetContext(ctx, undefinedOpt, gVisorOpt, StructuredLogger(&logger))
+		require.NoError(t, err, "SetContext failed")
+		defer undo()
+
+		require.Len(t, logger.entries, 2)
+		assert.Equal(t, "maxprocs: Running under gVisor, CPU quota detection may be limited", logger.entries[0].msg)
+		assert.Empty(t, logger.entries[0].keyvals)
+		assert.Contains(t, logger.entries[1].msg, "CPU quota undefined")
+		assert.Contains(t, logger.entries[1].keyvals, "numcpu")
+	})
+}
+
+func TestSetAsync(t *testing.T) {
+	prev := currentMaxProcs()
+
+	opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		return 42, iruntime.CPUQuotaUsed, nil
+	})
+
+	select {
+	case res := <-SetAsync(opt):
+		require.NoError(t, res.Err, "SetAsync failed")
+		assert.Equal(t, 42, currentMaxProcs(), "should change GOMAXPROCS to match quota")
+		res.Undo()
+		assert.Equal(t, prev, currentMaxProcs(), "didn't undo GOMAXPROCS changes")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for SetAsync")
+	}
+}
+
+func TestSetContext(t *testing.T) {
+	t.Run("Done", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 42, iruntime.CPUQuotaUsed, nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		undo, err := SetContext(ctx, opt, Max(8), logOpt)
+		defer undo()
+		require.NoError(t, err, "SetContext failed")
+		assert.Equal(t, 8, currentMaxProcs(), "should change GOMAXPROCS to match quota")
+		assert.Equal(t,
+			"maxprocs: Capping GOMAXPROCS=42 to maximum allowed GOMAXPROCS=8"+
+				"maxprocs: Updating GOMAXPROCS=8: determined from CPU quota",
+			buf.String(), "unexpected log output")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		prev := currentMaxProcs()
+		release := make(chan struct{})
+		defer close(release)
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			<-release
+			return 42, iruntime.CPUQuotaUsed, nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		undo, err := SetContext(ctx, opt, Max(8), logOpt)
+		defer undo()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		assert.NotContains(t, buf.String(), "Capping", "abandoned detection shouldn't log")
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		prev := currentMaxProcs()
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			t.Error("shouldn't detect the CPU quota")
+			return 42, iruntime.CPUQuotaUsed, nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		undo, err := SetContext(ctx, opt)
+		defer undo()
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+}
+
+func TestSetUntil(t *testing.T) {
+	t.Run("Cancel", func(t *testing.T) {
+		prev := currentMaxProcs()
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 42, iruntime.CPUQuotaUsed, nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		require.NoError(t, SetUntil(ctx, opt), "SetUntil failed")
+		assert.Equal(t, 42, currentMaxProcs(), "should change GOMAXPROCS to match quota")
+
+		cancel()
+		assert.Eventually(t, func() bool {
+			return currentMaxProcs() == prev
+		}, time.Second, time.Millisecond, "didn't undo GOMAXPROCS changes")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		prev := currentMaxProcs()
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 0, iruntime.CPUQuotaUndefined, errors.New("failed")
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.Error(t, SetUntil(ctx, opt), "SetUntil should have failed")
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+	})
+}
+
+func TestWatch(t *testing.T) {
+	// runWatch feeds one tick per quota to watch, where a negative quota is
+	// undefined, and waits for it to return.
+	runWatch := func(t *testing.T, quotas []int, opts ...Option) {
+		prev := runtime.GOMAXPROCS(2)
+		t.Cleanup(func() { runtime.GOMAXPROCS(prev) })
+
+		reads := 0
+		opts = append(opts, stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			quota := quotas[reads]
+			reads++
+			if quota < 0 {
+				return -1, iruntime.CPUQuotaUndefined, nil
+			}
+			return quota, iruntime.CPUQuotaUsed, nil
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ticks := make(chan time.Time)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			watch(ctx, newConfig(opts), ticks)
+		}()
+		for range quotas {
+			ticks <- time.Time{}
+		}
+		cancel()
+		<-done
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		runWatch(t, []int{2, 2, 2}, logOpt)
+		assert.Equal(t, 2, currentMaxProcs())
+		assert.Empty(t, buf.String(), "shouldn't log without changes")
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		runWatch(t, []int{4, 4}, logOpt)
+		assert.Equal(t, 4, currentMaxProcs(), "should apply the new quota")
+		assert.Equal(t, "maxprocs: Updating GOMAXPROCS=4: CPU quota changed, was 2", buf.String())
+	})
+
+	t.Run("NotObservedForFullInterval", func(t *testing.T) {
+		runWatch(t, []int{2, 4})
+		assert.Equal(t, 2, currentMaxProcs(), "shouldn't apply a quota read once")
+	})
+
+	t.Run("Flapping", func(t *testing.T) {
+		runWatch(t, []int{4, 6, 4, 6})
+		assert.Equal(t, 2, currentMaxProcs(), "shouldn't apply a flapping quota")
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		runWatch(t, []int{4, 4, 4, 4}, logOpt, DryRun())
+		assert.Equal(t, 2, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		assert.Equal(t, "maxprocs: Dry run, leaving GOMAXPROCS=2: CPU quota changed to 4", buf.String(), "should log once")
+	})
+
+	t.Run("Undefined", func(t *testing.T) {
+		runWatch(t, []int{-1, -1})
+		assert.Equal(t, 2, currentMaxProcs(), "should leave GOMAXPROCS alone")
+	})
+
+	numCPUOpt := optionFunc(func(cfg *config) {
+		cfg.numCPU = func() int { return 6 }
+	})
+
+	t.Run("QuotaRemoved", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		runWatch(t, []int{2, -1, -1}, logOpt, numCPUOpt)
+		assert.Equal(t, 6, currentMaxProcs(), "should go back to the number of CPUs")
+		assert.Equal(t, "maxprocs: Updating GOMAXPROCS=6: CPU quota removed, was 2", buf.String())
+	})
+
+	t.Run("QuotaRemovedOnce", func(t *testing.T) {
+		runWatch(t, []int{2, -1}, numCPUOpt)
+		assert.Equal(t, 2, currentMaxProcs(), "shouldn't apply a removal read once")
+	})
+
+	t.Run("QuotaRemovedFallback", func(t *testing.T) {
+		t.Setenv("MESOS_CPU", "3")
+		runWatch(t, []int{2, -1, -1}, numCPUOpt, SchedulerEnvFallback())
+		assert.Equal(t, 3, currentMaxProcs(), "should go back to the scheduler allocation")
+	})
+
+	t.Run("QuotaRemovedDryRun", func(t *testing.T) {
+		buf, logOpt := testLogger()
+		runWatch(t, []int{2, -1, -1, -1}, logOpt, numCPUOpt, DryRun())
+		assert.Equal(t, 2, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		assert.Equal(t, "maxprocs: Dry run, leaving GOMAXPROCS=2: CPU quota removed, would set 6", buf.String(), "should log once")
+	})
+
+	t.Run("Capped", func(t *testing.T) {
+		runWatch(t, []int{8, 8}, Max(3))
+		assert.Equal(t, 3, currentMaxProcs(), "should honor Max")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		var warnings []string
+		warnOpt := WarningHandler(func(msg string) {
+			warnings = append(warnings, msg)
+		})
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return 0, iruntime.CPUQuotaUndefined, errors.New("failed")
+		})
+		prev := currentMaxProcs()
+		ctx, cancel := context.WithCancel(context.Background())
+		ticks := make(chan time.Time)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			watch(ctx, newConfig([]Option{warnOpt, opt}), ticks)
+		}()
+		ticks <- time.Time{}
+		ticks <- time.Time{}
+		cancel()
+		<-done
+		assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+		assert.Equal(t, []string{
+			"maxprocs: Failed to re-read CPU quota: failed",
+			"maxprocs: Failed to re-read CPU quota: failed",
+		}, warnings)
+	})
+
+	t.Run("InvalidInterval", func(t *testing.T) {
+		err := Watch(context.Background(), 0)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid watch interval")
+	})
+
+	t.Run("EnvVariable", func(t *testing.T) {
+		t.Setenv(_maxProcsKey, "42")
+		require.NoError(t, Watch(context.Background(), time.Millisecond), "should return right away")
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		opt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return currentMaxProcs(), iruntime.CPUQuotaUsed, nil
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.NoError(t, Watch(ctx, time.Millisecond, opt), "Watch failed")
+	})
+}
+
+func TestRoundUpAnyFraction(t *testing.T) {
+	tests := []struct {
+		quota float64
+		want  int
+	}{
+		{quota: 1.0, want: 1},
+		{quota: 1.01, want: 2},
+		{quota: 2.99, want: 3},
+		{quota: 3.0, want: 3},
+	}
+
+	cfg := &config{}
+	RoundUpAnyFraction().apply(cfg)
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, cfg.roundQuotaFunc(tt.quota), "quota %v", tt.quota)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	newGauges := func() (map[string]float64, Option) {
+		gauges := make(map[string]float64)
+		return gauges, GaugeFunc(func(name string, value float64) {
+			_, dup := gauges[name]
+			assert.False(t, dup, "gauge %q reported twice", name)
+			gauges[name] = value
+		})
+	}
+
+	t.Run("QuotaUsed", func(t *testing.T) {
+		gauges, gaugeOpt := newGauges()
+		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return round(2.5), iruntime.CPUQuotaUsed, nil
+		})
+		undo, err := Set(gaugeOpt, quotaOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, map[string]float64{
+			"automaxprocs_quota_cores": 2.5,
+			"automaxprocs_gomaxprocs":  2,
+		}, gauges)
+	})
+
+	t.Run("QuotaUndefined", func(t *testing.T) {
+		gauges, gaugeOpt := newGauges()
+		quotaOpt := stubProcs(func(int, func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return -1, iruntime.CPUQuotaUndefined, nil
+		})
+		undo, err := Set(gaugeOpt, quotaOpt)
+		defer undo()
+		require.NoError(t, err, "Set failed")
+		assert.Equal(t, map[string]float64{
+			"automaxprocs_gomaxprocs": float64(currentMaxProcs()),
+		}, gauges)
+	})
+
+	t.Run("EnvVarPresent", func(t *testing.T) {
+		withMax(t, 42, func() {
+			gauges, gaugeOpt := newGauges()
+			undo, err := Set(gaugeOpt)
+			defer undo()
+			require.NoError(t, err, "Set failed")
+			assert.Equal(t, map[string]float64{
+				"automaxprocs_gomaxprocs": float64(currentMaxProcs()),
+			}, gauges)
+		})
+	})
+
+	physMemOpt := optionFunc(func(cfg *config) {
+		cfg.physMem = func() (uint64, bool) { return 8 << 30, true }
+	})
+
+	t.Run("MemoryLimit", func(t *testing.T) {
+		gauges, gaugeOpt := newGauges()
+		undo, _, err := SetMemoryLimit(gaugeOpt, physMemOpt, stubMemLimit(2<<30, true, nil), MemoryLimitReserve(25))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.Equal(t, map[string]float64{
+			"automaxprocs_gomaxprocs":      float64(currentMaxProcs()),
+			"automaxprocs_mem_limit_bytes": 3 << 29,
+		}, gauges)
+	})
+
+	t.Run("MemoryLimitUndefined", func(t *testing.T) {
+		gauges, gaugeOpt := newGauges()
+		undo, _, err := SetMemoryLimit(gaugeOpt, physMemOpt, stubMemLimit(0, false, nil))
+		defer undo()
+		require.NoError(t, err, "SetMemoryLimit failed")
+		assert.NotContains(t, gauges, "automaxprocs_mem_limit_bytes")
+	})
+
+	t.Run("SetAll", func(t *testing.T) {
+		gauges, gaugeOpt := newGauges()
+		quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+			return round(2.5), iruntime.CPUQuotaUsed, nil
+		})
+		undo, _, err := SetAll(gaugeOpt, quotaOpt, physMemOpt, stubMemLimit(2<<30, true, nil), MemoryLimitReserve(0))
+		defer undo()
+		require.NoError(t, err, "SetAll failed")
+		assert.Equal(t, map[string]float64{
+			"automaxprocs_quota_cores":     2.5,
+			"automaxprocs_gomaxprocs":      2,
+			"automaxprocs_mem_limit_bytes": 2 << 30,
+		}, gauges)
+	})
+}
+
+func TestFromMillicores(t *testing.T) {
+	prev := currentMaxProcs()
+
+	assert.Equal(t, 1, FromMillicores(1500), "should round 1.5 cores down")
+	assert.Equal(t, 2, FromMillicores(1500, RoundUpAnyFraction()), "should round 1.5 cores up")
+	assert.Equal(t, 4, FromMillicores(4000), "whole cores")
+	assert.Equal(t, 1, FromMillicores(250), "should use the default minimum")
+	assert.Equal(t, 2, FromMillicores(250, Min(2)), "should use the configured minimum")
+	assert.Equal(t, 8, FromMillicores(64000, Max(8)), "should cap at the configured maximum")
+	assert.Equal(t, 1, FromMillicores(250, Min(0)), "should ignore an invalid minimum")
+	assert.Equal(t, 64, FromMillicores(64000, Max(0)), "should ignore an invalid maximum")
+
+	assert.Equal(t, prev, currentMaxProcs(), "shouldn't alter GOMAXPROCS")
+}
+
 func TestMain(m *testing.M) {
 	if err := os.Unsetenv(_maxProcsKey); err != nil {
 		log.Fatalf("Couldn't clear %s: %v\n", _maxProcsKey, err)
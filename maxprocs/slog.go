@@ -0,0 +1,51 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build go1.21
+// +build go1.21
+
+package maxprocs
+
+import (
+	"context"
+	"log/slog"
+)
+
+var _slogLevels = map[logLevel]slog.Level{
+	levelDebug: slog.LevelDebug,
+	levelInfo:  slog.LevelInfo,
+	levelWarn:  slog.LevelWarn,
+}
+
+type slogLogger struct{ l *slog.Logger }
+
+func (s slogLogger) logAt(level logLevel, msg string, keyvals ...interface{}) {
+	s.l.Log(context.Background(), _slogLevels[level], msg, keyvals...)
+}
+
+// Slog sends log output to l. The value GOMAXPROCS is set to is logged at
+// Info, the raw CPU quota at Debug, and fallbacks and other warnings at
+// Warn, with the same attributes as StructuredLogger. It takes precedence
+// over StructuredLogger and Logger.
+func Slog(l *slog.Logger) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.slog = slogLogger{l}
+	})
+}
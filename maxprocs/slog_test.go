@@ -0,0 +1,96 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build go1.21
+// +build go1.21
+
+package maxprocs
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	iruntime "go.uber.org/automaxprocs/internal/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlog(t *testing.T) {
+	quotaOpt := stubProcs(func(min int, round func(v float64) int) (int, iruntime.CPUQuotaStatus, error) {
+		procs, status := iruntime.QuotaToGOMAXPROCS(4, min, round)
+		return procs, status, nil
+	})
+	newLogger := func(buf *bytes.Buffer) *slog.Logger {
+		return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}))
+	}
+
+	t.Run("levels and attributes", func(t *testing.T) {
+		var buf bytes.Buffer
+		prev := currentMaxProcs()
+		undo, err := Set(quotaOpt, Slog(newLogger(&buf)))
+		require.NoError(t, err, "Set failed")
+		defer undo()
+
+		lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+		require.Len(t, lines, 2)
+		assert.Equal(t, `level=DEBUG msg="maxprocs: Detected CPU quota of 4 cores" quota=4`, string(lines[0]))
+		assert.Contains(t, string(lines[1]), `level=INFO msg="maxprocs: Updating GOMAXPROCS=4: determined from CPU quota" gomaxprocs=4`)
+		assert.Contains(t, string(lines[1]), "quota=4 status=quota source=cgroup")
+		assert.Contains(t, string(lines[1]), fmt.Sprintf("previous=%d", prev))
+	})
+
+	t.Run("warnings", func(t *testing.T) {
+		var buf bytes.Buffer
+		gVisorOpt := optionFunc(func(cfg *config) {
+			cfg.isGVisor = func() bool { return true }
+		})
+		undo, err := Set(quotaOpt, gVisorOpt, Slog(newLogger(&buf)))
+		require.NoError(t, err, "Set failed")
+		defer undo()
+
+		assert.Contains(t, buf.String(), `level=WARN msg="maxprocs: Running under gVisor, CPU quota detection may be limited"`)
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		var (
+			buf bytes.Buffer
+			kv  testKVLogger
+		)
+		printfBuf, logOpt := testLogger()
+		undo, err := Set(logOpt, StructuredLogger(&kv), quotaOpt, Slog(newLogger(&buf)))
+		require.NoError(t, err, "Set failed")
+		defer undo()
+
+		assert.Empty(t, printfBuf.String(), "printf logger shouldn't be used")
+		assert.Empty(t, kv.entries, "StructuredLogger shouldn't be used")
+		assert.NotEmpty(t, buf.String())
+	})
+}
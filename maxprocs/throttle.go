@@ -0,0 +1,68 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package maxprocs
+
+import (
+	"time"
+
+	iruntime "go.uber.org/automaxprocs/internal/runtime"
+)
+
+var (
+	_nrThrottled = iruntime.CPUThrottledPeriods
+	_cpuStat     = iruntime.CPUThrottleStats
+)
+
+// CPUStat holds the CFS throttling counters of the calling process' CPU
+// cgroup, as reported by its `cpu.stat` file.
+type CPUStat = iruntime.CPUStat
+
+// ThrottleStats returns the CFS throttling counters of the calling process'
+// CPU cgroup, read from `cpu.stat` on cgroups v1 or v2. The counters only
+// grow; callers adapting GOMAXPROCS, e.g. alongside Watch, compare two
+// readings and may lower it when NrThrottled grows by a large share of
+// NrPeriods. The boolean is false on non-Linux systems and when the counters
+// aren't available.
+func ThrottleStats() (CPUStat, bool, error) {
+	return _cpuStat()
+}
+
+// IsThrottled reports whether the CFS scheduler throttled the calling
+// process' CPU cgroup during the given interval. It reads the cgroup's
+// `nr_throttled` counter, sleeps for interval and reads it again, so it
+// blocks for at least interval.
+//
+// IsThrottled always reports false on non-Linux systems and when the
+// throttling counter isn't available.
+func IsThrottled(interval time.Duration) (bool, error) {
+	before, ok, err := _nrThrottled()
+	if err != nil || !ok {
+		return false, err
+	}
+
+	time.Sleep(interval)
+
+	after, ok, err := _nrThrottled()
+	if err != nil || !ok {
+		return false, err
+	}
+	return after > before, nil
+}
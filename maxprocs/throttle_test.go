@@ -0,0 +1,93 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package maxprocs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stubNrThrottled(t *testing.T, f func() (uint64, bool, error)) {
+	prev := _nrThrottled
+	_nrThrottled = f
+	t.Cleanup(func() { _nrThrottled = prev })
+}
+
+func TestIsThrottled(t *testing.T) {
+	readings := func(values ...uint64) func() (uint64, bool, error) {
+		return func() (uint64, bool, error) {
+			v := values[0]
+			values = values[1:]
+			return v, true, nil
+		}
+	}
+
+	t.Run("increasing", func(t *testing.T) {
+		stubNrThrottled(t, readings(5, 9))
+		throttled, err := IsThrottled(0)
+		require.NoError(t, err)
+		assert.True(t, throttled)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		stubNrThrottled(t, readings(5, 5))
+		throttled, err := IsThrottled(0)
+		require.NoError(t, err)
+		assert.False(t, throttled)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		stubNrThrottled(t, func() (uint64, bool, error) {
+			return 0, false, nil
+		})
+		throttled, err := IsThrottled(0)
+		require.NoError(t, err)
+		assert.False(t, throttled)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		stubNrThrottled(t, func() (uint64, bool, error) {
+			return 0, false, errors.New("failed")
+		})
+		_, err := IsThrottled(0)
+		assert.EqualError(t, err, "failed")
+	})
+}
+
+func TestThrottleStats(t *testing.T) {
+	prev := _cpuStat
+	t.Cleanup(func() { _cpuStat = prev })
+
+	want := CPUStat{NrPeriods: 100, NrThrottled: 7, ThrottledTime: 250 * time.Millisecond}
+	_cpuStat = func() (CPUStat, bool, error) { return want, true, nil }
+	got, ok, err := ThrottleStats()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, want, got)
+
+	_cpuStat = func() (CPUStat, bool, error) { return CPUStat{}, false, errors.New("failed") }
+	_, _, err = ThrottleStats()
+	assert.EqualError(t, err, "failed")
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package otelmaxprocs records the GOMAXPROCS decision of the maxprocs
+// package in OpenTelemetry traces. It lives in its own module so that the
+// automaxprocs module doesn't depend on OpenTelemetry.
+package otelmaxprocs // import "go.uber.org/automaxprocs/otelmaxprocs"
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+// ScopeName is the instrumentation scope of the tracer returned by Tracer.
+const ScopeName = "go.uber.org/automaxprocs/otelmaxprocs"
+
+// SpanName is the name of the span started by Set.
+const SpanName = "automaxprocs.set"
+
+// EventName is the name of the event added by SetWithEvent.
+const EventName = "automaxprocs.set"
+
+// Attribute keys describing the GOMAXPROCS decision.
+const (
+	// QuotaKey is the CPU quota in cores. It's absent if there's no quota.
+	QuotaKey = attribute.Key("automaxprocs.quota_cores")
+	// ProcsKey is the resulting GOMAXPROCS value.
+	ProcsKey = attribute.Key("automaxprocs.gomaxprocs")
+	// SourceKey is where GOMAXPROCS came from; see maxprocs.Source.
+	SourceKey = attribute.Key("automaxprocs.source")
+	// CGroupVersionKey is the version of cgroups the CPU quota was read
+	// from, 1 or 2; see maxprocs.QuotaInfo. It's absent unless SourceKey is
+	// "cgroup".
+	CGroupVersionKey = attribute.Key("automaxprocs.cgroup_version")
+)
+
+// Tracer returns a tracer of provider for Set, whose instrumentation scope
+// carries the version of the maxprocs package.
+func Tracer(provider trace.TracerProvider) trace.Tracer {
+	return provider.Tracer(ScopeName, trace.WithInstrumentationVersion(maxprocs.Version))
+}
+
+// Set sets GOMAXPROCS with maxprocs.SetFromEnvOrCGroup and records the
+// decision as a span started with tracer from ctx, e.g. a tracer returned
+// by Tracer.
+//
+// Set reports metrics with maxprocs.GaugeFunc, replacing any GaugeFunc
+// option in opts.
+func Set(ctx context.Context, tracer trace.Tracer, opts ...maxprocs.Option) (func(), error) {
+	_, span := tracer.Start(ctx, SpanName)
+	defer span.End()
+
+	undo, attrs, err := set(opts)
+	span.SetAttributes(attrs...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return undo, err
+}
+
+// SetWithEvent is like Set, but records the decision as an event on span
+// instead of starting a new span.
+func SetWithEvent(span trace.Span, opts ...maxprocs.Option) (func(), error) {
+	undo, attrs, err := set(opts)
+	span.AddEvent(EventName, trace.WithAttributes(attrs...))
+	if err != nil {
+		span.RecordError(err)
+	}
+	return undo, err
+}
+
+func set(opts []maxprocs.Option) (func(), []attribute.KeyValue, error) {
+	var attrs []attribute.KeyValue
+	gauges := maxprocs.GaugeFunc(func(name string, value float64) {
+		switch name {
+		case "automaxprocs_quota_cores":
+			attrs = append(attrs, QuotaKey.Float64(value))
+		case "automaxprocs_gomaxprocs":
+			attrs = append(attrs, ProcsKey.Int(int(value)))
+		}
+	})
+
+	undo, source, err := maxprocs.SetFromEnvOrCGroup(append(opts[:len(opts):len(opts)], gauges)...)
+	attrs = append(attrs, SourceKey.String(source.String()))
+	if source == maxprocs.SourceCGroup {
+		if info, err := maxprocs.Query(opts...); err == nil && info.CGroupVersion > 0 {
+			attrs = append(attrs, CGroupVersionKey.Int(info.CGroupVersion))
+		}
+	}
+	return undo, attrs, err
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2026 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package otelmaxprocs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+func newTracer() (*tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	recorder := tracetest.NewSpanRecorder()
+	return recorder, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+}
+
+func attrMap(attrs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(attrs))
+	for _, kv := range attrs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestSet(t *testing.T) {
+	if _, ok := os.LookupEnv("GOMAXPROCS"); ok {
+		t.Skip("GOMAXPROCS is set in the environment")
+	}
+
+	recorder, provider := newTracer()
+	undo, err := Set(context.Background(), provider.Tracer("test"), maxprocs.AssumeUncontained())
+	defer undo()
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, SpanName, spans[0].Name())
+	attrs := attrMap(spans[0].Attributes())
+	assert.Equal(t, int64(runtime.GOMAXPROCS(0)), attrs[ProcsKey].AsInt64())
+	assert.Equal(t, "numcpu", attrs[SourceKey].AsString())
+	assert.NotContains(t, attrs, QuotaKey, "no quota was detected")
+	assert.NotContains(t, attrs, CGroupVersionKey, "no quota was detected")
+}
+
+func TestSetQuota(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("CPU quotas are only supported on Linux")
+	}
+	if _, ok := os.LookupEnv("GOMAXPROCS"); ok {
+		t.Skip("GOMAXPROCS is set in the environment")
+	}
+
+	// Lay out a procfs whose mountinfo points the cpu controller at a
+	// directory holding a quota of 2 CPUs.
+	root := t.TempDir()
+	procFS := filepath.Join(root, "proc")
+	cpuDir := filepath.Join(root, "cgroup")
+	require.NoError(t, os.MkdirAll(filepath.Join(procFS, "self"), 0o755))
+	require.NoError(t, os.MkdirAll(cpuDir, 0o755))
+	files := map[string]string{
+		filepath.Join(procFS, "self", "mountinfo"): "31 23 0:24 / " + cpuDir + " rw,relatime shared:1 - cgroup cgroup rw,cpu\n",
+		filepath.Join(procFS, "self", "cgroup"):    "1:cpu:/\n",
+		filepath.Join(cpuDir, "cpu.cfs_quota_us"):  "200000\n",
+		filepath.Join(cpuDir, "cpu.cfs_period_us"): "100000\n",
+	}
+	for path, content := range files {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	recorder, provider := newTracer()
+	undo, err := Set(context.Background(), provider.Tracer("test"), maxprocs.ProcFS(procFS))
+	defer undo()
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	attrs := attrMap(spans[0].Attributes())
+	assert.Equal(t, 2.0, attrs[QuotaKey].AsFloat64())
+	assert.Equal(t, int64(2), attrs[ProcsKey].AsInt64())
+	assert.Equal(t, "cgroup", attrs[SourceKey].AsString())
+	assert.Equal(t, int64(1), attrs[CGroupVersionKey].AsInt64())
+}
+
+func TestSetError(t *testing.T) {
+	recorder, provider := newTracer()
+	undo, err := Set(context.Background(), provider.Tracer("test"), maxprocs.ProcFS(filepath.Join(t.TempDir(), "missing")))
+	defer undo()
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	if assert.Len(t, spans[0].Events(), 1) {
+		assert.Equal(t, "exception", spans[0].Events()[0].Name)
+	}
+}
+
+func TestSetWithEvent(t *testing.T) {
+	if _, ok := os.LookupEnv("GOMAXPROCS"); ok {
+		t.Skip("GOMAXPROCS is set in the environment")
+	}
+
+	recorder, provider := newTracer()
+	_, span := provider.Tracer("test").Start(context.Background(), "startup")
+	undo, err := SetWithEvent(span, maxprocs.AssumeUncontained())
+	defer undo()
+	require.NoError(t, err)
+	span.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "startup", spans[0].Name())
+	events := spans[0].Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, EventName, events[0].Name)
+	attrs := attrMap(events[0].Attributes)
+	assert.Equal(t, int64(runtime.GOMAXPROCS(0)), attrs[ProcsKey].AsInt64())
+	assert.Equal(t, "numcpu", attrs[SourceKey].AsString())
+}
+
+func TestTracer(t *testing.T) {
+	recorder, provider := newTracer()
+	_, span := Tracer(provider).Start(context.Background(), "startup")
+	span.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	scope := spans[0].InstrumentationScope()
+	assert.Equal(t, ScopeName, scope.Name)
+	assert.Equal(t, maxprocs.Version, scope.Version)
+}